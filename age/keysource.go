@@ -35,6 +35,22 @@ const (
 	// SopsAgeSshPrivateKeyFileEnv can be set as an environment variable pointing to
 	// a private SSH key file.
 	SopsAgeSshPrivateKeyFileEnv = "SOPS_AGE_SSH_PRIVATE_KEY_FILE"
+	// SopsAgePassphraseEnv can be set as an environment variable with the
+	// passphrase of scrypt recipients as value.
+	SopsAgePassphraseEnv = "SOPS_AGE_PASSPHRASE"
+	// SopsAgePassphraseCmdEnv can be set as an environment variable with a
+	// command to execute that returns the passphrase of scrypt recipients.
+	// The label of the recipient is passed to the command in the
+	// SopsAgePassphraseLabelEnv environment variable.
+	SopsAgePassphraseCmdEnv = "SOPS_AGE_PASSPHRASE_CMD"
+	// SopsAgePassphraseLabelEnv is the environment variable the label of a
+	// scrypt recipient is passed in to the SopsAgePassphraseCmdEnv command.
+	SopsAgePassphraseLabelEnv = "SOPS_AGE_PASSPHRASE_LABEL"
+	// ScryptRecipientPrefix is the prefix of recipients encrypting to a
+	// passphrase instead of a key, e.g. "scrypt:" or "scrypt:break-glass".
+	// The text after the prefix is an optional label used to tell several
+	// passphrases apart.
+	ScryptRecipientPrefix = "scrypt:"
 	// SopsAgeKeyUserConfigPath is the default age keys file path in
 	// getUserConfigDir().
 	SopsAgeKeyUserConfigPath = "sops/age/keys.txt"
@@ -145,6 +161,51 @@ func (i *ParsedIdentities) Import(identity ...string) error {
 	return nil
 }
 
+// ImportFile attempts to parse the identities in the file at the given path,
+// to then add them to itself. The file may be a (passphrase encrypted) age
+// identity file, or an SSH private key. It returns any reading or parsing
+// error.
+func (i *ParsedIdentities) ImportFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open age identity file: %w", err)
+	}
+	defer f.Close()
+
+	b := bufio.NewReader(f)
+	peeked, _ := b.Peek(len("-----BEGIN AGE"))
+	if bytes.HasPrefix(peeked, []byte("-----BEGIN")) && string(peeked) != "-----BEGIN AGE" {
+		identity, err := parseSSHIdentityFromPrivateKeyFile(path)
+		if err != nil {
+			return err
+		}
+		*i = append(*i, identity)
+		return nil
+	}
+
+	identities, err := unwrapIdentities(path, b)
+	if err != nil {
+		return err
+	}
+	*i = append(*i, identities...)
+	return nil
+}
+
+// ForRecipient returns the identities which may decrypt data encrypted to the
+// given recipient. X25519 identities are only returned if their recipient
+// matches. Identities for which the recipient can not be determined, e.g.
+// SSH, plugin or passphrase encrypted identities, are always returned.
+func (i ParsedIdentities) ForRecipient(recipient string) ParsedIdentities {
+	var identities ParsedIdentities
+	for _, identity := range i {
+		if x25519, ok := identity.(*age.X25519Identity); ok && x25519.Recipient().String() != recipient {
+			continue
+		}
+		identities = append(identities, identity)
+	}
+	return identities
+}
+
 // ApplyToMasterKey configures the ParsedIdentities on the provided key.
 func (i ParsedIdentities) ApplyToMasterKey(key *MasterKey) {
 	key.parsedIdentities = i
@@ -207,8 +268,13 @@ func (key *MasterKey) SetEncryptedDataKey(enc []byte) {
 }
 
 // Decrypt decrypts the EncryptedKey with the parsed or loaded identities, and
-// returns the result.
+// returns the result. The EncryptedKey of a scrypt recipient is decrypted with
+// its passphrase instead.
 func (key *MasterKey) Decrypt() ([]byte, error) {
+	if IsScryptRecipient(key.Recipient) {
+		return key.decryptWithPassphrase()
+	}
+
 	var errs errSet
 	if len(key.parsedIdentities) == 0 {
 		var ids ParsedIdentities
@@ -222,7 +288,7 @@ func (key *MasterKey) Decrypt() ([]byte, error) {
 
 	src := bytes.NewReader([]byte(key.EncryptedKey))
 	ar := armor.NewReader(src)
-	r, err := age.Decrypt(ar, key.parsedIdentities...)
+	r, err := age.Decrypt(ar, pluginIdentitiesLast(key.parsedIdentities)...)
 	if err != nil {
 		log.Info("Decryption failed")
 		var loadErrors string
@@ -242,6 +308,53 @@ func (key *MasterKey) Decrypt() ([]byte, error) {
 	return b.Bytes(), nil
 }
 
+// decryptWithPassphrase decrypts the EncryptedKey of a scrypt recipient with
+// the passphrase read by readPassphrase, and returns the result.
+func (key *MasterKey) decryptWithPassphrase() ([]byte, error) {
+	label := strings.TrimPrefix(key.Recipient, ScryptRecipientPrefix)
+	passphrase, err := readPassphrase(label, false)
+	if err != nil {
+		log.WithField("recipient", key.Recipient).Info("Decryption failed")
+		return nil, err
+	}
+	identity, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		log.WithField("recipient", key.Recipient).Info("Decryption failed")
+		return nil, fmt.Errorf("failed to create age scrypt identity: %w", err)
+	}
+
+	ar := armor.NewReader(strings.NewReader(key.EncryptedKey))
+	r, err := age.Decrypt(ar, identity)
+	if err != nil {
+		log.WithField("recipient", key.Recipient).Info("Decryption failed")
+		return nil, fmt.Errorf("failed to create reader for decrypting sops data key with age passphrase: %w", err)
+	}
+	var b bytes.Buffer
+	if _, err := io.Copy(&b, r); err != nil {
+		log.WithField("recipient", key.Recipient).Info("Decryption failed")
+		return nil, fmt.Errorf("failed to copy age decrypted data into bytes.Buffer: %w", err)
+	}
+	log.WithField("recipient", key.Recipient).Info("Decryption succeeded")
+	return b.Bytes(), nil
+}
+
+// pluginIdentitiesLast returns the identities with age plugin identities moved
+// to the end. Identities are tried in order, and plugins may require user
+// interaction (e.g. touching a hardware token) even when they cannot decrypt
+// the data key, so they are only invoked if no other identity succeeds.
+func pluginIdentitiesLast(identities []age.Identity) []age.Identity {
+	sorted := make([]age.Identity, 0, len(identities))
+	var plugins []age.Identity
+	for _, identity := range identities {
+		if _, ok := identity.(*plugin.Identity); ok {
+			plugins = append(plugins, identity)
+			continue
+		}
+		sorted = append(sorted, identity)
+	}
+	return append(sorted, plugins...)
+}
+
 // NeedsRotation returns whether the data key needs to be rotated or not.
 func (key *MasterKey) NeedsRotation() bool {
 	return false
@@ -377,10 +490,107 @@ func (key *MasterKey) loadIdentities() (ParsedIdentities, errSet) {
 	return identities, errs
 }
 
+// IsScryptRecipient returns whether the recipient is a passphrase (scrypt)
+// recipient, see ScryptRecipientPrefix.
+func IsScryptRecipient(recipient string) bool {
+	return strings.HasPrefix(recipient, ScryptRecipientPrefix)
+}
+
+// scryptRecipient is an age.Recipient encrypting to a passphrase. The
+// passphrase is only read once the data key is encrypted, so that parsing a
+// configuration does not prompt for it.
+type scryptRecipient struct {
+	label string
+}
+
+// Wrap implements age.Recipient.
+func (r *scryptRecipient) Wrap(fileKey []byte) ([]*age.Stanza, error) {
+	stanzas, _, err := r.WrapWithLabels(fileKey)
+	return stanzas, err
+}
+
+// WrapWithLabels implements age.RecipientWithLabels, as age.ScryptRecipient
+// must not be mixed with other recipients.
+func (r *scryptRecipient) WrapWithLabels(fileKey []byte) ([]*age.Stanza, []string, error) {
+	passphrase, err := readPassphrase(r.label, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	recipient, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+	return recipient.WrapWithLabels(fileKey)
+}
+
+// String returns the recipient as configured.
+func (r *scryptRecipient) String() string {
+	return ScryptRecipientPrefix + r.label
+}
+
+// readPassphrase returns the passphrase of the scrypt recipient with the given
+// label from the SopsAgePassphraseEnv environment variable, the output of the
+// SopsAgePassphraseCmdEnv command, or by prompting for it. When confirm is
+// set, the prompted passphrase must be entered twice.
+func readPassphrase(label string, confirm bool) (string, error) {
+	if passphrase, ok := os.LookupEnv(SopsAgePassphraseEnv); ok && passphrase != "" {
+		return passphrase, nil
+	}
+	if passphraseCmd, ok := os.LookupEnv(SopsAgePassphraseCmdEnv); ok && passphraseCmd != "" {
+		args, err := shlex.Split(passphraseCmd)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse command %s from %s: %w", passphraseCmd, SopsAgePassphraseCmdEnv, err)
+		}
+		if len(args) == 0 {
+			return "", fmt.Errorf("command from %s is empty", SopsAgePassphraseCmdEnv)
+		}
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Env = append(os.Environ(), SopsAgePassphraseLabelEnv+"="+label)
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("failed to execute command %s from %s: %w", passphraseCmd, SopsAgePassphraseCmdEnv, err)
+		}
+		passphrase := strings.TrimRight(string(out), "\r\n")
+		if passphrase == "" {
+			return "", fmt.Errorf("command %s from %s returned an empty passphrase", passphraseCmd, SopsAgePassphraseCmdEnv)
+		}
+		return passphrase, nil
+	}
+
+	prompt := "Enter age passphrase:"
+	if label != "" {
+		prompt = fmt.Sprintf("Enter age passphrase for %q:", label)
+	}
+	passphrase, err := readSecret(prompt)
+	if err != nil {
+		return "", fmt.Errorf("could not read age passphrase: %w", err)
+	}
+	if len(passphrase) == 0 {
+		return "", fmt.Errorf("age passphrase must not be empty")
+	}
+	if confirm {
+		confirmation, err := readSecret("Confirm age passphrase:")
+		if err != nil {
+			return "", fmt.Errorf("could not read age passphrase: %w", err)
+		}
+		if !bytes.Equal(passphrase, confirmation) {
+			return "", fmt.Errorf("age passphrases didn't match")
+		}
+	}
+	return string(passphrase), nil
+}
+
 // parseRecipient attempts to parse a string containing an encoded age public
-// key or a public ssh key.
+// key, a public ssh key, or a scrypt recipient.
 func parseRecipient(recipient string) (age.Recipient, error) {
 	switch {
+	case IsScryptRecipient(recipient):
+		label := strings.TrimPrefix(recipient, ScryptRecipientPrefix)
+		if strings.ContainsAny(label, ", \t\n") {
+			return nil, fmt.Errorf("invalid scrypt recipient label %q: must not contain commas or whitespace", label)
+		}
+		return &scryptRecipient{label: label}, nil
 	case strings.HasPrefix(recipient, "age1") && strings.Count(recipient, "1") > 1:
 		parsedRecipient, err := plugin.NewRecipient(recipient, pluginTerminalUI)
 		if err != nil {
@@ -434,10 +644,14 @@ func parseIdentities(r io.Reader) (ParsedIdentities, error) {
 func parseIdentity(s string) (age.Identity, error) {
 	switch {
 	case strings.HasPrefix(s, "AGE-PLUGIN-"):
-		return plugin.NewIdentity(s, pluginTerminalUI)
+		identity, err := plugin.NewIdentity(s, pluginTerminalUI)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse input as age plugin identity: %w", err)
+		}
+		return identity, nil
 	case strings.HasPrefix(s, "AGE-SECRET-KEY-1"):
 		return age.ParseX25519Identity(s)
 	default:
-		return nil, fmt.Errorf("unknown identity type")
+		return nil, fmt.Errorf("unknown identity type, expected an AGE-SECRET-KEY-1 or AGE-PLUGIN- identity")
 	}
 }
@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 const (
@@ -154,6 +155,73 @@ func TestParsedIdentities_Import(t *testing.T) {
 	assert.Len(t, i, 2)
 }
 
+func TestParsedIdentities_ImportFile(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	t.Run("identity file", func(t *testing.T) {
+		path := filepath.Join(tmpDir, "keys.txt")
+		assert.NoError(t, os.WriteFile(path, []byte("# comment\n"+mockIdentity+"\n"+mockOtherIdentity+"\n"), 0o600))
+
+		i := make(ParsedIdentities, 0)
+		assert.NoError(t, i.ImportFile(path))
+		assert.Len(t, i, 2)
+	})
+
+	t.Run("encrypted identity file", func(t *testing.T) {
+		path := filepath.Join(tmpDir, "keys.age")
+		assert.NoError(t, os.WriteFile(path, []byte(mockEncryptedIdentity), 0o600))
+
+		i := make(ParsedIdentities, 0)
+		assert.NoError(t, i.ImportFile(path))
+		assert.Len(t, i, 1)
+		assert.IsType(t, &EncryptedIdentity{}, i[0])
+	})
+
+	t.Run("SSH private key", func(t *testing.T) {
+		path := filepath.Join(tmpDir, "id_ed25519")
+		assert.NoError(t, os.WriteFile(path, []byte(mockSshIdentity), 0o600))
+
+		i := make(ParsedIdentities, 0)
+		assert.NoError(t, i.ImportFile(path))
+		assert.Len(t, i, 1)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		i := make(ParsedIdentities, 0)
+		assert.Error(t, i.ImportFile(filepath.Join(tmpDir, "missing")))
+		assert.Len(t, i, 0)
+	})
+}
+
+func TestParsedIdentities_ForRecipient(t *testing.T) {
+	i := make(ParsedIdentities, 0)
+	assert.NoError(t, i.Import(mockIdentity, mockOtherIdentity))
+	sshIdentity, err := parseSSHIdentityFromPrivateKeyFile(writeTempFile(t, mockSshIdentity))
+	assert.NoError(t, err)
+	i = append(i, sshIdentity)
+
+	got := i.ForRecipient(mockRecipient)
+	assert.Len(t, got, 2)
+	assert.Equal(t, i[0], got[0])
+	assert.Equal(t, sshIdentity, got[1])
+
+	// Recipient of mockOtherIdentity.
+	got = i.ForRecipient("age1afnrdrkyvy0sl5e08t9ffs4c7g5e5npc8luf7hjc77syewg5gajsls4r90")
+	assert.Len(t, got, 2)
+	assert.Equal(t, i[1], got[0])
+
+	got = i.ForRecipient("age1tmaae3ld5vpevmsh5yacsauzx8jetg300mpvc4ugp5zr5l6ssq9sla97ep")
+	assert.Len(t, got, 1)
+	assert.Equal(t, sshIdentity, got[0])
+}
+
+func writeTempFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "file")
+	assert.NoError(t, os.WriteFile(path, []byte(contents), 0o600))
+	return path
+}
+
 func TestParsedIdentities_ApplyToMasterKey(t *testing.T) {
 	i := make(ParsedIdentities, 0)
 	assert.NoError(t, i.Import(mockIdentity, mockOtherIdentity))
@@ -595,3 +663,70 @@ func TestMasterKey_Identities_Passphrase(t *testing.T) {
 		assert.Nil(t, got)
 	})
 }
+
+func TestMasterKey_Scrypt(t *testing.T) {
+	dataKey := []byte("abcdefghijklmnopqrstuvwxyz123456")
+	t.Setenv(SopsAgePassphraseEnv, "")
+	t.Setenv(SopsAgePassphraseCmdEnv, "")
+
+	t.Run("parse without prompting", func(t *testing.T) {
+		keys, err := MasterKeysFromRecipients("scrypt:, scrypt:break-glass")
+		require.NoError(t, err)
+		require.Len(t, keys, 2)
+		assert.Equal(t, "scrypt:", keys[0].Recipient)
+		assert.Equal(t, "scrypt:break-glass", keys[1].Recipient)
+
+		_, err = MasterKeyFromRecipient("scrypt:break glass")
+		assert.ErrorContains(t, err, "invalid scrypt recipient label")
+	})
+
+	t.Run(SopsAgePassphraseEnv, func(t *testing.T) {
+		t.Setenv(SopsAgePassphraseEnv, "correct horse battery staple")
+		key, err := MasterKeyFromRecipient("scrypt:break-glass")
+		require.NoError(t, err)
+		require.NoError(t, key.Encrypt(dataKey))
+		assert.Contains(t, key.EncryptedKey, "-----BEGIN AGE ENCRYPTED FILE-----")
+
+		// X25519 identities are not used for scrypt recipients.
+		t.Setenv(SopsAgeKeyEnv, mockIdentity)
+		decryptKey := &MasterKey{Recipient: key.Recipient, EncryptedKey: key.EncryptedKey}
+		got, err := decryptKey.Decrypt()
+		require.NoError(t, err)
+		assert.Equal(t, dataKey, got)
+
+		t.Setenv(SopsAgePassphraseEnv, "wrong")
+		_, err = decryptKey.Decrypt()
+		assert.ErrorContains(t, err, "failed to create reader for decrypting sops data key with age passphrase")
+	})
+
+	t.Run(SopsAgePassphraseCmdEnv, func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("test requires a POSIX shell")
+		}
+		t.Setenv(SopsAgePassphraseCmdEnv, `sh -c 'echo "passphrase for $SOPS_AGE_PASSPHRASE_LABEL"'`)
+		passphrase, err := readPassphrase("break-glass", true)
+		require.NoError(t, err)
+		assert.Equal(t, "passphrase for break-glass", passphrase)
+
+		t.Setenv(SopsAgePassphraseCmdEnv, "false")
+		_, err = readPassphrase("", false)
+		assert.ErrorContains(t, err, "failed to execute command false")
+
+		t.Setenv(SopsAgePassphraseCmdEnv, "  ")
+		_, err = readPassphrase("", false)
+		assert.ErrorContains(t, err, "is empty")
+	})
+
+	t.Run("prompt", func(t *testing.T) {
+		testOnlyAgePassword = "prompted passphrase"
+		t.Cleanup(func() { testOnlyAgePassword = "" })
+		key, err := MasterKeyFromRecipient("scrypt:")
+		require.NoError(t, err)
+		require.NoError(t, key.Encrypt(dataKey))
+
+		decryptKey := &MasterKey{Recipient: key.Recipient, EncryptedKey: key.EncryptedKey}
+		got, err := decryptKey.Decrypt()
+		require.NoError(t, err)
+		assert.Equal(t, dataKey, got)
+	})
+}
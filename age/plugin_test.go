@@ -0,0 +1,282 @@
+package age
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	// stubPluginName is the name of the age plugin implemented by the test
+	// binary, see TestMain.
+	stubPluginName = "sopsstub"
+	// stubPluginPIN is the PIN the stub plugin requests for identities
+	// created with stubIdentity(true).
+	stubPluginPIN = "123456"
+)
+
+// TestMain runs the test binary as the stub age plugin when it is invoked as
+// age-plugin-sopsstub, see installStubPlugin.
+func TestMain(m *testing.M) {
+	if filepath.Base(os.Args[0]) == "age-plugin-"+stubPluginName {
+		if err := runStubPlugin(os.Args[1], os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// installStubPlugin makes the test binary available as age-plugin-sopsstub
+// in PATH.
+func installStubPlugin(t *testing.T) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("stub age plugin is not supported on Windows")
+	}
+	exe, err := os.Executable()
+	require.NoError(t, err)
+	dir := t.TempDir()
+	require.NoError(t, os.Symlink(exe, filepath.Join(dir, "age-plugin-"+stubPluginName)))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func stubRecipient() string {
+	return plugin.EncodeRecipient(stubPluginName, []byte("recipient"))
+}
+
+func stubIdentity(pin bool) string {
+	if pin {
+		return plugin.EncodeIdentity(stubPluginName, []byte("pin"))
+	}
+	return plugin.EncodeIdentity(stubPluginName, []byte("identity"))
+}
+
+// stubStanza is a stanza of the age plugin protocol.
+type stubStanza struct {
+	Type string
+	Args []string
+	Body []byte
+}
+
+func readStubStanza(r *bufio.Reader) (stubStanza, error) {
+	header, err := r.ReadString('\n')
+	if err != nil {
+		return stubStanza{}, err
+	}
+	fields := strings.Fields(strings.TrimPrefix(header, "-> "))
+	if len(fields) == 0 {
+		return stubStanza{}, fmt.Errorf("malformed stanza header %q", header)
+	}
+	s := stubStanza{Type: fields[0], Args: fields[1:]}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return stubStanza{}, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		b, err := base64.RawStdEncoding.DecodeString(line)
+		if err != nil {
+			return stubStanza{}, err
+		}
+		s.Body = append(s.Body, b...)
+		if len(line) < 64 {
+			return s, nil
+		}
+	}
+}
+
+func writeStubStanza(w io.Writer, typ string, body []byte, args ...string) error {
+	header := strings.Join(append([]string{"->", typ}, args...), " ")
+	encoded := base64.RawStdEncoding.EncodeToString(body)
+	var lines []string
+	for len(encoded) >= 64 {
+		lines = append(lines, encoded[:64])
+		encoded = encoded[64:]
+	}
+	lines = append(lines, encoded)
+	_, err := fmt.Fprintf(w, "%s\n%s\n", header, strings.Join(lines, "\n"))
+	return err
+}
+
+// runStubPlugin implements the recipient-v1 and identity-v1 state machines of
+// the age plugin protocol. It "wraps" file keys by storing them in plaintext
+// in a stanza of its own type, which its identities then unwrap.
+func runStubPlugin(stateMachine string, in io.Reader, out io.Writer) error {
+	r := bufio.NewReader(in)
+	var commands []stubStanza
+	for {
+		s, err := readStubStanza(r)
+		if err != nil {
+			return err
+		}
+		if s.Type == "done" {
+			break
+		}
+		commands = append(commands, s)
+	}
+	expectOK := func() error {
+		s, err := readStubStanza(r)
+		if err != nil {
+			return err
+		}
+		if s.Type != "ok" {
+			return fmt.Errorf("expected ok, got %s", s.Type)
+		}
+		return nil
+	}
+
+	switch stateMachine {
+	case "--age-plugin=recipient-v1":
+		for _, c := range commands {
+			if c.Type != "wrap-file-key" {
+				continue
+			}
+			if err := writeStubStanza(out, "recipient-stanza", c.Body, "0", stubPluginName); err != nil {
+				return err
+			}
+			if err := expectOK(); err != nil {
+				return err
+			}
+		}
+	case "--age-plugin=identity-v1":
+		var identity string
+		for _, c := range commands {
+			if c.Type == "add-identity" {
+				identity = c.Args[0]
+			}
+		}
+		_, data, err := plugin.ParseIdentity(identity)
+		if err != nil {
+			return err
+		}
+		if string(data) == "pin" {
+			if err := writeStubStanza(out, "request-secret", []byte("Enter PIN:")); err != nil {
+				return err
+			}
+			s, err := readStubStanza(r)
+			if err != nil {
+				return err
+			}
+			if s.Type != "ok" || string(s.Body) != stubPluginPIN {
+				if err := writeStubStanza(out, "error", []byte("wrong PIN"), "internal"); err != nil {
+					return err
+				}
+				if err := expectOK(); err != nil {
+					return err
+				}
+				break
+			}
+		}
+		for _, c := range commands {
+			if c.Type != "recipient-stanza" || len(c.Args) < 2 || c.Args[1] != stubPluginName {
+				continue
+			}
+			if err := writeStubStanza(out, "msg", []byte("touch the stub")); err != nil {
+				return err
+			}
+			if err := expectOK(); err != nil {
+				return err
+			}
+			if err := writeStubStanza(out, "file-key", c.Body, "0"); err != nil {
+				return err
+			}
+			if err := expectOK(); err != nil {
+				return err
+			}
+			break
+		}
+	default:
+		return fmt.Errorf("unknown state machine %q", stateMachine)
+	}
+	return writeStubStanza(out, "done", nil)
+}
+
+func TestMasterKey_Plugin(t *testing.T) {
+	installStubPlugin(t)
+	dataKey := []byte("abcdefghijklmnopqrstuvwxyz123456")
+
+	key, err := MasterKeyFromRecipient(stubRecipient())
+	require.NoError(t, err)
+	require.NoError(t, key.Encrypt(dataKey))
+	assert.NotEmpty(t, key.EncryptedKey)
+
+	t.Run("identity from environment", func(t *testing.T) {
+		t.Setenv(SopsAgeKeyEnv, stubIdentity(false))
+		t.Setenv(SopsAgeSshPrivateKeyFileEnv, filepath.Join(t.TempDir(), "nonexistent"))
+		decryptKey := &MasterKey{Recipient: key.Recipient, EncryptedKey: key.EncryptedKey}
+		got, err := decryptKey.Decrypt()
+		require.NoError(t, err)
+		assert.Equal(t, dataKey, got)
+	})
+
+	t.Run("prompt", func(t *testing.T) {
+		var ids ParsedIdentities
+		require.NoError(t, ids.Import(stubIdentity(true)))
+
+		testOnlyAgePassword = stubPluginPIN
+		t.Cleanup(func() { testOnlyAgePassword = "" })
+		decryptKey := &MasterKey{Recipient: key.Recipient, EncryptedKey: key.EncryptedKey}
+		ids.ApplyToMasterKey(decryptKey)
+		got, err := decryptKey.Decrypt()
+		require.NoError(t, err)
+		assert.Equal(t, dataKey, got)
+
+		testOnlyAgePassword = "000000"
+		decryptKey = &MasterKey{Recipient: key.Recipient, EncryptedKey: key.EncryptedKey}
+		ids.ApplyToMasterKey(decryptKey)
+		_, err = decryptKey.Decrypt()
+		assert.ErrorContains(t, err, "wrong PIN")
+	})
+
+	t.Run("missing plugin", func(t *testing.T) {
+		recipient := plugin.EncodeRecipient("sopsmissing", []byte("recipient"))
+		key, err := MasterKeyFromRecipient(recipient)
+		require.NoError(t, err)
+		assert.ErrorContains(t, key.Encrypt(dataKey), "age-plugin-sopsmissing")
+	})
+}
+
+func TestParseIdentity_Plugin(t *testing.T) {
+	identity, err := parseIdentity(stubIdentity(false))
+	require.NoError(t, err)
+	assert.IsType(t, &plugin.Identity{}, identity)
+
+	_, err = parseIdentity("AGE-PLUGIN-1INVALID")
+	assert.ErrorContains(t, err, "failed to parse input as age plugin identity")
+
+	_, err = parseIdentity("not an identity")
+	assert.ErrorContains(t, err, "unknown identity type")
+}
+
+func TestPluginIdentitiesLast(t *testing.T) {
+	x25519, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	pluginIdentity, err := plugin.NewIdentity(stubIdentity(false), pluginTerminalUI)
+	require.NoError(t, err)
+
+	sorted := pluginIdentitiesLast([]age.Identity{pluginIdentity, x25519})
+	assert.Equal(t, []age.Identity{x25519, pluginIdentity}, sorted)
+}
+
+func TestReadStubStanza(t *testing.T) {
+	body := bytes.Repeat([]byte("x"), 48)
+	var buf bytes.Buffer
+	require.NoError(t, writeStubStanza(&buf, "test", body, "a", "b"))
+	s, err := readStubStanza(bufio.NewReader(&buf))
+	require.NoError(t, err)
+	assert.Equal(t, stubStanza{Type: "test", Args: []string{"a", "b"}, Body: body}, s)
+}
@@ -11,12 +11,14 @@
 package age
 
 import (
+	"bufio"
 	"errors"
 	"filippo.io/age/plugin"
 	"fmt"
 	"io"
 	"os"
 	"runtime"
+	"strings"
 	"testing"
 
 	"golang.org/x/term"
@@ -94,6 +96,28 @@ func readSecret(prompt string) (s []byte, err error) {
 	return
 }
 
+// readPublic reads a line from the terminal, echoing the input. The prompt is
+// ephemeral.
+func readPublic(prompt string) (s []byte, err error) {
+	if testing.Testing() {
+		if testOnlyAgePassword != "" {
+			return []byte(testOnlyAgePassword), nil
+		}
+	}
+
+	err = withTerminal(func(in, out *os.File) error {
+		fmt.Fprintf(out, "%s ", prompt)
+		defer clearLine(out)
+		line, err := bufio.NewReader(in).ReadString('\n')
+		if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+			return err
+		}
+		s = []byte(strings.TrimRight(line, "\r\n"))
+		return nil
+	})
+	return
+}
+
 // readCharacter reads a single character from the terminal with no echo. The
 // prompt is ephemeral.
 func readCharacter(prompt string) (c byte, err error) {
@@ -123,17 +147,21 @@ var pluginTerminalUI = &plugin.ClientUI{
 		printf("%s plugin: %s", name, message)
 		return nil
 	},
-	RequestValue: func(name, message string, _ bool) (s string, err error) {
+	RequestValue: func(name, message string, secret bool) (s string, err error) {
 		defer func() {
 			if err != nil {
 				warningf("could not read value for age-plugin-%s: %v", name, err)
 			}
 		}()
-		secret, err := readSecret(message)
+		read := readSecret
+		if !secret {
+			read = readPublic
+		}
+		value, err := read(message)
 		if err != nil {
 			return "", err
 		}
-		return string(secret), nil
+		return string(value), nil
 	},
 	Confirm: func(name, message, yes, no string) (choseYes bool, err error) {
 		defer func() {
@@ -105,6 +105,19 @@ type RotateEvent struct {
 	File string
 }
 
+// KeyServiceEvent contains fields relevant to a request received by a key
+// service server
+type KeyServiceEvent struct {
+	// Action is the requested operation, "encrypt" or "decrypt"
+	Action string
+	// Key describes the master key the request was made for
+	Key string
+	// Transport is the protocol the request was received over, e.g. "grpc"
+	Transport string
+	// Error is the reason the request failed, or empty if it succeeded
+	Error string
+}
+
 // PostgresAuditor is a Postgres SQL DB implementation of the Auditor interface.
 // It persists the audit event by writing a row to the 'audit_event' table.
 // Errors with writing to the database will output a log message and the
@@ -163,6 +176,20 @@ func (p *PostgresAuditor) Handle(event interface{}) {
 		if err != nil {
 			log.Fatalf("Failed to insert audit record: %s", err)
 		}
+	case KeyServiceEvent:
+		// Save the event to the database. Key service requests are not
+		// made for a file, so the key is recorded instead.
+		action := "keyservice-" + event.Action
+		if event.Error != "" {
+			action += "-failed"
+		}
+		log.WithField("key", event.Key).
+			WithField("transport", event.Transport).
+			Debug("Saving key service event to database")
+		_, err = p.DB.Exec("INSERT INTO audit_event (action, username, file) VALUES ($1, $2, $3)", action, u.Username, event.Key)
+		if err != nil {
+			log.Fatalf("Failed to insert audit record: %s", err)
+		}
 	default:
 		log.WithField("type", fmt.Sprintf("%T", event)).
 			Info("Received unknown event")
@@ -41,8 +41,8 @@ func init() {
 // MasterKey is an Azure Key Vault Key used to Encrypt and Decrypt SOPS'
 // data key.
 type MasterKey struct {
-	// VaultURL of the Azure Key Vault. For example:
-	// "https://myvault.vault.azure.net/".
+	// VaultURL of the Azure Key Vault or Managed HSM. For example:
+	// "https://myvault.vault.azure.net/" or "https://myhsm.managedhsm.azure.net/".
 	VaultURL string
 	// Name of the Azure Key Vault key in the VaultURL.
 	Name string
@@ -75,13 +75,16 @@ func NewMasterKey(vaultURL string, keyName string, keyVersion string) *MasterKey
 	}
 }
 
-// NewMasterKeyFromURL takes an Azure Key Vault key URL, and returns a new
-// MasterKey. The URL format is {vaultUrl}/keys/{keyName}/{keyVersion}.
+// NewMasterKeyFromURL takes an Azure Key Vault or Managed HSM key URL, and
+// returns a new MasterKey. The URL format is {vaultUrl}/keys/{keyName}/{keyVersion},
+// where the version may be omitted to use the latest version of the key at
+// encryption time. The version used is then recorded in the key, so that data
+// keys can still be decrypted after the key is rotated.
 func NewMasterKeyFromURL(url string) (*MasterKey, error) {
 	url = strings.TrimSpace(url)
-	re := regexp.MustCompile("^(https://[^/]+)/keys/([^/]+)/([^/]+)$")
+	re := regexp.MustCompile("^(https://[^/]+)/keys/([^/]+)(?:/([^/]*))?/?$")
 	parts := re.FindStringSubmatch(url)
-	if len(parts) < 3 {
+	if parts == nil {
 		return nil, fmt.Errorf("could not parse %q into a valid Azure Key Vault MasterKey", url)
 	}
 	return NewMasterKey(parts[1], parts[2], parts[3]), nil
@@ -160,16 +163,35 @@ func (key *MasterKey) EncryptContext(ctx context.Context, dataKey []byte) error
 		return fmt.Errorf("failed to construct Azure Key Vault client to encrypt data: %w", err)
 	}
 
-	resp, err := c.Encrypt(ctx, key.Name, key.Version, azkeys.KeyOperationParameters{
-		Algorithm: to.Ptr(azkeys.EncryptionAlgorithmRSAOAEP256),
-		Value:     dataKey,
-	}, nil)
+	var encryptedKey []byte
+	var version string
+	if localEncrypt() {
+		encryptedKey, version, err = key.encryptLocally(ctx, c, dataKey)
+	} else {
+		var resp azkeys.EncryptResponse
+		resp, err = c.Encrypt(ctx, key.Name, key.Version, azkeys.KeyOperationParameters{
+			Algorithm: to.Ptr(azkeys.EncryptionAlgorithmRSAOAEP256),
+			Value:     dataKey,
+		}, nil)
+		encryptedKey = resp.KeyOperationResult.Result
+		if resp.KID != nil {
+			version = resp.KID.Version()
+		}
+	}
+	if err == nil && key.Version == "" && version == "" {
+		err = fmt.Errorf("no key version returned")
+	}
 	if err != nil {
 		log.WithFields(logrus.Fields{"key": key.Name, "version": key.Version}).Info("Encryption failed")
 		return fmt.Errorf("failed to encrypt sops data key with Azure Key Vault key '%s': %w", key.ToString(), err)
 	}
+	if key.Version == "" {
+		// Decrypting without a version uses the latest version of the key,
+		// which no longer works once the key is rotated.
+		key.Version = version
+	}
 
-	encodedEncryptedKey := base64.RawURLEncoding.EncodeToString(resp.KeyOperationResult.Result)
+	encodedEncryptedKey := base64.RawURLEncoding.EncodeToString(encryptedKey)
 	key.SetEncryptedDataKey([]byte(encodedEncryptedKey))
 	log.WithFields(logrus.Fields{"key": key.Name, "version": key.Version}).Info("Encryption succeeded")
 	return nil
@@ -185,6 +207,12 @@ func (key *MasterKey) SetEncryptedDataKey(enc []byte) {
 	key.EncryptedKey = string(enc)
 }
 
+// SetKeyVersion sets the version of the key the data key was encrypted with,
+// as returned by a key service.
+func (key *MasterKey) SetKeyVersion(version string) {
+	key.Version = version
+}
+
 // EncryptIfNeeded encrypts the provided SOPS data key, if it has not been
 // encrypted yet.
 func (key *MasterKey) EncryptIfNeeded(dataKey []byte) error {
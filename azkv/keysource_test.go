@@ -28,11 +28,33 @@ func TestNewMasterKeyFromURL(t *testing.T) {
 				Version:  "a2a690a4fcc04166b739da342a912c90",
 			},
 		},
+		{
+			name: "Managed HSM URL",
+			url:  "https://test.managedhsm.azure.net/keys/test-key/a2a690a4fcc04166b739da342a912c90",
+			expectKey: MasterKey{
+				VaultURL: "https://test.managedhsm.azure.net",
+				Name:     "test-key",
+				Version:  "a2a690a4fcc04166b739da342a912c90",
+			},
+		},
+		{
+			name: "URL without version",
+			url:  "https://test.managedhsm.azure.net/keys/test-key",
+			expectKey: MasterKey{
+				VaultURL: "https://test.managedhsm.azure.net",
+				Name:     "test-key",
+			},
+		},
 		{
 			name:      "malformed URL",
 			url:       "https://test.vault.azure.net/no-keys-here/test-key/a2a690a4fcc04166b739da342a912c90",
 			expectErr: true,
 		},
+		{
+			name:      "URL with trailing path",
+			url:       "https://test.vault.azure.net/keys/test-key/a2a690a4fcc04166b739da342a912c90/encrypt",
+			expectErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -0,0 +1,104 @@
+package azkv
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+)
+
+const (
+	// SopsAzureKeyVaultLocalEncryptEnv can be set to true to encrypt data keys
+	// locally with the public key of the Azure Key Vault key, instead of with
+	// the remote encrypt operation. Encrypting then only requires the "get"
+	// key permission, while decrypting is unchanged.
+	SopsAzureKeyVaultLocalEncryptEnv = "SOPS_AZURE_KEYVAULT_LOCAL_ENCRYPT"
+)
+
+var (
+	// publicKeyCache caches the public keys fetched for local encryption for
+	// the lifetime of the process, keyed by the key URL.
+	publicKeyCache   = map[string]publicKey{}
+	publicKeyCacheMu sync.Mutex
+)
+
+// publicKey is the RSA public key of a version of an Azure Key Vault key.
+type publicKey struct {
+	key     *rsa.PublicKey
+	version string
+}
+
+// localEncrypt returns whether data keys should be encrypted locally, as
+// configured with SopsAzureKeyVaultLocalEncryptEnv.
+func localEncrypt() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv(SopsAzureKeyVaultLocalEncryptEnv))
+	return enabled
+}
+
+// encryptLocally encrypts the data key with the public key of the Azure Key
+// Vault key using RSA-OAEP-256, which produces the same result as the remote
+// encrypt operation, and can be decrypted by it. It also returns the version of
+// the key used.
+func (key *MasterKey) encryptLocally(ctx context.Context, c *azkeys.Client, dataKey []byte) ([]byte, string, error) {
+	pub, err := key.publicKey(ctx, c)
+	if err != nil {
+		return nil, "", err
+	}
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub.key, dataKey, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	return encryptedKey, pub.version, nil
+}
+
+// publicKey returns the RSA public key of the Azure Key Vault key, fetching
+// it once per process.
+func (key *MasterKey) publicKey(ctx context.Context, c *azkeys.Client) (publicKey, error) {
+	publicKeyCacheMu.Lock()
+	defer publicKeyCacheMu.Unlock()
+	if pub, ok := publicKeyCache[key.ToString()]; ok {
+		return pub, nil
+	}
+	resp, err := c.GetKey(ctx, key.Name, key.Version, nil)
+	if err != nil {
+		return publicKey{}, fmt.Errorf("failed to get public key of Azure Key Vault key '%s': %w", key.ToString(), err)
+	}
+	rsaKey, err := rsaPublicKey(resp.Key)
+	if err != nil {
+		return publicKey{}, fmt.Errorf("invalid public key of Azure Key Vault key '%s': %w", key.ToString(), err)
+	}
+	pub := publicKey{key: rsaKey, version: key.Version}
+	if resp.Key.KID != nil {
+		pub.version = resp.Key.KID.Version()
+	}
+	publicKeyCache[key.ToString()] = pub
+	return pub, nil
+}
+
+// rsaPublicKey returns the RSA public key of a JSON web key, which is of type
+// RSA for Key Vault software keys, or RSA-HSM for HSM-backed and Managed HSM
+// keys.
+func rsaPublicKey(jwk *azkeys.JSONWebKey) (*rsa.PublicKey, error) {
+	if jwk == nil || jwk.Kty == nil {
+		return nil, fmt.Errorf("no key returned")
+	}
+	if *jwk.Kty != azkeys.KeyTypeRSA && *jwk.Kty != azkeys.KeyTypeRSAHSM {
+		return nil, fmt.Errorf("key type %s is not supported, must be %s or %s", *jwk.Kty, azkeys.KeyTypeRSA, azkeys.KeyTypeRSAHSM)
+	}
+	if len(jwk.N) == 0 || len(jwk.E) == 0 {
+		return nil, fmt.Errorf("missing modulus or exponent")
+	}
+	e := new(big.Int).SetBytes(jwk.E)
+	if !e.IsInt64() || e.Int64() > int64(^uint32(0)>>1) {
+		return nil, fmt.Errorf("invalid exponent %s", base64.RawURLEncoding.EncodeToString(jwk.E))
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(jwk.N), E: int(e.Int64())}, nil
+}
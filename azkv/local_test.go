@@ -0,0 +1,224 @@
+package azkv
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCredential is an azcore.TokenCredential returning a static token.
+type fakeCredential struct{}
+
+func (fakeCredential) GetToken(context.Context, policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// fakeKeyVault is an httptest stand-in for the Azure Key Vault keys API,
+// serving the versions of a single RSA key.
+type fakeKeyVault struct {
+	*httptest.Server
+	kty azkeys.KeyType
+
+	mu       sync.Mutex
+	versions map[string]*rsa.PrivateKey
+	// latest is the version used when a request does not specify one.
+	latest string
+	// ops records the key operations performed, e.g. "get" or "encrypt".
+	ops []string
+}
+
+func newFakeKeyVault(t *testing.T, kty azkeys.KeyType) *fakeKeyVault {
+	f := &fakeKeyVault{kty: kty, versions: map[string]*rsa.PrivateKey{}}
+	f.rotate(t)
+	f.Server = httptest.NewTLSServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// rotate creates a new version of the key, which becomes the latest.
+func (f *fakeKeyVault) rotate(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.latest = fmt.Sprintf("version%d", len(f.versions)+1)
+	f.versions[f.latest] = priv
+}
+
+func (f *fakeKeyVault) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") == "" {
+		// Challenge the client to authenticate, as Key Vault does.
+		w.Header().Set("WWW-Authenticate", `Bearer authorization="https://login.microsoftonline.com/tenant", resource="https://vault.azure.net"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	// The path is /keys/{name}/{version}/{operation}, the version being
+	// omitted for the latest one, and the operation for getting the key.
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	version, op := "", "get"
+	if n := len(parts); n > 2 && (parts[n-1] == "encrypt" || parts[n-1] == "decrypt") {
+		op = parts[n-1]
+		parts = parts[:n-1]
+	}
+	if len(parts) > 2 {
+		version = parts[2]
+	}
+	f.mu.Lock()
+	f.ops = append(f.ops, op)
+	if version == "" {
+		version = f.latest
+	}
+	priv, ok := f.versions[version]
+	f.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	kid := f.URL + "/keys/test-key/" + version
+	var body interface{}
+	switch op {
+	case "get":
+		body = map[string]interface{}{"key": map[string]interface{}{
+			"kid": kid,
+			"kty": f.kty,
+			"n":   base64.RawURLEncoding.EncodeToString(priv.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(priv.E)).Bytes()),
+		}}
+	case "encrypt", "decrypt":
+		var params struct {
+			Value string `json:"value"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		value, _ := base64.RawURLEncoding.DecodeString(params.Value)
+		var result []byte
+		var err error
+		if op == "encrypt" {
+			result, err = rsa.EncryptOAEP(sha256.New(), rand.Reader, &priv.PublicKey, value, nil)
+		} else {
+			result, err = rsa.DecryptOAEP(sha256.New(), nil, priv, value, nil)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body = map[string]string{"kid": kid, "value": base64.RawURLEncoding.EncodeToString(result)}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func (f *fakeKeyVault) masterKey() *MasterKey {
+	return f.masterKeyVersion("version1")
+}
+
+func (f *fakeKeyVault) masterKeyVersion(version string) *MasterKey {
+	key := NewMasterKey(f.URL, "test-key", version)
+	NewTokenCredential(fakeCredential{}).ApplyToMasterKey(key)
+	NewClientOptions(&azkeys.ClientOptions{
+		ClientOptions:                        policy.ClientOptions{Transport: f.Client()},
+		DisableChallengeResourceVerification: true,
+	}).ApplyToMasterKey(key)
+	return key
+}
+
+func resetPublicKeyCache() {
+	publicKeyCacheMu.Lock()
+	publicKeyCache = map[string]publicKey{}
+	publicKeyCacheMu.Unlock()
+}
+
+func TestMasterKey_EncryptDecrypt_Remote(t *testing.T) {
+	t.Setenv(SopsAzureKeyVaultLocalEncryptEnv, "")
+	f := newFakeKeyVault(t, azkeys.KeyTypeRSA)
+
+	key := f.masterKey()
+	require.NoError(t, key.Encrypt([]byte("data key")))
+	plaintext, err := key.Decrypt()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data key"), plaintext)
+	assert.Equal(t, []string{"encrypt", "decrypt"}, f.ops)
+}
+
+func TestMasterKey_EncryptDecrypt_Local(t *testing.T) {
+	for _, kty := range []azkeys.KeyType{azkeys.KeyTypeRSA, azkeys.KeyTypeRSAHSM} {
+		t.Run(string(kty), func(t *testing.T) {
+			t.Setenv(SopsAzureKeyVaultLocalEncryptEnv, "true")
+			resetPublicKeyCache()
+			f := newFakeKeyVault(t, kty)
+
+			key := f.masterKey()
+			require.NoError(t, key.Encrypt([]byte("data key")))
+			plaintext, err := key.Decrypt()
+			require.NoError(t, err)
+			assert.Equal(t, []byte("data key"), plaintext)
+
+			// The public key is only fetched once.
+			other := f.masterKey()
+			require.NoError(t, other.Encrypt([]byte("other data key")))
+			assert.Equal(t, []string{"get", "decrypt"}, f.ops)
+		})
+	}
+}
+
+func TestMasterKey_Encrypt_LocalUnsupportedKeyType(t *testing.T) {
+	t.Setenv(SopsAzureKeyVaultLocalEncryptEnv, "true")
+	resetPublicKeyCache()
+	f := newFakeKeyVault(t, azkeys.KeyTypeEC)
+
+	err := f.masterKey().Encrypt([]byte("data key"))
+	assert.ErrorContains(t, err, "key type EC is not supported")
+}
+
+func TestMasterKey_EncryptDecrypt_UnversionedAfterRotation(t *testing.T) {
+	for _, local := range []string{"", "true"} {
+		t.Run("local="+local, func(t *testing.T) {
+			t.Setenv(SopsAzureKeyVaultLocalEncryptEnv, local)
+			resetPublicKeyCache()
+			f := newFakeKeyVault(t, azkeys.KeyTypeRSA)
+
+			key := f.masterKeyVersion("")
+			require.NoError(t, key.Encrypt([]byte("data key")))
+			assert.Equal(t, "version1", key.Version)
+			assert.Equal(t, "version1", key.ToMap()["version"])
+
+			// A file encrypted before the rotation must still be decryptable,
+			// by the version recorded in its metadata.
+			f.rotate(t)
+			stored := f.masterKeyVersion(key.ToMap()["version"].(string))
+			stored.EncryptedKey = key.EncryptedKey
+			plaintext, err := stored.Decrypt()
+			require.NoError(t, err)
+			assert.Equal(t, []byte("data key"), plaintext)
+
+			// New data keys are encrypted with the latest version.
+			resetPublicKeyCache()
+			rotated := f.masterKeyVersion("")
+			require.NoError(t, rotated.Encrypt([]byte("data key")))
+			assert.Equal(t, "version2", rotated.Version)
+		})
+	}
+}
@@ -30,4 +30,5 @@ const (
 	NoEditorFound                          int = 201
 	FailedToCompareVersions                int = 202
 	FileAlreadyEncrypted                   int = 203
+	RotationNeeded                         int = 204
 )
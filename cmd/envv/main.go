@@ -1,11 +1,9 @@
 package main // import "github.com/AetherVoxSanctum/envv-cli/v3/cmd/envv"
 
 import (
-	"context"
 	encodingjson "encoding/json"
 	"fmt"
 	"io"
-	"net"
 	"net/url"
 	"os"
 	osExec "os/exec"
@@ -13,11 +11,11 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 
 	"github.com/AetherVoxSanctum/envv-cli/v3"
 	"github.com/AetherVoxSanctum/envv-cli/v3/aes"
@@ -31,6 +29,8 @@ import (
 	"github.com/AetherVoxSanctum/envv-cli/v3/cmd/envv/subcommand/groups"
 	keyservicecmd "github.com/AetherVoxSanctum/envv-cli/v3/cmd/envv/subcommand/keyservice"
 	publishcmd "github.com/AetherVoxSanctum/envv-cli/v3/cmd/envv/subcommand/publish"
+	"github.com/AetherVoxSanctum/envv-cli/v3/cmd/envv/subcommand/render"
+	"github.com/AetherVoxSanctum/envv-cli/v3/cmd/envv/subcommand/rotationstatus"
 	"github.com/AetherVoxSanctum/envv-cli/v3/cmd/envv/subcommand/updatekeys"
 	"github.com/AetherVoxSanctum/envv-cli/v3/config"
 	"github.com/AetherVoxSanctum/envv-cli/v3/gcpkms"
@@ -40,6 +40,7 @@ import (
 	"github.com/AetherVoxSanctum/envv-cli/v3/kms"
 	"github.com/AetherVoxSanctum/envv-cli/v3/logging"
 	"github.com/AetherVoxSanctum/envv-cli/v3/pgp"
+	"github.com/AetherVoxSanctum/envv-cli/v3/pkcs11"
 	"github.com/AetherVoxSanctum/envv-cli/v3/stores/dotenv"
 	"github.com/AetherVoxSanctum/envv-cli/v3/stores/json"
 	"github.com/AetherVoxSanctum/envv-cli/v3/version"
@@ -123,6 +124,12 @@ func main() {
     https://docs.microsoft.com/en-us/go/azure/azure-sdk-go-authorization#use-environment-based-authentication.
     The user/sp needs the key/encrypt and key/decrypt permissions.)
 
+   To encrypt or decrypt a document with a key stored on a PKCS#11 token
+   (such as an HSM), specify its PKCS#11 URI in the --pkcs11 flag or in
+   the SOPS_PKCS11_URIS environment variable. The module and PIN can be
+   given in the URI or in the SOPS_PKCS11_MODULE and SOPS_PKCS11_PIN
+   environment variables.
+
    To encrypt or decrypt using age, specify the recipient in the -a flag,
    or in the SOPS_AGE_RECIPIENTS environment variable.
 
@@ -175,7 +182,10 @@ func main() {
 		{
 			Name:      "exec-env",
 			Usage:     "execute a command with decrypted values inserted into the environment",
-			ArgsUsage: "[file to decrypt] [command to run]",
+			ArgsUsage: "[files to decrypt...] [command to run] or [files to decrypt...] -- [command] [args...]",
+			// Reordering would drop the "--" separating the files from the
+			// command, execArgs parses flags between the files instead
+			SkipArgReorder: true,
 			Flags: append([]cli.Flag{
 				cli.BoolFlag{
 					Name:  "background",
@@ -193,36 +203,63 @@ func main() {
 					Name:  "same-process",
 					Usage: "run command in the current process instead of in a child process",
 				},
+				cli.StringSliceFlag{
+					Name:  "only",
+					Usage: "only insert variables whose names match the glob pattern. Can be specified more than once",
+				},
+				cli.StringSliceFlag{
+					Name:  "exclude",
+					Usage: "do not insert variables whose names match the glob pattern. Can be specified more than once",
+				},
+				cli.StringFlag{
+					Name:  "prefix",
+					Usage: "prefix the names of the inserted variables with this string",
+				},
+				cli.StringFlag{
+					Name:  "env-separator",
+					Usage: "separator joining the keys of nested values in variable names (default: _). Overrides env_mapping of the matching creation rule",
+				},
+				cli.StringFlag{
+					Name:  "env-case",
+					Usage: "letter case of the names of nested values: upper (default), lower or preserve. Overrides env_mapping of the matching creation rule",
+				},
+				cli.StringFlag{
+					Name:  "env-lists",
+					Usage: "how lists are inserted: index (default) for one variable per item, or join for lists of values joined by commas. Overrides env_mapping of the matching creation rule",
+				},
+				cli.StringSliceFlag{
+					Name:  "env-rename",
+					Usage: "name the value or branch at a dotted path, e.g. 'database.password=PGPASSWORD'. Can be specified more than once",
+				},
+				cli.BoolFlag{
+					Name:  "watch",
+					Usage: "supervise the command: forward SIGTERM, SIGINT and SIGHUP to it, exit with its exit code, and restart it with the new values whenever the encrypted files change",
+				},
+				cli.StringFlag{
+					Name:  "watch-signal",
+					Usage: "with --watch, send this signal (e.g. HUP) to the command when the encrypted files change instead of restarting it",
+				},
+				cli.DurationFlag{
+					Name:  "watch-interval",
+					Usage: "with --watch, how often to check the encrypted files for changes",
+					Value: exec.DefaultPollInterval,
+				},
+				cli.BoolFlag{
+					Name:  "mask-output",
+					Usage: "replace the inserted values, as well as their base64 and URL encoded forms, with *** in the output of the command",
+				},
+				cli.IntFlag{
+					Name:  "mask-min-length",
+					Usage: "with --mask-output, only mask values that are at least this long",
+					Value: exec.DefaultMaskMinLength,
+				},
 			}, keyserviceFlags...),
 			Action: func(c *cli.Context) error {
-				if c.NArg() != 2 {
-					return common.NewExitError(fmt.Errorf("error: missing file to decrypt"), codes.ErrorGeneric)
-				}
-
-				fileName := c.Args()[0]
-				command := c.Args()[1]
-
-				inputStore, err := inputStore(c, fileName)
+				fileNames, command, err := execArgs(c)
 				if err != nil {
 					return toExitError(err)
 				}
 
-				svcs := keyservices(c)
-
-				order, err := decryptionOrder(c.String("decryption-order"))
-				if err != nil {
-					return toExitError(err)
-				}
-				opts := decryptOpts{
-					OutputStore:     &dotenv.Store{},
-					InputStore:      inputStore,
-					InputPath:       fileName,
-					Cipher:          aes.NewCipher(),
-					KeyServices:     svcs,
-					DecryptionOrder: order,
-					IgnoreMAC:       c.Bool("ignore-mac"),
-				}
-
 				if c.Bool("background") {
 					log.Warn("exec-env's --background option is deprecated and will be removed in a future version of sops")
 
@@ -230,49 +267,128 @@ func main() {
 						return common.NewExitError("Error: The --same-process flag cannot be used with --background", codes.ErrorConflictingParameters)
 					}
 				}
-
-				tree, err := decryptTree(opts)
-				if err != nil {
-					return toExitError(err)
+				if c.Bool("watch") && (c.Bool("background") || c.Bool("same-process")) {
+					return common.NewExitError("Error: The --watch flag cannot be used with --background or --same-process", codes.ErrorConflictingParameters)
+				}
+				if c.Bool("mask-output") && (c.Bool("background") || c.Bool("same-process")) {
+					return common.NewExitError("Error: The --mask-output flag cannot be used with --background or --same-process", codes.ErrorConflictingParameters)
 				}
 
 				var env []string
-				for _, item := range tree.Branches[0] {
-					if dotenv.IsComplexValue(item.Value) {
-						return cli.NewExitError(fmt.Errorf("cannot use complex value in environment: %s", item.Value), codes.ErrorGeneric)
-					}
-					if _, ok := item.Key.(sops.Comment); ok {
-						continue
-					}
-					key, ok := item.Key.(string)
-					if !ok {
-						return cli.NewExitError(fmt.Errorf("cannot use non-string keys in environment, got %T", item.Key), codes.ErrorGeneric)
-					}
-					if strings.Contains(key, "=") {
-						return cli.NewExitError(fmt.Errorf("cannot use keys with '=' in environment: %s", key), codes.ErrorGeneric)
+				var ttl time.Duration
+				var sessionFiles []string
+				if session := reusableSession(c, fileNames); session != nil {
+					// The command can't outlive the session it runs in
+					log.Infof("Using the secrets of the current session, which expires at %s", session.Expires.Format(time.RFC3339))
+					ttl, sessionFiles = time.Until(session.Expires), session.Files
+				} else if env, err = decryptEnv(c, fileNames); err != nil {
+					return err
+				}
+
+				opts := exec.ExecOpts{
+					Command:       command,
+					Plaintext:     []byte{},
+					Background:    c.Bool("background"),
+					Pristine:      c.Bool("pristine"),
+					User:          c.String("user"),
+					SameProcess:   c.Bool("same-process"),
+					Env:           env,
+					TTL:           ttl,
+					SessionFiles:  sessionFiles,
+					MaskOutput:    c.Bool("mask-output"),
+					MaskMinLength: c.Int("mask-min-length"),
+				}
+				if c.Bool("watch") {
+					watch := exec.WatchOpts{
+						Files:        fileNames,
+						PollInterval: c.Duration("watch-interval"),
+						Reload: func() ([]string, error) {
+							return decryptEnv(c, fileNames)
+						},
 					}
-					value, ok := item.Value.(string)
-					if !ok {
-						return cli.NewExitError(fmt.Errorf("cannot use non-string values in environment, got %T", item.Value), codes.ErrorGeneric)
+					if name := c.String("watch-signal"); name != "" {
+						if watch.Signal, err = exec.ParseSignal(name); err != nil {
+							return common.NewExitError(err, codes.ErrorGeneric)
+						}
 					}
-					env = append(env, fmt.Sprintf("%s=%s", key, value))
+					return toExitError(exec.Supervise(opts, watch))
 				}
-
-				if err := exec.ExecWithEnv(exec.ExecOpts{
-					Command:     command,
-					Plaintext:   []byte{},
-					Background:  c.Bool("background"),
-					Pristine:    c.Bool("pristine"),
-					User:        c.String("user"),
-					SameProcess: c.Bool("same-process"),
-					Env:         env,
-				}); err != nil {
+				if err := exec.ExecWithEnv(opts); err != nil {
 					return toExitError(err)
 				}
 
 				return nil
 			},
 		},
+		{
+			Name:      "shell",
+			Usage:     "start an interactive shell with decrypted values inserted into its environment, which is stopped when the TTL elapses",
+			ArgsUsage: "[files to decrypt...]",
+			Flags: append([]cli.Flag{
+				cli.DurationFlag{
+					Name:  "ttl",
+					Usage: "time after which the shell is stopped",
+					Value: exec.DefaultSessionTTL,
+				},
+				cli.StringFlag{
+					Name:  "shell",
+					Usage: "the shell command to run instead of $SHELL",
+				},
+				cli.BoolFlag{
+					Name:  "pristine",
+					Usage: "insert only the decrypted values into the environment without forwarding existing environment variables",
+				},
+				cli.StringSliceFlag{
+					Name:  "only",
+					Usage: "only insert variables whose names match the glob pattern. Can be specified more than once",
+				},
+				cli.StringSliceFlag{
+					Name:  "exclude",
+					Usage: "do not insert variables whose names match the glob pattern. Can be specified more than once",
+				},
+				cli.StringFlag{
+					Name:  "prefix",
+					Usage: "prefix the names of the inserted variables with this string",
+				},
+			}, keyserviceFlags...),
+			Action: func(c *cli.Context) error {
+				if c.NArg() == 0 {
+					return common.NewExitError(fmt.Errorf("error: missing file to decrypt"), codes.ErrorGeneric)
+				}
+				fileNames := c.Args()
+				ttl := c.Duration("ttl")
+				if ttl <= 0 {
+					return common.NewExitError(fmt.Errorf("error: --ttl must be positive"), codes.ErrorGeneric)
+				}
+				command := c.String("shell")
+				if command == "" {
+					command = exec.ShellCommand()
+				}
+				files, err := absPaths(fileNames)
+				if err != nil {
+					return toExitError(err)
+				}
+
+				var env []string
+				if session := reusableSession(c, fileNames); session != nil {
+					// A nested session can't outlive the one it runs in
+					log.Infof("Using the secrets of the current session, which expires at %s", session.Expires.Format(time.RFC3339))
+					if remaining := time.Until(session.Expires); remaining < ttl {
+						ttl = remaining
+					}
+				} else if env, err = decryptEnv(c, fileNames); err != nil {
+					return err
+				}
+
+				return toExitError(exec.ExecWithEnv(exec.ExecOpts{
+					Command:      command,
+					Pristine:     c.Bool("pristine"),
+					Env:          env,
+					TTL:          ttl,
+					SessionFiles: files,
+				}))
+			},
+		},
 		{
 			Name:      "exec-file",
 			Usage:     "execute a command with the decrypted contents as a temporary file",
@@ -286,6 +402,10 @@ func main() {
 					Name:  "no-fifo",
 					Usage: "use a regular file instead of a fifo to temporarily hold the decrypted contents",
 				},
+				cli.BoolFlag{
+					Name:  "memfd",
+					Usage: "pass the decrypted contents in a sealed in-memory file that can be read repeatedly (Linux only, falls back to --no-fifo behavior elsewhere)",
+				},
 				cli.StringFlag{
 					Name:  "user",
 					Usage: "the user to run the command as",
@@ -302,6 +422,14 @@ func main() {
 					Name:  "filename",
 					Usage: fmt.Sprintf("filename for the temporarily file (default: %s)", exec.FallbackFilename),
 				},
+				cli.BoolFlag{
+					Name:  "docker-env",
+					Usage: "write the decrypted values as variables in the env-file format of 'docker run --env-file', without quotes or export statements",
+				},
+				cli.BoolFlag{
+					Name:  "secrets-dir",
+					Usage: "write each top-level value to its own file, named after its key, in a temporary directory on a tmpfs if available. {} in the command is replaced with the directory, which is removed when the command exits",
+				},
 			}, keyserviceFlags...),
 			Action: func(c *cli.Context) error {
 				if c.NArg() != 2 {
@@ -311,6 +439,39 @@ func main() {
 				fileName := c.Args()[0]
 				command := c.Args()[1]
 
+				if c.Bool("docker-env") && c.Bool("secrets-dir") {
+					return common.NewExitError("error: --docker-env and --secrets-dir cannot be used together", codes.ErrorConflictingParameters)
+				}
+				if c.Bool("secrets-dir") && c.Bool("background") {
+					return common.NewExitError("error: --secrets-dir cannot be used with --background, the directory is removed when the command exits", codes.ErrorConflictingParameters)
+				}
+				if c.Bool("background") {
+					log.Warn("exec-file's --background option is deprecated and will be removed in a future version of sops")
+				}
+
+				if c.Bool("docker-env") {
+					env, err := decryptEnv(c, []string{fileName})
+					if err != nil {
+						return err
+					}
+					output, err := exec.DockerEnvFile(env)
+					if err != nil {
+						return common.NewExitError(fmt.Errorf("error reading %s: %w", fileName, err), codes.ErrorGeneric)
+					}
+					if err := exec.ExecWithFile(exec.ExecOpts{
+						Command:    command,
+						Plaintext:  output,
+						Background: c.Bool("background"),
+						Fifo:       !c.Bool("no-fifo"),
+						Memfd:      c.Bool("memfd"),
+						User:       c.String("user"),
+						Filename:   c.String("filename"),
+					}); err != nil {
+						return toExitError(err)
+					}
+					return nil
+				}
+
 				inputStore, err := inputStore(c, fileName)
 				if err != nil {
 					return toExitError(err)
@@ -336,20 +497,41 @@ func main() {
 					IgnoreMAC:       c.Bool("ignore-mac"),
 				}
 
+				if c.Bool("secrets-dir") {
+					tree, err := decryptTree(opts)
+					if err != nil {
+						return toExitError(err)
+					}
+					files, err := exec.SecretFiles(tree.Branches[0], func(value interface{}) ([]byte, error) {
+						if branch, ok := value.(sops.TreeBranch); ok {
+							return outputStore.EmitPlainFile(sops.TreeBranches{branch})
+						}
+						return outputStore.EmitValue(value)
+					})
+					if err != nil {
+						return common.NewExitError(fmt.Errorf("error reading %s: %w", fileName, err), codes.ErrorGeneric)
+					}
+					if err := exec.ExecWithSecretsDir(exec.ExecOpts{
+						Command:     command,
+						User:        c.String("user"),
+						SecretFiles: files,
+					}); err != nil {
+						return toExitError(err)
+					}
+					return nil
+				}
+
 				output, err := decrypt(opts)
 				if err != nil {
 					return toExitError(err)
 				}
 
-				if c.Bool("background") {
-					log.Warn("exec-file's --background option is deprecated and will be removed in a future version of sops")
-				}
-
 				if err := exec.ExecWithFile(exec.ExecOpts{
 					Command:    command,
 					Plaintext:  output,
 					Background: c.Bool("background"),
 					Fifo:       !c.Bool("no-fifo"),
+					Memfd:      c.Bool("memfd"),
 					User:       c.String("user"),
 					Filename:   c.String("filename"),
 				}); err != nil {
@@ -359,6 +541,204 @@ func main() {
 				return nil
 			},
 		},
+		{
+			Name:      "exec-fd",
+			Usage:     "execute a command with the decrypted contents, or a single value of them, readable from stdin or an inherited file descriptor",
+			ArgsUsage: "[file to decrypt] [command to run] or [file to decrypt] -- [command] [args...]",
+			// See exec-env
+			SkipArgReorder: true,
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "extract",
+					Usage: "extract a specific key or branch from the input document. Example: --extract '[\"db\"][\"password\"]'",
+				},
+				cli.IntFlag{
+					Name:  "fd",
+					Usage: "the file descriptor of the command the decrypted contents are readable from. {} in the command is replaced with its /dev/fd path",
+					Value: 3,
+				},
+				cli.BoolFlag{
+					Name:  "stdin",
+					Usage: "pass the decrypted contents on the standard input of the command, like --fd 0",
+				},
+				cli.BoolFlag{
+					Name:  "pristine",
+					Usage: "run the command without forwarding existing environment variables",
+				},
+				cli.StringFlag{
+					Name:  "user",
+					Usage: "the user to run the command as",
+				},
+				cli.StringFlag{
+					Name:  "input-type",
+					Usage: "currently ini, json, yaml, dotenv and binary are supported. If not set, sops will use the file's extension to determine the type",
+				},
+				cli.StringFlag{
+					Name:  "output-type",
+					Usage: "currently ini, json, yaml, dotenv and binary are supported. If not set, sops will use the input file's extension to determine the output format",
+				},
+			}, keyserviceFlags...),
+			Action: func(c *cli.Context) error {
+				fileNames, command, err := execArgs(c)
+				if err != nil {
+					return toExitError(err)
+				}
+				if len(fileNames) != 1 {
+					return common.NewExitError(fmt.Errorf("error: exec-fd decrypts a single file, got %d", len(fileNames)), codes.ErrorGeneric)
+				}
+				fileName := fileNames[0]
+				fd := c.Int("fd")
+				if c.Bool("stdin") {
+					if c.IsSet("fd") {
+						return common.NewExitError("Error: cannot use both --stdin and --fd", codes.ErrorConflictingParameters)
+					}
+					fd = 0
+				}
+
+				inputStore, err := inputStore(c, fileName)
+				if err != nil {
+					return toExitError(err)
+				}
+				outputStore, err := outputStore(c, fileName)
+				if err != nil {
+					return toExitError(err)
+				}
+				order, err := decryptionOrder(c.String("decryption-order"))
+				if err != nil {
+					return toExitError(err)
+				}
+				extract, err := parseTreePath(c.String("extract"))
+				if err != nil {
+					return common.NewExitError(fmt.Errorf("error parsing --extract path: %s", err), codes.InvalidTreePathFormat)
+				}
+				output, err := decrypt(decryptOpts{
+					OutputStore:     outputStore,
+					InputStore:      inputStore,
+					InputPath:       fileName,
+					Cipher:          aes.NewCipher(),
+					Extract:         extract,
+					KeyServices:     keyservices(c),
+					DecryptionOrder: order,
+					IgnoreMAC:       c.Bool("ignore-mac"),
+				})
+				if err != nil {
+					return toExitError(err)
+				}
+
+				return toExitError(exec.ExecWithFd(exec.ExecOpts{
+					Command:   command,
+					Plaintext: output,
+					Fd:        fd,
+					Pristine:  c.Bool("pristine"),
+					User:      c.String("user"),
+				}))
+			},
+		},
+		{
+			Name:      "render",
+			Usage:     "render a Go text/template with the decrypted contents of a file as data",
+			ArgsUsage: "[file to decrypt]",
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "template, t",
+					Usage: "the template to render. Besides the text/template builtins, the b64enc, quote, required and default functions are available",
+				},
+				cli.StringFlag{
+					Name:  "output, o",
+					Usage: "write the rendered template to this file instead of stdout",
+				},
+				cli.StringFlag{
+					Name:  "exec",
+					Usage: "instead of writing it, pass the rendered template to this command like exec-file, replacing {} with the path of a temporary file",
+				},
+				cli.BoolFlag{
+					Name:  "no-fifo",
+					Usage: "with --exec, use a regular file instead of a fifo to temporarily hold the rendered template",
+				},
+				cli.BoolFlag{
+					Name:  "memfd",
+					Usage: "with --exec, pass the rendered template in a sealed in-memory file that can be read repeatedly (Linux only)",
+				},
+				cli.StringFlag{
+					Name:  "user",
+					Usage: "with --exec, the user to run the command as",
+				},
+				cli.StringFlag{
+					Name:  "filename",
+					Usage: fmt.Sprintf("with --exec, filename for the temporarily file (default: %s)", exec.FallbackFilename),
+				},
+				cli.StringFlag{
+					Name:  "input-type",
+					Usage: "currently ini, json, yaml, dotenv and binary are supported. If not set, sops will use the file's extension to determine the type",
+				},
+			}, keyserviceFlags...),
+			Action: func(c *cli.Context) error {
+				if c.NArg() != 1 {
+					return common.NewExitError(fmt.Errorf("error: missing file to decrypt"), codes.ErrorGeneric)
+				}
+				if c.String("template") == "" {
+					return common.NewExitError(fmt.Errorf("error: missing template, set it with --template"), codes.ErrorGeneric)
+				}
+				if c.String("output") != "" && c.String("exec") != "" {
+					return common.NewExitError("Error: cannot operate on both --output and --exec", codes.ErrorConflictingParameters)
+				}
+				fileName := c.Args()[0]
+
+				text, err := os.ReadFile(c.String("template"))
+				if err != nil {
+					return common.NewExitError(fmt.Sprintf("Error reading template: %s", err), codes.CouldNotReadInputFile)
+				}
+
+				inputStore, err := inputStore(c, fileName)
+				if err != nil {
+					return toExitError(err)
+				}
+				order, err := decryptionOrder(c.String("decryption-order"))
+				if err != nil {
+					return toExitError(err)
+				}
+				tree, err := decryptTree(decryptOpts{
+					InputStore:      inputStore,
+					InputPath:       fileName,
+					Cipher:          aes.NewCipher(),
+					KeyServices:     keyservices(c),
+					DecryptionOrder: order,
+					IgnoreMAC:       c.Bool("ignore-mac"),
+				})
+				if err != nil {
+					return toExitError(err)
+				}
+				data, err := sops.EmitAsMap(tree.Branches)
+				if err != nil {
+					return toExitError(err)
+				}
+				output, err := render.Render(filepath.Base(c.String("template")), text, data)
+				if err != nil {
+					return common.NewExitError(err, codes.ErrorGeneric)
+				}
+
+				if command := c.String("exec"); command != "" {
+					return toExitError(exec.ExecWithFile(exec.ExecOpts{
+						Command:   command,
+						Plaintext: output,
+						Fifo:      !c.Bool("no-fifo"),
+						Memfd:     c.Bool("memfd"),
+						User:      c.String("user"),
+						Filename:  c.String("filename"),
+					}))
+				}
+				if c.String("output") != "" {
+					// The rendered template contains secrets, so only the
+					// owner may read it
+					if err := render.WriteFile(c.String("output"), output); err != nil {
+						return common.NewExitError(fmt.Sprintf("Could not write output file: %s", err), codes.CouldNotWriteOutputFile)
+					}
+					return nil
+				}
+				_, err = os.Stdout.Write(output)
+				return toExitError(err)
+			},
+		},
 		{
 			Name:      "publish",
 			Usage:     "Publish sops file or directory to a configured destination",
@@ -463,6 +843,54 @@ func main() {
 					Name:  "prompt",
 					Usage: "Prompt user to confirm every incoming request",
 				},
+				cli.StringFlag{
+					Name:  "prompt-command",
+					Usage: "command to run to confirm every incoming request, which is approved if the command exits successfully. Details about the request are passed in the SOPS_KEYSERVICE_REQUEST_* environment variables",
+				},
+				cli.StringFlag{
+					Name:  "prompt-http",
+					Usage: "address to serve an HTTP endpoint on for confirming incoming requests, e.g. '127.0.0.1:5001'. Pending requests are listed with 'GET /requests' and answered with 'POST /requests/{id}/approve' or 'POST /requests/{id}/deny'",
+				},
+				cli.StringFlag{
+					Name:   "prompt-http-token",
+					Usage:  "bearer token required in the Authorization header of requests to the --prompt-http endpoint. If not set and stderr is a terminal, a random token is generated and printed to stderr at startup",
+					EnvVar: "SOPS_KEYSERVICE_PROMPT_HTTP_TOKEN",
+				},
+				cli.DurationFlag{
+					Name:  "prompt-timeout",
+					Usage: "time after which unconfirmed requests are denied",
+					Value: keyservice.DefaultApprovalTimeout,
+				},
+				cli.StringFlag{
+					Name:  "http-address",
+					Usage: "address to serve an HTTP/JSON gateway to the key service on, e.g. '127.0.0.1:5002'. Requests are POSTed to /v1/encrypt and /v1/decrypt",
+				},
+				cli.StringFlag{
+					Name:   "http-token",
+					Usage:  "bearer token required in the Authorization header of requests to the --http-address gateway. If not set and stderr is a terminal, a random token is generated and printed to stderr at startup",
+					EnvVar: "SOPS_KEYSERVICE_HTTP_TOKEN",
+				},
+				cli.StringSliceFlag{
+					Name:  "upstream",
+					Usage: "forward requests to an upstream key service instead of fulfilling them locally. Can be specified more than once; upstreams are tried in order until one can be reached. Syntax: [type[,type...]=]protocol://address, where 'local' is the in-process key service. Example: --upstream kms,gcp_kms=tcp://bastion:5000 --upstream age=local",
+				},
+				cli.StringSliceFlag{
+					Name:  "age-key-file",
+					Usage: "age identity file to decrypt with, instead of the identities configured through the SOPS_AGE_KEY* environment variables. Can be specified more than once; only identities matching the requested recipient are used",
+				},
+				cli.DurationFlag{
+					Name:  "drain-timeout",
+					Usage: "time in-flight requests are given to complete on shutdown. Zero waits indefinitely",
+					Value: 10 * time.Second,
+				},
+				cli.StringFlag{
+					Name:  "socket-mode",
+					Usage: "octal permissions to set on a unix socket, e.g. '0660'",
+				},
+				cli.StringFlag{
+					Name:  "socket-owner",
+					Usage: "owner to set on a unix socket, in the form 'user', 'user:group' or ':group'",
+				},
 				cli.BoolFlag{
 					Name:  "verbose",
 					Usage: "Enable verbose logging output",
@@ -472,10 +900,29 @@ func main() {
 				if c.Bool("verbose") || c.GlobalBool("verbose") {
 					logging.SetLevel(logrus.DebugLevel)
 				}
+				var socketMode uint64
+				if c.String("socket-mode") != "" {
+					var err error
+					socketMode, err = strconv.ParseUint(c.String("socket-mode"), 8, 32)
+					if err != nil {
+						return common.NewExitError(fmt.Sprintf("Error: invalid --socket-mode %q: %s", c.String("socket-mode"), err), codes.ErrorGeneric)
+					}
+				}
 				err := keyservicecmd.Run(keyservicecmd.Opts{
-					Network: c.String("network"),
-					Address: c.String("address"),
-					Prompt:  c.Bool("prompt"),
+					Network:           c.String("network"),
+					Address:           c.String("address"),
+					Prompt:            c.Bool("prompt"),
+					PromptCommand:     c.String("prompt-command"),
+					PromptHTTPAddress: c.String("prompt-http"),
+					PromptHTTPToken:   c.String("prompt-http-token"),
+					PromptTimeout:     c.Duration("prompt-timeout"),
+					DrainTimeout:      c.Duration("drain-timeout"),
+					SocketMode:        os.FileMode(socketMode),
+					SocketOwner:       c.String("socket-owner"),
+					Upstreams:         c.StringSlice("upstream"),
+					AgeKeyFiles:       c.StringSlice("age-key-file"),
+					HTTPAddress:       c.String("http-address"),
+					HTTPToken:         c.String("http-token"),
 				})
 				if err != nil {
 					log.Errorf("Error running keyservice: %s", err)
@@ -524,6 +971,39 @@ func main() {
 				return nil
 			},
 		},
+		{
+			Name:      "rotation-status",
+			Usage:     "report files whose data key is older than the rotation TTL of their master keys",
+			ArgsUsage: `[paths...]`,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "input-type",
+					Usage: "currently ini, json, yaml, dotenv and binary are supported. If not set, sops will use the file's extension to determine the type",
+				},
+				cli.BoolFlag{
+					Name:  "all",
+					Usage: "also report files and master keys which do not need rotation",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.Bool("verbose") || c.GlobalBool("verbose") {
+					logging.SetLevel(logrus.DebugLevel)
+				}
+				statuses, err := rotationstatus.RotationStatus(rotationstatus.Opts{
+					Paths:      c.Args(),
+					ConfigPath: optionalConfigPath(c),
+					InputType:  c.String("input-type"),
+				})
+				if err != nil {
+					return toExitError(err)
+				}
+				rotationstatus.PrintStatus(os.Stdout, statuses, c.Bool("all"))
+				if due := rotationstatus.Due(statuses); len(due) > 0 {
+					return common.NewExitError(fmt.Sprintf("%d file(s) need rotation", len(due)), codes.RotationNeeded)
+				}
+				return nil
+			},
+		},
 		{
 			Name:  "groups",
 			Usage: "modify the groups on a SOPS file",
@@ -564,6 +1044,10 @@ func main() {
 							Name:  "age",
 							Usage: "the age recipient the new group should contain. Can be specified more than once",
 						},
+						cli.StringSliceFlag{
+							Name:  "pkcs11",
+							Usage: "the PKCS#11 key URI the new group should contain. Can be specified more than once",
+						},
 						cli.BoolFlag{
 							Name:  "in-place, i",
 							Usage: "write output back to the same file instead of stdout",
@@ -584,6 +1068,7 @@ func main() {
 						vaultURIs := c.StringSlice("hc-vault-transit")
 						azkvs := c.StringSlice("azure-kv")
 						ageRecipients := c.StringSlice("age")
+						pkcs11URIs := c.StringSlice("pkcs11")
 						if c.NArg() != 0 {
 							return common.NewExitError(fmt.Errorf("error: no positional arguments allowed"), codes.ErrorGeneric)
 						}
@@ -623,6 +1108,14 @@ func main() {
 								group = append(group, key)
 							}
 						}
+						for _, uri := range pkcs11URIs {
+							k, err := pkcs11.NewMasterKeyFromURI(uri)
+							if err != nil {
+								log.WithError(err).Error("Failed to add key")
+								continue
+							}
+							group = append(group, k)
+						}
 						inputStore, err := inputStore(c, c.String("file"))
 						if err != nil {
 							return toExitError(err)
@@ -920,6 +1413,11 @@ func main() {
 					Usage:  "comma separated list of Azure Key Vault URLs",
 					EnvVar: "SOPS_AZURE_KEYVAULT_URLS",
 				},
+				cli.StringFlag{
+					Name:   "pkcs11",
+					Usage:  "comma separated list of PKCS#11 key URIs (e.g. 'pkcs11:token=sops;object=master-key')",
+					EnvVar: "SOPS_PKCS11_URIS",
+				},
 				cli.StringFlag{
 					Name:   "hc-vault-transit",
 					Usage:  "comma separated list of vault's key URI (e.g. 'https://vault.example.org:8200/v1/transit/keys/dev')",
@@ -1076,6 +1574,14 @@ func main() {
 					Name:  "in-place, i",
 					Usage: "write output back to the same file instead of stdout",
 				},
+				cli.BoolFlag{
+					Name:  "rewrap",
+					Usage: "instead of generating a new data key, rewrap the data key of each Vault master key with the latest version of its transit key",
+				},
+				cli.BoolFlag{
+					Name:  "if-needed",
+					Usage: "only rotate the file if its data key is older than the rotation TTL of its master keys, see rotation-status",
+				},
 				cli.StringFlag{
 					Name:  "output",
 					Usage: "Save the output after decryption to the file specified",
@@ -1108,6 +1614,14 @@ func main() {
 					Name:  "rm-azure-kv",
 					Usage: "remove the provided comma-separated list of Azure Key Vault key URLs from the list of master keys on the given file",
 				},
+				cli.StringFlag{
+					Name:  "add-pkcs11",
+					Usage: "add the provided comma-separated list of PKCS#11 key URIs to the list of master keys on the given file",
+				},
+				cli.StringFlag{
+					Name:  "rm-pkcs11",
+					Usage: "remove the provided comma-separated list of PKCS#11 key URIs from the list of master keys on the given file",
+				},
 				cli.StringFlag{
 					Name:  "add-kms",
 					Usage: "add the provided comma-separated list of KMS ARNs to the list of master keys on the given file",
@@ -1166,8 +1680,8 @@ func main() {
 					return toExitError(err)
 				}
 				if _, err := os.Stat(fileName); os.IsNotExist(err) {
-					if c.String("add-kms") != "" || c.String("add-pgp") != "" || c.String("add-gcp-kms") != "" || c.String("add-hc-vault-transit") != "" || c.String("add-azure-kv") != "" || c.String("add-age") != "" ||
-						c.String("rm-kms") != "" || c.String("rm-pgp") != "" || c.String("rm-gcp-kms") != "" || c.String("rm-hc-vault-transit") != "" || c.String("rm-azure-kv") != "" || c.String("rm-age") != "" {
+					if c.String("add-kms") != "" || c.String("add-pgp") != "" || c.String("add-gcp-kms") != "" || c.String("add-hc-vault-transit") != "" || c.String("add-azure-kv") != "" || c.String("add-age") != "" || c.String("add-pkcs11") != "" ||
+						c.String("rm-kms") != "" || c.String("rm-pgp") != "" || c.String("rm-gcp-kms") != "" || c.String("rm-hc-vault-transit") != "" || c.String("rm-azure-kv") != "" || c.String("rm-age") != "" || c.String("rm-pkcs11") != "" {
 						return common.NewExitError(fmt.Sprintf("Error: cannot add or remove keys on non-existent file %q, use the `edit` subcommand instead.", fileName), codes.CannotChangeKeysFromNonExistentFile)
 					}
 				}
@@ -1196,13 +1710,37 @@ func main() {
 					return toExitError(err)
 				}
 
-				rotateOpts, err := getRotateOpts(c, fileName, inputStore, outputStore, svcs, order)
-				if err != nil {
-					return toExitError(err)
+				var output []byte
+				if c.Bool("if-needed") {
+					status, err := rotationstatus.Check(rotationstatus.Opts{
+						ConfigPath: optionalConfigPath(c),
+						InputType:  c.String("input-type"),
+					}, fileName)
+					if err != nil {
+						return toExitError(err)
+					}
+					if !status.Due {
+						log.Info("File does not need rotation")
+						if c.Bool("in-place") {
+							return nil
+						}
+						// Output the file unchanged
+						output, err = os.ReadFile(fileName)
+						if err != nil {
+							return toExitError(err)
+						}
+					}
 				}
-				output, err := rotate(rotateOpts)
-				if err != nil {
-					return toExitError(err)
+
+				if output == nil {
+					rotateOpts, err := getRotateOpts(c, fileName, inputStore, outputStore, svcs, order)
+					if err != nil {
+						return toExitError(err)
+					}
+					output, err = rotate(rotateOpts)
+					if err != nil {
+						return toExitError(err)
+					}
 				}
 
 				// We open the file *after* the operations on the tree have been
@@ -1258,6 +1796,11 @@ func main() {
 					Usage:  "comma separated list of Azure Key Vault URLs",
 					EnvVar: "SOPS_AZURE_KEYVAULT_URLS",
 				},
+				cli.StringFlag{
+					Name:   "pkcs11",
+					Usage:  "comma separated list of PKCS#11 key URIs (e.g. 'pkcs11:token=sops;object=master-key')",
+					EnvVar: "SOPS_PKCS11_URIS",
+				},
 				cli.StringFlag{
 					Name:   "hc-vault-transit",
 					Usage:  "comma separated list of vault's key URI (e.g. 'https://vault.example.org:8200/v1/transit/keys/dev')",
@@ -1666,6 +2209,11 @@ func main() {
 			Usage:  "comma separated list of Azure Key Vault URLs",
 			EnvVar: "SOPS_AZURE_KEYVAULT_URLS",
 		},
+		cli.StringFlag{
+			Name:   "pkcs11",
+			Usage:  "comma separated list of PKCS#11 key URIs (e.g. 'pkcs11:token=sops;object=master-key')",
+			EnvVar: "SOPS_PKCS11_URIS",
+		},
 		cli.StringFlag{
 			Name:   "hc-vault-transit",
 			Usage:  "comma separated list of vault's key URI (e.g. 'https://vault.example.org:8200/v1/transit/keys/dev')",
@@ -1717,6 +2265,14 @@ func main() {
 			Name:  "rm-azure-kv",
 			Usage: "remove the provided comma-separated list of Azure Key Vault key URLs from the list of master keys on the given file",
 		},
+		cli.StringFlag{
+			Name:  "add-pkcs11",
+			Usage: "add the provided comma-separated list of PKCS#11 key URIs to the list of master keys on the given file",
+		},
+		cli.StringFlag{
+			Name:  "rm-pkcs11",
+			Usage: "remove the provided comma-separated list of PKCS#11 key URIs from the list of master keys on the given file",
+		},
 		cli.StringFlag{
 			Name:  "add-kms",
 			Usage: "add the provided comma-separated list of KMS ARNs to the list of master keys on the given file",
@@ -1843,8 +2399,8 @@ func main() {
 			return toExitError(err)
 		}
 		if _, err := os.Stat(fileName); os.IsNotExist(err) {
-			if c.String("add-kms") != "" || c.String("add-pgp") != "" || c.String("add-gcp-kms") != "" || c.String("add-hc-vault-transit") != "" || c.String("add-azure-kv") != "" || c.String("add-age") != "" ||
-				c.String("rm-kms") != "" || c.String("rm-pgp") != "" || c.String("rm-gcp-kms") != "" || c.String("rm-hc-vault-transit") != "" || c.String("rm-azure-kv") != "" || c.String("rm-age") != "" {
+			if c.String("add-kms") != "" || c.String("add-pgp") != "" || c.String("add-gcp-kms") != "" || c.String("add-hc-vault-transit") != "" || c.String("add-azure-kv") != "" || c.String("add-age") != "" || c.String("add-pkcs11") != "" ||
+				c.String("rm-kms") != "" || c.String("rm-pgp") != "" || c.String("rm-gcp-kms") != "" || c.String("rm-hc-vault-transit") != "" || c.String("rm-azure-kv") != "" || c.String("rm-age") != "" || c.String("rm-pkcs11") != "" {
 				return common.NewExitError(fmt.Sprintf("Error: cannot add or remove keys on non-existent file %q, use `--kms` and `--pgp` instead.", fileName), codes.CannotChangeKeysFromNonExistentFile)
 			}
 			if isEncryptMode || isDecryptMode || isRotateMode {
@@ -2137,7 +2693,7 @@ func getEncryptConfig(c *cli.Context, fileName string) (encryptConfig, error) {
 	}, nil
 }
 
-func getMasterKeys(c *cli.Context, kmsEncryptionContext map[string]*string, kmsOptionName string, pgpOptionName string, gcpKmsOptionName string, azureKvOptionName string, hcVaultTransitOptionName string, ageOptionName string) ([]keys.MasterKey, error) {
+func getMasterKeys(c *cli.Context, kmsEncryptionContext map[string]*string, kmsOptionName string, pgpOptionName string, gcpKmsOptionName string, azureKvOptionName string, hcVaultTransitOptionName string, ageOptionName string, pkcs11OptionName string) ([]keys.MasterKey, error) {
 	var masterKeys []keys.MasterKey
 	for _, k := range kms.MasterKeysFromArnString(c.String(kmsOptionName), kmsEncryptionContext, c.String("aws-profile")) {
 		masterKeys = append(masterKeys, k)
@@ -2169,16 +2725,23 @@ func getMasterKeys(c *cli.Context, kmsEncryptionContext map[string]*string, kmsO
 	for _, k := range ageKeys {
 		masterKeys = append(masterKeys, k)
 	}
+	pkcs11Keys, err := pkcs11.MasterKeysFromURIs(c.String(pkcs11OptionName))
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range pkcs11Keys {
+		masterKeys = append(masterKeys, k)
+	}
 	return masterKeys, nil
 }
 
 func getRotateOpts(c *cli.Context, fileName string, inputStore common.Store, outputStore common.Store, svcs []keyservice.KeyServiceClient, decryptionOrder []string) (rotateOpts, error) {
 	kmsEncryptionContext := kms.ParseKMSContext(c.String("encryption-context"))
-	addMasterKeys, err := getMasterKeys(c, kmsEncryptionContext, "add-kms", "add-pgp", "add-gcp-kms", "add-azure-kv", "add-hc-vault-transit", "add-age")
+	addMasterKeys, err := getMasterKeys(c, kmsEncryptionContext, "add-kms", "add-pgp", "add-gcp-kms", "add-azure-kv", "add-hc-vault-transit", "add-age", "add-pkcs11")
 	if err != nil {
 		return rotateOpts{}, err
 	}
-	rmMasterKeys, err := getMasterKeys(c, kmsEncryptionContext, "rm-kms", "rm-pgp", "rm-gcp-kms", "rm-azure-kv", "rm-hc-vault-transit", "rm-age")
+	rmMasterKeys, err := getMasterKeys(c, kmsEncryptionContext, "rm-kms", "rm-pgp", "rm-gcp-kms", "rm-azure-kv", "rm-hc-vault-transit", "rm-age", "rm-pkcs11")
 	if err != nil {
 		return rotateOpts{}, err
 	}
@@ -2192,6 +2755,7 @@ func getRotateOpts(c *cli.Context, fileName string, inputStore common.Store, out
 		IgnoreMAC:        c.Bool("ignore-mac"),
 		AddMasterKeys:    addMasterKeys,
 		RemoveMasterKeys: rmMasterKeys,
+		Rewrap:           c.Bool("rewrap"),
 	}, nil
 }
 
@@ -2199,6 +2763,11 @@ func toExitError(err error) error {
 	if cliErr, ok := err.(*cli.ExitError); ok && cliErr != nil {
 		return cliErr
 	} else if execErr, ok := err.(*osExec.ExitError); ok && execErr != nil {
+		// Like shells, report commands killed by a signal with 128 plus the
+		// signal number
+		if status, ok := execErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return cli.NewExitError(err, 128+int(status.Signal()))
+		}
 		return cli.NewExitError(err, execErr.ExitCode())
 	} else if err != nil {
 		return cli.NewExitError(err, codes.ErrorGeneric)
@@ -2212,33 +2781,16 @@ func keyservices(c *cli.Context) (svcs []keyservice.KeyServiceClient) {
 	}
 	uris := c.StringSlice("keyservice")
 	for _, uri := range uris {
-		url, err := url.Parse(uri)
-		if err != nil {
+		if _, err := url.Parse(uri); err != nil {
 			log.WithField("uri", uri).
 				Warnf("Error parsing URI for keyservice, skipping")
 			continue
 		}
-		addr := url.Host
-		if url.Scheme == "unix" {
-			addr = url.Path
-		}
-		opts := []grpc.DialOption{
-			grpc.WithTransportCredentials(insecure.NewCredentials()),
-			grpc.WithContextDialer(
-				func(ctx context.Context, addr string) (net.Conn, error) {
-					return (&net.Dialer{}).DialContext(ctx, url.Scheme, addr)
-				},
-			),
-		}
-		log.WithField(
-			"address",
-			fmt.Sprintf("%s://%s", url.Scheme, addr),
-		).Infof("Connecting to key service")
-		conn, err := grpc.NewClient(addr, opts...)
+		client, err := keyservice.Dial(uri)
 		if err != nil {
 			log.Fatalf("failed to listen: %v", err)
 		}
-		svcs = append(svcs, keyservice.NewKeyServiceClient(conn))
+		svcs = append(svcs, client)
 	}
 	return
 }
@@ -2253,6 +2805,142 @@ func findConfigFile() (string, error) {
 	return result.Path, err
 }
 
+// decryptEnv decrypts the files of exec-env and shell into environment
+// variables. Later files override the values of earlier ones.
+func decryptEnv(c *cli.Context, fileNames []string) ([]string, error) {
+	svcs := keyservices(c)
+	order, err := decryptionOrder(c.String("decryption-order"))
+	if err != nil {
+		return nil, toExitError(err)
+	}
+	secrets := exec.NewEnv()
+	for _, fileName := range fileNames {
+		inputStore, err := inputStore(c, fileName)
+		if err != nil {
+			return nil, toExitError(err)
+		}
+		tree, err := decryptTree(decryptOpts{
+			OutputStore:     &dotenv.Store{},
+			InputStore:      inputStore,
+			InputPath:       fileName,
+			Cipher:          aes.NewCipher(),
+			KeyServices:     svcs,
+			DecryptionOrder: order,
+			IgnoreMAC:       c.Bool("ignore-mac"),
+		})
+		if err != nil {
+			return nil, toExitError(err)
+		}
+		mapping, err := envNameMapping(c, fileName)
+		if err != nil {
+			return nil, common.NewExitError(err, codes.ErrorGeneric)
+		}
+		if err := secrets.AddBranches(tree.Branches, mapping); err != nil {
+			return nil, common.NewExitError(fmt.Errorf("error reading %s: %w", fileName, err), codes.ErrorGeneric)
+		}
+	}
+	env, err := secrets.Environ(exec.EnvOpts{
+		Only:    c.StringSlice("only"),
+		Exclude: c.StringSlice("exclude"),
+		Prefix:  c.String("prefix"),
+	})
+	if err != nil {
+		return nil, common.NewExitError(err, codes.ErrorGeneric)
+	}
+	return env, nil
+}
+
+// reusableSession returns the session the current process runs in, if the
+// secrets of the files are already in its environment as exec-env and shell
+// would insert them, so they don't have to be decrypted again. The command is
+// then run for the remaining lifetime of the session. Sessions are a
+// convenience, not a security boundary: the variables marking them can be set
+// by anyone, but only skip decrypting secrets already in the environment.
+func reusableSession(c *cli.Context, fileNames []string) *exec.Session {
+	for _, flag := range []string{"pristine", "only", "exclude", "prefix", "env-separator", "env-case", "env-lists", "env-rename", "user", "watch", "mask-output", "background", "same-process"} {
+		if c.IsSet(flag) {
+			return nil
+		}
+	}
+	session, ok := exec.CurrentSession()
+	if !ok {
+		return nil
+	}
+	files, err := absPaths(fileNames)
+	if err != nil || !session.Covers(files) {
+		return nil
+	}
+	return session
+}
+
+func absPaths(fileNames []string) ([]string, error) {
+	paths := make([]string, len(fileNames))
+	for i, fileName := range fileNames {
+		path, err := filepath.Abs(fileName)
+		if err != nil {
+			return nil, err
+		}
+		paths[i] = path
+	}
+	return paths, nil
+}
+
+// envNameMapping returns how exec-env names the variables of the values of
+// the file: the env_mapping of the matching creation rule, overridden by flags.
+func envNameMapping(c *cli.Context, fileName string) (exec.NameMapping, error) {
+	var mapping config.EnvMapping
+	if configPath := optionalConfigPath(c); configPath != "" {
+		absPath, err := filepath.Abs(fileName)
+		if err != nil {
+			return exec.NameMapping{}, err
+		}
+		if mapping, err = config.LoadEnvMappingForFile(configPath, absPath); err != nil {
+			return exec.NameMapping{}, err
+		}
+	}
+	nameMapping := exec.NameMapping{
+		Separator: mapping.Separator,
+		Case:      mapping.Case,
+		Lists:     mapping.Lists,
+		Renames:   make(map[string]string),
+	}
+	for path, name := range mapping.Renames {
+		nameMapping.Renames[path] = name
+	}
+	if c.String("env-separator") != "" {
+		nameMapping.Separator = c.String("env-separator")
+	}
+	if c.String("env-case") != "" {
+		nameMapping.Case = c.String("env-case")
+	}
+	if c.String("env-lists") != "" {
+		nameMapping.Lists = c.String("env-lists")
+	}
+	for _, rename := range c.StringSlice("env-rename") {
+		path, name, ok := strings.Cut(rename, "=")
+		if !ok || path == "" || name == "" {
+			return exec.NameMapping{}, fmt.Errorf("invalid --env-rename %q, expected path=NAME", rename)
+		}
+		nameMapping.Renames[path] = name
+	}
+	return nameMapping, nil
+}
+
+// optionalConfigPath returns the path of the config file settings with
+// defaults, like the rotation TTLs, are read from, or an empty string if there
+// is none.
+func optionalConfigPath(c *cli.Context) string {
+	if c.GlobalString("config") != "" {
+		return c.GlobalString("config")
+	}
+	// The config file is not mandatory, the defaults are used without it
+	configPath, err := findConfigFile()
+	if err != nil {
+		return ""
+	}
+	return configPath
+}
+
 func loadStoresConfig(context *cli.Context, path string) (*config.StoresConfig, error) {
 	configPath := context.GlobalString("config")
 	if configPath == "" {
@@ -2266,6 +2954,88 @@ func loadStoresConfig(context *cli.Context, path string) (*config.StoresConfig,
 	return config.LoadStoresConfig(configPath)
 }
 
+// execArgs returns the files to decrypt and the command to run of exec-env and
+// exec-fd.
+// The command is either the last argument, or the arguments following "--",
+// which are quoted and joined. Flags given between the files are parsed as
+// well, as the commands skip reordering the arguments to keep the "--".
+func execArgs(c *cli.Context) ([]string, string, error) {
+	args := []string(c.Args())
+	var command []string
+	separated := false
+	for i, arg := range args {
+		if arg == "--" {
+			args, command, separated = args[:i], args[i+1:], true
+			break
+		}
+	}
+	files, err := parseInterspersedFlags(c, args)
+	if err != nil {
+		return nil, "", common.NewExitError(err, codes.ErrorGeneric)
+	}
+	if !separated && len(files) > 0 {
+		files, command = files[:len(files)-1], files[len(files)-1:]
+	}
+	if len(files) == 0 {
+		return nil, "", common.NewExitError(fmt.Errorf("error: missing file to decrypt"), codes.ErrorGeneric)
+	}
+	if len(command) == 0 {
+		return nil, "", common.NewExitError(fmt.Errorf("error: missing command to run"), codes.ErrorGeneric)
+	}
+	if !separated {
+		return files, command[0], nil
+	}
+	return files, exec.JoinCommand(command), nil
+}
+
+// parseInterspersedFlags sets the flags of the command found in args, which
+// flag parsing stopped at since they follow other arguments, and returns the
+// remaining arguments.
+func parseInterspersedFlags(c *cli.Context, args []string) ([]string, error) {
+	var remaining []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if len(arg) < 2 || arg[0] != '-' {
+			remaining = append(remaining, arg)
+			continue
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		flag := commandFlag(c, name)
+		if flag == nil {
+			return nil, fmt.Errorf("flag provided but not defined: %s", arg)
+		}
+		if !hasValue {
+			switch flag.(type) {
+			case cli.BoolFlag, cli.BoolTFlag:
+				value = "true"
+			default:
+				if i+1 == len(args) {
+					return nil, fmt.Errorf("flag needs an argument: %s", arg)
+				}
+				i++
+				value = args[i]
+			}
+		}
+		if err := c.Set(name, value); err != nil {
+			return nil, fmt.Errorf("invalid value %q for flag %s: %w", value, arg, err)
+		}
+	}
+	return remaining, nil
+}
+
+// commandFlag returns the flag of the command with the given name, or nil if
+// there is none
+func commandFlag(c *cli.Context, name string) cli.Flag {
+	for _, flag := range c.Command.Flags {
+		for _, flagName := range strings.Split(flag.GetName(), ",") {
+			if strings.TrimSpace(flagName) == name {
+				return flag
+			}
+		}
+	}
+	return nil
+}
+
 func inputStore(context *cli.Context, path string) (common.Store, error) {
 	storesConf, err := loadStoresConfig(context, path)
 	if err != nil {
@@ -2323,6 +3093,7 @@ func keyGroups(c *cli.Context, file string) ([]sops.KeyGroup, error) {
 	var azkvKeys []keys.MasterKey
 	var hcVaultMkKeys []keys.MasterKey
 	var ageMasterKeys []keys.MasterKey
+	var pkcs11MasterKeys []keys.MasterKey
 	kmsEncryptionContext := kms.ParseKMSContext(c.String("encryption-context"))
 	if c.String("encryption-context") != "" && kmsEncryptionContext == nil {
 		return nil, common.NewExitError("Invalid KMS encryption context format", codes.ErrorInvalidKMSEncryptionContextFormat)
@@ -2369,7 +3140,16 @@ func keyGroups(c *cli.Context, file string) ([]sops.KeyGroup, error) {
 			ageMasterKeys = append(ageMasterKeys, k)
 		}
 	}
-	if c.String("kms") == "" && c.String("pgp") == "" && c.String("gcp-kms") == "" && c.String("azure-kv") == "" && c.String("hc-vault-transit") == "" && c.String("age") == "" {
+	if c.String("pkcs11") != "" {
+		pkcs11Keys, err := pkcs11.MasterKeysFromURIs(c.String("pkcs11"))
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range pkcs11Keys {
+			pkcs11MasterKeys = append(pkcs11MasterKeys, k)
+		}
+	}
+	if c.String("kms") == "" && c.String("pgp") == "" && c.String("gcp-kms") == "" && c.String("azure-kv") == "" && c.String("hc-vault-transit") == "" && c.String("age") == "" && c.String("pkcs11") == "" {
 		conf, err := loadConfig(c, file, kmsEncryptionContext)
 		// config file might just not be supplied, without any error
 		if conf == nil {
@@ -2388,6 +3168,7 @@ func keyGroups(c *cli.Context, file string) ([]sops.KeyGroup, error) {
 	group = append(group, pgpKeys...)
 	group = append(group, hcVaultMkKeys...)
 	group = append(group, ageMasterKeys...)
+	group = append(group, pkcs11MasterKeys...)
 	log.Debugf("Master keys available:  %+v", group)
 	return []sops.KeyGroup{group}, nil
 }
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli"
+)
+
+func TestExecArgs(t *testing.T) {
+	// Exit errors are handled by exiting, unless told otherwise
+	cli.OsExiter = func(int) {}
+	cli.ErrWriter = io.Discard
+	t.Cleanup(func() {
+		cli.OsExiter = os.Exit
+		cli.ErrWriter = os.Stderr
+	})
+
+	tests := []struct {
+		name     string
+		args     []string
+		files    []string
+		command  string
+		pristine bool
+		only     []string
+		err      string
+	}{
+		{
+			name:    "legacy command",
+			args:    []string{"a.yaml", "b.yaml", "echo $A"},
+			files:   []string{"a.yaml", "b.yaml"},
+			command: "echo $A",
+		},
+		{
+			name:    "separated command",
+			args:    []string{"a.yaml", "b.yaml", "--", "node", "server.js", "--port=8080"},
+			files:   []string{"a.yaml", "b.yaml"},
+			command: "node server.js --port=8080",
+		},
+		{
+			name:    "single separated argument is quoted",
+			args:    []string{"a.yaml", "--", "./my app"},
+			files:   []string{"a.yaml"},
+			command: `'./my app'`,
+		},
+		{
+			name:     "bool flag before the file",
+			args:     []string{"--pristine", "a.yaml", "--", "true"},
+			files:    []string{"a.yaml"},
+			command:  "true",
+			pristine: true,
+		},
+		{
+			name:     "flags between the files",
+			args:     []string{"a.yaml", "--pristine", "--only", "DB_*", "b.yaml", "--only=LOG_*", "--", "env"},
+			files:    []string{"a.yaml", "b.yaml"},
+			command:  "env",
+			pristine: true,
+			only:     []string{"DB_*", "LOG_*"},
+		},
+		{
+			name:    "flags after the separator belong to the command",
+			args:    []string{"a.yaml", "--", "ls", "--pristine"},
+			files:   []string{"a.yaml"},
+			command: "ls --pristine",
+		},
+		{
+			name: "missing file",
+			args: []string{"--", "true"},
+			err:  "missing file to decrypt",
+		},
+		{
+			name: "missing command",
+			args: []string{"a.yaml", "--"},
+			err:  "missing command to run",
+		},
+		{
+			name: "undefined flag",
+			args: []string{"a.yaml", "--nope", "true"},
+			err:  "flag provided but not defined: --nope",
+		},
+		{
+			name: "missing flag value",
+			args: []string{"a.yaml", "true", "--only"},
+			err:  "flag needs an argument: --only",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var files []string
+			var command string
+			var pristine bool
+			var only []string
+			app := cli.NewApp()
+			app.Commands = []cli.Command{{
+				Name:           "exec-env",
+				SkipArgReorder: true,
+				Flags: []cli.Flag{
+					cli.BoolFlag{Name: "pristine"},
+					cli.StringSliceFlag{Name: "only"},
+				},
+				Action: func(c *cli.Context) error {
+					var err error
+					files, command, err = execArgs(c)
+					pristine = c.Bool("pristine")
+					only = c.StringSlice("only")
+					return err
+				},
+			}}
+			err := app.Run(append([]string{"sops", "exec-env"}, tt.args...))
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.files, files)
+			assert.Equal(t, tt.command, command)
+			assert.Equal(t, tt.pristine, pristine)
+			if tt.only == nil {
+				assert.Empty(t, only)
+			} else {
+				assert.Equal(t, tt.only, only)
+			}
+		})
+	}
+}
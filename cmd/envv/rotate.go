@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 
 	"github.com/AetherVoxSanctum/envv-cli/v3"
 	"github.com/AetherVoxSanctum/envv-cli/v3/audit"
 	"github.com/AetherVoxSanctum/envv-cli/v3/cmd/envv/codes"
 	"github.com/AetherVoxSanctum/envv-cli/v3/cmd/envv/common"
+	"github.com/AetherVoxSanctum/envv-cli/v3/hcvault"
 	"github.com/AetherVoxSanctum/envv-cli/v3/keys"
 	"github.com/AetherVoxSanctum/envv-cli/v3/keyservice"
 )
@@ -21,9 +24,14 @@ type rotateOpts struct {
 	RemoveMasterKeys []keys.MasterKey
 	KeyServices      []keyservice.KeyServiceClient
 	DecryptionOrder  []string
+	Rewrap           bool
 }
 
 func rotate(opts rotateOpts) ([]byte, error) {
+	if opts.Rewrap {
+		return rewrap(opts)
+	}
+
 	tree, err := common.LoadEncryptedFileWithBugFixes(common.GenericDecryptOpts{
 		Cipher:          opts.Cipher,
 		InputStore:      opts.InputStore,
@@ -87,3 +95,73 @@ func rotate(opts rotateOpts) ([]byte, error) {
 	}
 	return encryptedFile, nil
 }
+
+// rewrap rewraps the data key of each Vault master key of the file with the
+// latest version of its Vault Transit key, through the key services. The data
+// key itself is neither changed nor decrypted on the client.
+func rewrap(opts rotateOpts) ([]byte, error) {
+	if len(opts.AddMasterKeys) > 0 || len(opts.RemoveMasterKeys) > 0 {
+		return nil, common.NewExitError("Error: cannot add or remove master keys while rewrapping", codes.ErrorConflictingParameters)
+	}
+	tree, err := common.LoadEncryptedFileWithBugFixes(common.GenericDecryptOpts{
+		Cipher:          opts.Cipher,
+		InputStore:      opts.InputStore,
+		InputPath:       opts.InputPath,
+		IgnoreMAC:       opts.IgnoreMAC,
+		KeyServices:     opts.KeyServices,
+		DecryptionOrder: opts.DecryptionOrder,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	audit.SubmitEvent(audit.RotateEvent{
+		File: tree.FilePath,
+	})
+
+	var rewrapped int
+	for _, group := range tree.Metadata.KeyGroups {
+		for _, key := range group {
+			vaultKey, ok := key.(*hcvault.MasterKey)
+			if !ok {
+				continue
+			}
+			if err := rewrapKey(vaultKey, opts.KeyServices); err != nil {
+				return nil, common.NewExitError(fmt.Sprintf("Could not rewrap data key with %s: %s", vaultKey.ToString(), err), codes.CouldNotRetrieveKey)
+			}
+			rewrapped++
+		}
+	}
+	if rewrapped == 0 {
+		return nil, common.NewExitError("Error: the file has no Vault master keys to rewrap", codes.NoEncryptionKeyFound)
+	}
+
+	encryptedFile, err := opts.OutputStore.EmitEncryptedFile(*tree)
+	if err != nil {
+		return nil, common.NewExitError(fmt.Sprintf("Could not marshal tree: %s", err), codes.ErrorDumpingTree)
+	}
+	return encryptedFile, nil
+}
+
+// rewrapKey rewraps the data key of the Vault master key with the first key
+// service that succeeds.
+func rewrapKey(key *hcvault.MasterKey, svcs []keyservice.KeyServiceClient) error {
+	svcKey := keyservice.KeyFromMasterKey(key)
+	var errs []error
+	for _, svc := range svcs {
+		rsp, err := svc.Rewrap(context.Background(), &keyservice.RewrapRequest{
+			Key:        &svcKey,
+			Ciphertext: key.EncryptedDataKey(),
+		})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		key.SetEncryptedDataKey(rsp.Ciphertext)
+		return nil
+	}
+	if len(errs) == 0 {
+		return fmt.Errorf("no key service configured")
+	}
+	return errors.Join(errs...)
+}
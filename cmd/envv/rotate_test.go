@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/AetherVoxSanctum/envv-cli/v3/hcvault"
+	"github.com/AetherVoxSanctum/envv-cli/v3/keyservice"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// rewrapKeyService is a key service which rewraps ciphertexts by appending
+// its name, and fails every other request.
+type rewrapKeyService struct {
+	name string
+	err  error
+	reqs []*keyservice.RewrapRequest
+}
+
+func (s *rewrapKeyService) Encrypt(ctx context.Context, req *keyservice.EncryptRequest, opts ...grpc.CallOption) (*keyservice.EncryptResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "encrypt")
+}
+
+func (s *rewrapKeyService) Decrypt(ctx context.Context, req *keyservice.DecryptRequest, opts ...grpc.CallOption) (*keyservice.DecryptResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "decrypt")
+}
+
+func (s *rewrapKeyService) Rewrap(ctx context.Context, req *keyservice.RewrapRequest, opts ...grpc.CallOption) (*keyservice.RewrapResponse, error) {
+	s.reqs = append(s.reqs, req)
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &keyservice.RewrapResponse{Ciphertext: append(req.Ciphertext, []byte("+"+s.name)...)}, nil
+}
+
+func TestRewrapKey(t *testing.T) {
+	key := &hcvault.MasterKey{VaultAddress: "https://vault:8200", EnginePath: "transit", KeyName: "sops", EncryptedKey: "vault:v1:data"}
+	failing := &rewrapKeyService{err: status.Error(codes.Unavailable, "unreachable")}
+	remote := &rewrapKeyService{name: "remote"}
+
+	require.NoError(t, rewrapKey(key, []keyservice.KeyServiceClient{failing, remote}))
+	assert.Equal(t, "vault:v1:data+remote", key.EncryptedKey)
+	require.Len(t, remote.reqs, 1)
+	assert.Equal(t, "sops", remote.reqs[0].Key.GetVaultKey().KeyName)
+
+	err := rewrapKey(key, []keyservice.KeyServiceClient{failing})
+	assert.ErrorContains(t, err, "unreachable")
+	assert.Equal(t, "vault:v1:data+remote", key.EncryptedKey)
+}
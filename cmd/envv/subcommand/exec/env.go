@@ -0,0 +1,278 @@
+package exec
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/AetherVoxSanctum/envv-cli/v3"
+	"github.com/AetherVoxSanctum/envv-cli/v3/stores"
+)
+
+// EnvOpts select and name the environment variables of an Env
+type EnvOpts struct {
+	// Only are glob patterns, as accepted by path.Match, of the names of the
+	// variables to include. If empty, all variables are included
+	Only []string
+	// Exclude are glob patterns of the names of the variables to exclude.
+	// They take precedence over Only
+	Exclude []string
+	// Prefix is prepended to the names of the variables, after matching
+	// them against Only and Exclude
+	Prefix string
+}
+
+// Letter cases of NameMapping
+const (
+	CaseUpper    = "upper"
+	CaseLower    = "lower"
+	CasePreserve = "preserve"
+)
+
+// List handling modes of NameMapping
+const (
+	// ListsIndex names list items by appending their index to the name of
+	// the list
+	ListsIndex = "index"
+	// ListsJoin joins lists of scalars with commas into a single variable
+	ListsJoin = "join"
+)
+
+// NameMapping configures how the paths of nested values are mapped to
+// environment variable names. The zero value maps the value of "password" in
+// "db" to DB_PASSWORD and the items of a "hosts" list to HOSTS_0, HOSTS_1...
+type NameMapping struct {
+	// Separator joins the keys of the path, "_" by default
+	Separator string
+	// Case is the letter case of names, CaseUpper by default
+	Case string
+	// Lists is how lists are handled, ListsIndex by default
+	Lists string
+	// Renames maps paths, with keys and list indices joined by dots, to
+	// variable names, e.g. "database.password" to PGPASSWORD. Renaming the
+	// path of a branch renames the prefix of the names of its values
+	Renames map[string]string
+}
+
+func (m NameMapping) withDefaults() (NameMapping, error) {
+	if m.Separator == "" {
+		m.Separator = "_"
+	}
+	switch m.Case {
+	case "":
+		m.Case = CaseUpper
+	case CaseUpper, CaseLower, CasePreserve:
+	default:
+		return m, fmt.Errorf("invalid case %q, must be one of %s, %s or %s", m.Case, CaseUpper, CaseLower, CasePreserve)
+	}
+	switch m.Lists {
+	case "":
+		m.Lists = ListsIndex
+	case ListsIndex, ListsJoin:
+	default:
+		return m, fmt.Errorf("invalid list handling %q, must be one of %s or %s", m.Lists, ListsIndex, ListsJoin)
+	}
+	return m, nil
+}
+
+// name returns the variable name of the value at the path of keys. Top-level
+// keys are used as is.
+func (m NameMapping) name(keys []string) string {
+	for i := len(keys); i > 0; i-- {
+		if rename, ok := m.Renames[strings.Join(keys[:i], ".")]; ok {
+			if i == len(keys) {
+				return rename
+			}
+			return rename + m.Separator + m.join(keys[i:])
+		}
+	}
+	if len(keys) == 1 {
+		return keys[0]
+	}
+	return m.join(keys)
+}
+
+func (m NameMapping) join(keys []string) string {
+	name := strings.Join(keys, m.Separator)
+	switch m.Case {
+	case CaseUpper:
+		name = strings.ToUpper(name)
+	case CaseLower:
+		name = strings.ToLower(name)
+	}
+	return strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// joinLists replaces the lists of scalars in the value with their items
+// joined by commas
+func joinLists(value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for k, v := range value {
+			joined, err := joinLists(v)
+			if err != nil {
+				return nil, err
+			}
+			value[k] = joined
+		}
+	case []interface{}:
+		items := make([]string, 0, len(value))
+		for _, v := range value {
+			item, err := envValue(v)
+			if err != nil {
+				// Lists of branches are indexed
+				for i := range value {
+					if value[i], err = joinLists(value[i]); err != nil {
+						return nil, err
+					}
+				}
+				return value, nil
+			}
+			items = append(items, item)
+		}
+		return strings.Join(items, ","), nil
+	}
+	return value, nil
+}
+
+// Env is a set of environment variables built from decrypted trees, in which
+// variables of later trees override those of earlier ones
+type Env struct {
+	values map[string]string
+}
+
+// NewEnv returns an empty Env
+func NewEnv() *Env {
+	return &Env{values: make(map[string]string)}
+}
+
+// AddBranches adds the values of the branches to the environment, flattening
+// nested values with stores.Flatten and naming them with the mapping.
+func (e *Env) AddBranches(branches sops.TreeBranches, mapping NameMapping) error {
+	mapping, err := mapping.withDefaults()
+	if err != nil {
+		return err
+	}
+	data, err := sops.EmitAsMap(branches)
+	if err != nil {
+		return err
+	}
+	if mapping.Lists == ListsJoin {
+		if _, err := joinLists(data); err != nil {
+			return err
+		}
+	}
+	flat := stores.Flatten(data)
+	keys := make([]string, 0, len(flat))
+	for key := range flat {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		path := stores.SplitFlattenedKey(key)
+		keys := make([]string, len(path))
+		for i, k := range path {
+			keys[i] = fmt.Sprint(k)
+		}
+		name := mapping.name(keys)
+		if name == "" || strings.Contains(name, "=") {
+			return fmt.Errorf("cannot use key %q in environment", strings.Join(keys, "."))
+		}
+		value, err := envValue(flat[key])
+		if err != nil {
+			return fmt.Errorf("cannot use value of %s in environment: %w", name, err)
+		}
+		e.values[name] = value
+	}
+	return nil
+}
+
+// envValue formats a scalar tree value as an environment variable value.
+func envValue(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case int:
+		return strconv.Itoa(value), nil
+	case int64:
+		return strconv.FormatInt(value, 10), nil
+	case uint64:
+		return strconv.FormatUint(value, 10), nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("unsupported type %T", value)
+}
+
+// DockerEnvFile formats the variables, as returned by Environ, in the env-file
+// dialect of "docker run --env-file": one NAME=value line per variable, without
+// quotes or export statements, so values can't span multiple lines.
+func DockerEnvFile(env []string) ([]byte, error) {
+	var out strings.Builder
+	for _, variable := range env {
+		if strings.ContainsAny(variable, "\r\n") {
+			name, _, _ := strings.Cut(variable, "=")
+			return nil, fmt.Errorf("value of %s contains a line break, which Docker env files do not support", name)
+		}
+		out.WriteString(variable)
+		out.WriteString("\n")
+	}
+	return []byte(out.String()), nil
+}
+
+// Environ returns the variables selected by the options as "NAME=value"
+// strings, sorted by name
+func (e *Env) Environ(opts EnvOpts) ([]string, error) {
+	names := make([]string, 0, len(e.values))
+	for name := range e.values {
+		include, err := matchesEnvOpts(name, opts)
+		if err != nil {
+			return nil, err
+		}
+		if include {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	env := make([]string, 0, len(names))
+	for _, name := range names {
+		env = append(env, opts.Prefix+name+"="+e.values[name])
+	}
+	return env, nil
+}
+
+func matchesEnvOpts(name string, opts EnvOpts) (bool, error) {
+	for _, pattern := range opts.Exclude {
+		match, err := path.Match(pattern, name)
+		if err != nil {
+			return false, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		if match {
+			return false, nil
+		}
+	}
+	if len(opts.Only) == 0 {
+		return true, nil
+	}
+	for _, pattern := range opts.Only {
+		match, err := path.Match(pattern, name)
+		if err != nil {
+			return false, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		if match {
+			return true, nil
+		}
+	}
+	return false, nil
+}
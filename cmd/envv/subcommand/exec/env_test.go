@@ -0,0 +1,147 @@
+package exec
+
+import (
+	"testing"
+
+	"github.com/AetherVoxSanctum/envv-cli/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnv(t *testing.T) {
+	base := sops.TreeBranches{sops.TreeBranch{
+		sops.TreeItem{Key: sops.Comment{Value: "base values"}},
+		sops.TreeItem{Key: "LOG_LEVEL", Value: "info"},
+		sops.TreeItem{Key: "DB_PASSWORD", Value: "base"},
+	}}
+	prod := sops.TreeBranches{sops.TreeBranch{
+		sops.TreeItem{Key: "db", Value: sops.TreeBranch{
+			sops.TreeItem{Key: "password", Value: "prod"},
+			sops.TreeItem{Key: "port", Value: 5432},
+			sops.TreeItem{Key: "read-only", Value: true},
+		}},
+		sops.TreeItem{Key: "hosts", Value: []interface{}{"a", "b"}},
+	}}
+
+	env := NewEnv()
+	require.NoError(t, env.AddBranches(base, NameMapping{}))
+	require.NoError(t, env.AddBranches(prod, NameMapping{}))
+
+	vars, err := env.Environ(EnvOpts{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"DB_PASSWORD=prod",
+		"DB_PORT=5432",
+		"DB_READ_ONLY=true",
+		"HOSTS_0=a",
+		"HOSTS_1=b",
+		"LOG_LEVEL=info",
+	}, vars)
+
+	vars, err = env.Environ(EnvOpts{Only: []string{"DB_*", "LOG_*"}, Exclude: []string{"*_PORT"}, Prefix: "APP_"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"APP_DB_PASSWORD=prod",
+		"APP_DB_READ_ONLY=true",
+		"APP_LOG_LEVEL=info",
+	}, vars)
+
+	_, err = env.Environ(EnvOpts{Only: []string{"["}})
+	assert.ErrorContains(t, err, "invalid pattern")
+}
+
+func TestEnv_invalid(t *testing.T) {
+	err := NewEnv().AddBranches(sops.TreeBranches{sops.TreeBranch{
+		sops.TreeItem{Key: "A=B", Value: "value"},
+	}}, NameMapping{})
+	assert.ErrorContains(t, err, "cannot use key")
+
+	err = NewEnv().AddBranches(sops.TreeBranches{sops.TreeBranch{
+		sops.TreeItem{Key: 1, Value: "value"},
+	}}, NameMapping{})
+	assert.ErrorContains(t, err, "non-string keys")
+}
+
+func TestEnv_mapping(t *testing.T) {
+	branches := sops.TreeBranches{sops.TreeBranch{
+		sops.TreeItem{Key: "database", Value: sops.TreeBranch{
+			sops.TreeItem{Key: "password", Value: "secret"},
+			sops.TreeItem{Key: "user", Value: "admin"},
+			sops.TreeItem{Key: "replica", Value: sops.TreeBranch{
+				sops.TreeItem{Key: "host", Value: "replica.local"},
+			}},
+		}},
+		sops.TreeItem{Key: "hosts", Value: []interface{}{"a", "b"}},
+		sops.TreeItem{Key: "users", Value: []interface{}{
+			sops.TreeBranch{sops.TreeItem{Key: "name", Value: "alice"}},
+		}},
+	}}
+	tests := []struct {
+		name     string
+		mapping  NameMapping
+		expected []string
+	}{
+		{
+			name:    "separator",
+			mapping: NameMapping{Separator: "__"},
+			expected: []string{
+				"DATABASE__PASSWORD=secret",
+				"DATABASE__REPLICA__HOST=replica.local",
+				"DATABASE__USER=admin",
+				"HOSTS__0=a",
+				"HOSTS__1=b",
+				"USERS__0__NAME=alice",
+			},
+		},
+		{
+			name:    "lower case and joined lists",
+			mapping: NameMapping{Case: CaseLower, Lists: ListsJoin},
+			expected: []string{
+				"database_password=secret",
+				"database_replica_host=replica.local",
+				"database_user=admin",
+				"hosts=a,b",
+				"users_0_name=alice",
+			},
+		},
+		{
+			name: "renames",
+			mapping: NameMapping{Renames: map[string]string{
+				"database.password": "PGPASSWORD",
+				"database.replica":  "PGREPLICA",
+				"users.0.name":      "ADMIN",
+			}},
+			expected: []string{
+				"ADMIN=alice",
+				"DATABASE_USER=admin",
+				"HOSTS_0=a",
+				"HOSTS_1=b",
+				"PGPASSWORD=secret",
+				"PGREPLICA_HOST=replica.local",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := NewEnv()
+			require.NoError(t, env.AddBranches(branches, tt.mapping))
+			vars, err := env.Environ(EnvOpts{})
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, vars)
+		})
+	}
+
+	err := NewEnv().AddBranches(branches, NameMapping{Case: "title"})
+	assert.ErrorContains(t, err, "invalid case")
+	err = NewEnv().AddBranches(branches, NameMapping{Lists: "drop"})
+	assert.ErrorContains(t, err, "invalid list handling")
+}
+
+func TestDockerEnvFile(t *testing.T) {
+	out, err := DockerEnvFile([]string{"A=1", `B=quoted "value"`, "C="})
+	require.NoError(t, err)
+	assert.Equal(t, "A=1\nB=quoted \"value\"\nC=\n", string(out))
+
+	_, err = DockerEnvFile([]string{"A=1", "B=multi\nline"})
+	assert.ErrorContains(t, err, "value of B contains a line break")
+}
@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/AetherVoxSanctum/envv-cli/v3/logging"
 
@@ -30,9 +31,24 @@ type ExecOpts struct {
 	SameProcess bool
 	Pristine    bool
 	Fifo        bool
+	Memfd       bool
 	User        string
 	Filename    string
 	Env         []string
+	// Fd is the file descriptor ExecWithFd passes the plaintext on
+	Fd int
+	// TTL, if set, makes ExecWithEnv stop the command once it elapses. The
+	// command can tell it runs in a session from the SessionEnv and
+	// SessionExpiresEnv variables, listing SessionFiles
+	TTL          time.Duration
+	SessionFiles []string
+	// SecretFiles are the files ExecWithSecretsDir writes to the directory
+	// passed to the command, by name
+	SecretFiles map[string][]byte
+	// MaskOutput masks the inserted values that are at least MaskMinLength
+	// long in the output of the command
+	MaskOutput    bool
+	MaskMinLength int
 }
 
 func GetFile(dir, filename string) *os.File {
@@ -57,8 +73,9 @@ func GetFile(dir, filename string) *os.File {
 }
 
 func ExecWithFile(opts ExecOpts) error {
-	if opts.User != "" {
-		SwitchUser(opts.User)
+	u, err := lookupUser(opts)
+	if err != nil {
+		return err
 	}
 
 	if runtime.GOOS == "windows" && opts.Fifo {
@@ -66,29 +83,63 @@ func ExecWithFile(opts ExecOpts) error {
 		opts.Fifo = false
 	}
 
-	dir, err := os.MkdirTemp("", ".sops")
-	if err != nil {
-		log.Fatal(err)
+	var extraFiles []*os.File
+	if opts.Memfd {
+		name := opts.Filename
+		if name == "" {
+			name = FallbackFilename
+		}
+		memfd, err := GetMemfd(name, opts.Plaintext)
+		if err != nil {
+			log.Warnf("Cannot pass the decrypted contents in memory, falling back to a file: %s", err)
+			// A FIFO could only be read once, unlike the memfd
+			opts.Memfd, opts.Fifo = false, false
+		} else {
+			defer memfd.Close()
+			extraFiles = append(extraFiles, memfd)
+		}
 	}
-	defer os.RemoveAll(dir)
 
 	var filename string
-	if opts.Fifo {
-		// fifo handling needs to be async, even opening to write
-		// will block if there is no reader present
-		filename = opts.Filename
-		if filename == "" {
-			filename = FallbackFilename
-		}
-		filename = GetPipe(dir, filename)
-		go WritePipe(filename, opts.Plaintext)
+	if opts.Memfd {
+		// Files in ExtraFiles are passed to the child as file descriptors
+		// starting at 3. Opening the path reopens the file from the start, so
+		// it can be read repeatedly.
+		filename = fmt.Sprintf("/proc/self/fd/%d", 3+len(extraFiles)-1)
 	} else {
-		// GetFile handles opts.Filename == "" specially, that's why we have
-		// to pass in opts.Filename without handling the fallback here
-		handle := GetFile(dir, opts.Filename)
-		handle.Write(opts.Plaintext)
-		handle.Close()
-		filename = handle.Name()
+		dir, err := os.MkdirTemp("", ".sops")
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		if u != nil {
+			if err := u.chown(dir); err != nil {
+				return err
+			}
+		}
+
+		if opts.Fifo {
+			// fifo handling needs to be async, even opening to write
+			// will block if there is no reader present
+			filename = opts.Filename
+			if filename == "" {
+				filename = FallbackFilename
+			}
+			filename = GetPipe(dir, filename)
+			go WritePipe(filename, opts.Plaintext)
+		} else {
+			// GetFile handles opts.Filename == "" specially, that's why we have
+			// to pass in opts.Filename without handling the fallback here
+			handle := GetFile(dir, opts.Filename)
+			handle.Write(opts.Plaintext)
+			handle.Close()
+			filename = handle.Name()
+		}
+		if u != nil {
+			if err := u.chown(filename); err != nil {
+				return err
+			}
+		}
 	}
 
 	var env []string
@@ -100,6 +151,10 @@ func ExecWithFile(opts ExecOpts) error {
 	placeholdered := strings.Replace(opts.Command, "{}", filename, -1)
 	cmd := BuildCommand(placeholdered)
 	cmd.Env = env
+	cmd.ExtraFiles = extraFiles
+	if u != nil {
+		setUser(cmd, u)
+	}
 
 	if opts.Background {
 		return cmd.Start()
@@ -113,37 +168,36 @@ func ExecWithFile(opts ExecOpts) error {
 }
 
 func ExecWithEnv(opts ExecOpts) error {
-	if opts.User != "" {
-		SwitchUser(opts.User)
+	u, err := lookupUser(opts)
+	if err != nil {
+		return err
 	}
 
 	if runtime.GOOS == "windows" && opts.SameProcess {
 		return fmt.Errorf("The --same-process flag is not supported on Windows")
 	}
 
-	var env []string
-
-	if !opts.Pristine {
-		env = os.Environ()
-	}
+	env := environ(opts)
 
-	lines := bytes.Split(opts.Plaintext, []byte("\n"))
-	for _, line := range lines {
-		if len(line) == 0 {
-			continue
-		}
-		if line[0] == '#' {
-			continue
+	var session *Session
+	if opts.TTL > 0 {
+		if opts.SameProcess || opts.Background {
+			return fmt.Errorf("commands with a TTL cannot run in the same process or in the background")
 		}
-		env = append(env, string(line))
+		session = &Session{Files: opts.SessionFiles, Expires: time.Now().Add(opts.TTL)}
+		env = append(env, session.environ()...)
 	}
 
-	env = append(env, opts.Env...)
-
 	if opts.SameProcess {
 		if opts.Background {
 			log.Fatal("background is not supported for same-process")
 		}
+		if u != nil {
+			env = u.environ(env)
+			if err := switchUser(u); err != nil {
+				return err
+			}
+		}
 
 		// Note that the call does NOT return, unless an error happens.
 		return ExecSyscall(opts.Command, env)
@@ -151,14 +205,44 @@ func ExecWithEnv(opts ExecOpts) error {
 
 	cmd := BuildCommand(opts.Command)
 	cmd.Env = env
+	if u != nil {
+		setUser(cmd, u)
+	}
 
 	if opts.Background {
 		return cmd.Start()
 	}
 
 	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	flush := setOutput(cmd, opts)
+	defer flush()
 
+	if session != nil {
+		return runForwarding(cmd, session)
+	}
 	return cmd.Run()
 }
+
+// environ returns the environment of the command, made of the current
+// environment unless running pristine, the lines of the plaintext and the
+// extra variables
+func environ(opts ExecOpts) []string {
+	var env []string
+
+	if !opts.Pristine {
+		env = os.Environ()
+	}
+
+	lines := bytes.Split(opts.Plaintext, []byte("\n"))
+	for _, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		if line[0] == '#' {
+			continue
+		}
+		env = append(env, string(line))
+	}
+
+	return append(env, opts.Env...)
+}
@@ -4,12 +4,16 @@
 package exec
 
 import (
+	"fmt"
 	"os"
 	"os/exec"
 	"os/user"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"syscall"
+
+	"golang.org/x/sys/unix"
 )
 
 func ExecSyscall(command string, env []string) error {
@@ -20,6 +24,46 @@ func BuildCommand(command string) *exec.Cmd {
 	return exec.Command("/bin/sh", "-c", command)
 }
 
+// JoinCommand quotes the arguments for /bin/sh and joins them into a command
+// for BuildCommand
+func JoinCommand(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg != "" && strings.IndexFunc(arg, func(r rune) bool {
+			return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("@%+=:,./_-{}", r))
+		}) < 0 {
+			quoted[i] = arg
+			continue
+		}
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+// ParseSignal returns the signal with the given name, with or without the SIG
+// prefix, e.g. HUP or SIGUSR1
+func ParseSignal(name string) (os.Signal, error) {
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	sig := unix.SignalNum(name)
+	if sig == 0 {
+		return nil, fmt.Errorf("unknown signal %q", name)
+	}
+	return sig, nil
+}
+
+// ShellCommand returns the command starting the interactive shell of the
+// SHELL environment variable, or /bin/sh, in place of the shell running it
+func ShellCommand() string {
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+	return "exec " + JoinCommand([]string{shell})
+}
+
 func WritePipe(pipe string, contents []byte) {
 	handle, err := os.OpenFile(pipe, os.O_WRONLY, 0600)
 
@@ -42,31 +86,105 @@ func GetPipe(dir, filename string) string {
 	return tmpfn
 }
 
-func SwitchUser(username string) {
-	user, err := user.Lookup(username)
+// LookupUser returns the user to run commands as. As switching users requires
+// privileges, it fails unless running as root.
+func LookupUser(username string) (*User, error) {
+	if os.Geteuid() != 0 {
+		return nil, fmt.Errorf("running commands as user %q requires sops to run as root", username)
+	}
+	u, err := user.Lookup(username)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-
-	uid, _ := strconv.Atoi(user.Uid)
-
-	err = syscall.Setgid(uid)
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("invalid uid %q of user %q: %w", u.Uid, username, err)
 	}
-
-	err = syscall.Setuid(uid)
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("invalid gid %q of user %q: %w", u.Gid, username, err)
 	}
-
-	err = syscall.Setreuid(uid, uid)
+	groupIds, err := u.GroupIds()
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("failed to look up the groups of user %q: %w", username, err)
+	}
+	groups := make([]uint32, 0, len(groupIds))
+	for _, id := range groupIds {
+		group, err := strconv.ParseUint(id, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid group id %q of user %q: %w", id, username, err)
+		}
+		groups = append(groups, uint32(group))
 	}
+	return &User{
+		Name:    u.Username,
+		HomeDir: u.HomeDir,
+		Uid:     uint32(uid),
+		Gid:     uint32(gid),
+		Groups:  groups,
+	}, nil
+}
 
-	err = syscall.Setregid(uid, uid)
-	if err != nil {
-		log.Fatal(err)
+// setUser makes the command run as the user
+func setUser(cmd *exec.Cmd, u *User) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{
+		Uid:    u.Uid,
+		Gid:    u.Gid,
+		Groups: u.Groups,
+	}
+	cmd.Env = u.environ(cmd.Env)
+}
+
+// switchUser changes the credentials of the current process to the ones of
+// the user. It is only meant to be used right before replacing the process
+// with the command.
+func switchUser(u *User) error {
+	groups := make([]int, len(u.Groups))
+	for i, group := range u.Groups {
+		groups[i] = int(group)
+	}
+	// The groups have to be changed while still privileged
+	if err := syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("failed to set groups: %w", err)
+	}
+	if err := syscall.Setgid(int(u.Gid)); err != nil {
+		return fmt.Errorf("failed to set gid: %w", err)
+	}
+	if err := syscall.Setuid(int(u.Uid)); err != nil {
+		return fmt.Errorf("failed to set uid: %w", err)
+	}
+	return nil
+}
+
+// setProcessGroup starts the command in its own process group, so that
+// signalGroup reaches the processes it starts as well. If the current process
+// is in the foreground of the terminal on stdin, the group of the command is
+// made the foreground group instead, so that signals from the terminal such as
+// SIGINT are sent to it directly. It returns whether that is the case.
+func setProcessGroup(cmd *exec.Cmd) bool {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	fd := int(os.Stdin.Fd())
+	pgrp, err := unix.IoctlGetInt(fd, unix.TIOCGPGRP)
+	if err != nil || pgrp != unix.Getpgrp() {
+		return false
+	}
+	cmd.SysProcAttr.Foreground = true
+	cmd.SysProcAttr.Ctty = fd
+	return true
+}
+
+// signalGroup sends the signal to the process group of a process started with
+// setProcessGroup.
+func signalGroup(p *os.Process, sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return p.Signal(sig)
 	}
+	return syscall.Kill(-p.Pid, s)
 }
@@ -0,0 +1,92 @@
+//go:build !windows
+// +build !windows
+
+package exec
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJoinCommand(t *testing.T) {
+	assert.Equal(t, `node server.js --port=8080 'hello world' 'it'\''s' ''`, JoinCommand([]string{"node", "server.js", "--port=8080", "hello world", "it's", ""}))
+}
+
+func TestLookupUser(t *testing.T) {
+	if os.Geteuid() != 0 {
+		_, err := LookupUser("nobody")
+		assert.ErrorContains(t, err, "requires sops to run as root")
+		return
+	}
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip("user nobody does not exist")
+	}
+
+	u, err := LookupUser("nobody")
+	require.NoError(t, err)
+	assert.Equal(t, "nobody", u.Name)
+	assert.Equal(t, nobody.HomeDir, u.HomeDir)
+	assert.Equal(t, nobody.Uid, strconv.FormatUint(uint64(u.Uid), 10))
+	assert.Equal(t, nobody.Gid, strconv.FormatUint(uint64(u.Gid), 10))
+	assert.NotEmpty(t, u.Groups)
+
+	_, err = LookupUser("no-such-user-for-sops")
+	assert.Error(t, err)
+}
+
+func TestSetUser(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("switching users requires root")
+	}
+	u, err := LookupUser("nobody")
+	if err != nil {
+		t.Skip("user nobody does not exist")
+	}
+
+	cmd := exec.Command("/bin/sh", "-c", `echo "$(id -u) $(id -g) $HOME $USER $LOGNAME"`)
+	cmd.Env = []string{"HOME=/root", "USER=root", "LOGNAME=root", "PATH=" + os.Getenv("PATH")}
+	setUser(cmd, u)
+	out, err := cmd.Output()
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%d %d %s nobody nobody", u.Uid, u.Gid, u.HomeDir), strings.TrimSpace(string(out)))
+	assert.Equal(t, 0, os.Geteuid(), "the current process should keep its credentials")
+}
+
+func TestExecWithFile_user(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("switching users requires root")
+	}
+	if _, err := user.Lookup("nobody"); err != nil {
+		t.Skip("user nobody does not exist")
+	}
+	// t.TempDir is not accessible to other users
+	dir, err := os.MkdirTemp("", "sops-exec-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, os.Chmod(dir, 0777))
+	out := filepath.Join(dir, "out")
+
+	for _, fifo := range []bool{true, false} {
+		err := ExecWithFile(ExecOpts{
+			Command:   "cat {} > " + out,
+			Plaintext: []byte("secret"),
+			Fifo:      fifo,
+			User:      "nobody",
+		})
+		require.NoError(t, err)
+		contents, err := os.ReadFile(out)
+		require.NoError(t, err)
+		assert.Equal(t, "secret", string(contents))
+		require.NoError(t, os.Remove(out))
+	}
+}
@@ -1,7 +1,11 @@
 package exec
 
 import (
+	"fmt"
+	"os"
 	"os/exec"
+	"strings"
+	"syscall"
 )
 
 func ExecSyscall(command string, env []string) error {
@@ -13,6 +17,28 @@ func BuildCommand(command string) *exec.Cmd {
 	return exec.Command("cmd.exe", "/C", command)
 }
 
+// JoinCommand quotes the arguments and joins them into a command for
+// BuildCommand
+func JoinCommand(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = syscall.EscapeArg(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+func ParseSignal(name string) (os.Signal, error) {
+	return nil, fmt.Errorf("sending signals is not available on windows")
+}
+
+func ShellCommand() string {
+	shell := os.Getenv("COMSPEC")
+	if shell == "" {
+		shell = "cmd.exe"
+	}
+	return JoinCommand([]string{shell})
+}
+
 func WritePipe(pipe string, contents []byte) {
 	log.Fatal("fifos are not available on windows")
 }
@@ -22,6 +48,21 @@ func GetPipe(dir, filename string) string {
 	return ""
 }
 
-func SwitchUser(username string) {
-	log.Fatal("user switching not available on windows")
+func LookupUser(username string) (*User, error) {
+	return nil, fmt.Errorf("user switching not available on windows")
+}
+
+func setUser(cmd *exec.Cmd, u *User) {
+}
+
+func switchUser(u *User) error {
+	return fmt.Errorf("user switching not available on windows")
+}
+
+func setProcessGroup(cmd *exec.Cmd) bool {
+	return false
+}
+
+func signalGroup(p *os.Process, sig os.Signal) error {
+	return p.Signal(sig)
 }
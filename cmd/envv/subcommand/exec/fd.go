@@ -0,0 +1,64 @@
+package exec
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// ExecWithFd runs the command with the plaintext readable through a pipe on
+// file descriptor opts.Fd of the command, which is its standard input if 0.
+// The plaintext is neither written to a file nor inserted into the
+// environment. "{}" in the command is replaced with the /dev/fd path of the
+// file descriptor.
+func ExecWithFd(opts ExecOpts) error {
+	if opts.Fd < 0 || opts.Fd == 1 || opts.Fd == 2 {
+		return fmt.Errorf("cannot pass the decrypted contents on file descriptor %d, use 0 for stdin or 3 and above", opts.Fd)
+	}
+	if runtime.GOOS == "windows" && opts.Fd != 0 {
+		return fmt.Errorf("passing file descriptors other than stdin is not supported on Windows")
+	}
+	u, err := lookupUser(opts)
+	if err != nil {
+		return err
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	placeholdered := strings.Replace(opts.Command, "{}", fmt.Sprintf("/dev/fd/%d", opts.Fd), -1)
+	cmd := BuildCommand(placeholdered)
+	// The plaintext is not made of variables, unlike for ExecWithEnv
+	cmd.Env = environ(ExecOpts{Pristine: opts.Pristine, Env: opts.Env})
+	if u != nil {
+		setUser(cmd, u)
+	}
+	if opts.Fd == 0 {
+		cmd.Stdin = r
+	} else {
+		cmd.Stdin = os.Stdin
+		// Entry i of ExtraFiles becomes file descriptor 3+i, nil entries
+		// are closed
+		cmd.ExtraFiles = make([]*os.File, opts.Fd-2)
+		cmd.ExtraFiles[opts.Fd-3] = r
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err = cmd.Start()
+	// Only the command may hold the read end, so that writing fails instead
+	// of blocking if it exits without reading everything
+	r.Close()
+	if err != nil {
+		return err
+	}
+	go func() {
+		w.Write(opts.Plaintext)
+		w.Close()
+	}()
+	return cmd.Wait()
+}
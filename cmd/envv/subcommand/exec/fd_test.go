@@ -0,0 +1,46 @@
+//go:build !windows
+// +build !windows
+
+package exec
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecWithFd(t *testing.T) {
+	tests := []struct {
+		name    string
+		fd      int
+		command string
+	}{
+		{"stdin", 0, "cat > {out}"},
+		{"fd 3", 3, "cat <&3 > {out}"},
+		{"fd 5", 5, "cat {} > {out}; [ ! -e /dev/fd/4 ]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "out")
+			err := ExecWithFd(ExecOpts{
+				Command:   strings.ReplaceAll(tt.command, "{out}", out),
+				Plaintext: []byte("s3cr3t"),
+				Fd:        tt.fd,
+				Env:       []string{"OTHER=value"},
+			})
+			require.NoError(t, err)
+			contents, err := os.ReadFile(out)
+			require.NoError(t, err)
+			assert.Equal(t, "s3cr3t", string(contents))
+		})
+	}
+
+	// The command doesn't have to read the plaintext
+	assert.NoError(t, ExecWithFd(ExecOpts{Command: "true", Plaintext: make([]byte, 1<<20), Fd: 3}))
+
+	assert.ErrorContains(t, ExecWithFd(ExecOpts{Command: "true", Fd: 1}), "use 0 for stdin or 3 and above")
+}
@@ -0,0 +1,153 @@
+package exec
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+const (
+	// Mask replaces secrets in masked output
+	Mask = "***"
+	// DefaultMaskMinLength is the length below which values are not masked by
+	// default, as short values like "true" or "80" would mask unrelated output
+	DefaultMaskMinLength = 6
+)
+
+// MaskingWriter replaces the secrets, as well as their base64 and URL encoded
+// forms, in the output written to it with Mask before passing it on. Output
+// that could be the start of a secret is held back until the next write or
+// Close.
+type MaskingWriter struct {
+	w        io.Writer
+	patterns [][]byte
+	buf      []byte
+}
+
+// NewMaskingWriter returns a MaskingWriter writing to w, masking the values
+// that are at least minLength bytes long
+func NewMaskingWriter(w io.Writer, values []string, minLength int) *MaskingWriter {
+	seen := make(map[string]bool)
+	var patterns [][]byte
+	for _, value := range values {
+		if value == "" || len(value) < minLength {
+			continue
+		}
+		for _, pattern := range []string{
+			value,
+			base64.StdEncoding.EncodeToString([]byte(value)),
+			base64.RawStdEncoding.EncodeToString([]byte(value)),
+			base64.URLEncoding.EncodeToString([]byte(value)),
+			base64.RawURLEncoding.EncodeToString([]byte(value)),
+			url.QueryEscape(value),
+			url.PathEscape(value),
+		} {
+			if !seen[pattern] {
+				seen[pattern] = true
+				patterns = append(patterns, []byte(pattern))
+			}
+		}
+	}
+	// Prefer the longest match when patterns overlap
+	sort.Slice(patterns, func(i, j int) bool {
+		return len(patterns[i]) > len(patterns[j])
+	})
+	return &MaskingWriter{w: w, patterns: patterns}
+}
+
+// Write masks the secrets in p and writes the result to the underlying writer
+func (m *MaskingWriter) Write(p []byte) (int, error) {
+	m.buf = append(m.buf, p...)
+	var out []byte
+	for {
+		start, length := m.match()
+		if start < 0 {
+			break
+		}
+		out = append(out, m.buf[:start]...)
+		out = append(out, Mask...)
+		m.buf = m.buf[start+length:]
+	}
+	hold := m.partialMatch()
+	out = append(out, m.buf[:hold]...)
+	m.buf = append([]byte(nil), m.buf[hold:]...)
+	if _, err := m.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close writes the output held back by the MaskingWriter
+func (m *MaskingWriter) Close() error {
+	_, err := m.w.Write(m.buf)
+	m.buf = nil
+	return err
+}
+
+// match returns the position and length of the first secret in the buffer, or
+// -1 if there is none
+func (m *MaskingWriter) match() (int, int) {
+	start, length := -1, 0
+	for _, pattern := range m.patterns {
+		i := bytes.Index(m.buf, pattern)
+		if i >= 0 && (start < 0 || i < start) {
+			start, length = i, len(pattern)
+		}
+	}
+	return start, length
+}
+
+// partialMatch returns the position of the longest suffix of the buffer that
+// is the start of a secret, or the length of the buffer if there is none
+func (m *MaskingWriter) partialMatch() int {
+	start := 0
+	if len(m.patterns) > 0 && len(m.buf) > len(m.patterns[0]) {
+		// patterns are sorted by length, so no longer suffix can match
+		start = len(m.buf) - len(m.patterns[0])
+	}
+	for i := start; i < len(m.buf); i++ {
+		for _, pattern := range m.patterns {
+			if len(m.buf)-i < len(pattern) && bytes.HasPrefix(pattern, m.buf[i:]) {
+				return i
+			}
+		}
+	}
+	return len(m.buf)
+}
+
+// secretValues returns the values of the variables inserted into the
+// environment of the command
+func secretValues(opts ExecOpts) []string {
+	var values []string
+	for _, line := range environ(ExecOpts{Pristine: true, Plaintext: opts.Plaintext, Env: opts.Env}) {
+		if _, value, ok := strings.Cut(line, "="); ok {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// setOutput connects the output of the command to the one of the current
+// process, masking the secrets in it if requested. The returned function
+// writes any output held back once the command has exited.
+func setOutput(cmd *exec.Cmd, opts ExecOpts) func() {
+	if !opts.MaskOutput {
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		return func() {}
+	}
+	values := secretValues(opts)
+	stdout := NewMaskingWriter(os.Stdout, values, opts.MaskMinLength)
+	stderr := NewMaskingWriter(os.Stderr, values, opts.MaskMinLength)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	return func() {
+		stdout.Close()
+		stderr.Close()
+	}
+}
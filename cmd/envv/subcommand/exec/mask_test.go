@@ -0,0 +1,63 @@
+package exec
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaskingWriter(t *testing.T) {
+	secret := "s3cr3t/value+1"
+	tests := []struct {
+		name   string
+		input  string
+		output string
+	}{
+		{"plain", "password is " + secret + "!", "password is ***!"},
+		{"repeated", secret + secret, "******"},
+		{"base64", "auth: " + base64.StdEncoding.EncodeToString([]byte(secret)), "auth: ***"},
+		{"base64url", base64.RawURLEncoding.EncodeToString([]byte(secret)), "***"},
+		{"query escaped", "?p=s3cr3t%2Fvalue%2B1&x", "?p=***&x"},
+		{"path escaped", "/s3cr3t%2Fvalue+1/", "/***/"},
+		{"short", "hunter", "hunter"},
+		{"partial", "s3cr3t/val", "s3cr3t/val"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			w := NewMaskingWriter(&out, []string{secret, "hunter", ""}, 8)
+			n, err := w.Write([]byte(tt.input))
+			require.NoError(t, err)
+			assert.Equal(t, len(tt.input), n)
+			require.NoError(t, w.Close())
+			assert.Equal(t, tt.output, out.String())
+		})
+	}
+}
+
+func TestMaskingWriter_split(t *testing.T) {
+	var out bytes.Buffer
+	w := NewMaskingWriter(&out, []string{"topsecret"}, DefaultMaskMinLength)
+
+	_, err := w.Write([]byte("value: top"))
+	require.NoError(t, err)
+	assert.Equal(t, "value: ", out.String(), "a possible start of a secret should be held back")
+
+	_, err = w.Write([]byte("secret\nnext: top"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("ic\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	assert.Equal(t, "value: ***\nnext: topic\n", out.String())
+}
+
+func TestSecretValues(t *testing.T) {
+	values := secretValues(ExecOpts{
+		Plaintext: []byte("# comment\nA=first\n\nB=a=b\n"),
+		Env:       []string{"C=third"},
+	})
+	assert.Equal(t, []string{"first", "a=b", "third"}, values)
+}
@@ -0,0 +1,35 @@
+//go:build linux
+// +build linux
+
+package exec
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// memfdSeals are the seals added to in-memory files, making their contents
+// immutable.
+const memfdSeals = unix.F_SEAL_SHRINK | unix.F_SEAL_GROW | unix.F_SEAL_WRITE | unix.F_SEAL_SEAL
+
+// GetMemfd returns an anonymous in-memory file created with memfd_create
+// holding the contents, sealed against modification. The file is not
+// inherited across exec, unless passed explicitly to a child process
+func GetMemfd(name string, contents []byte) (*os.File, error) {
+	fd, err := unix.MemfdCreate(name, unix.MFD_CLOEXEC|unix.MFD_ALLOW_SEALING)
+	if err != nil {
+		return nil, fmt.Errorf("memfd_create: %w", err)
+	}
+	f := os.NewFile(uintptr(fd), name)
+	if _, err := f.Write(contents); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := unix.FcntlInt(f.Fd(), unix.F_ADD_SEALS, memfdSeals); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to seal memfd: %w", err)
+	}
+	return f, nil
+}
@@ -0,0 +1,33 @@
+//go:build linux
+// +build linux
+
+package exec
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestGetMemfd(t *testing.T) {
+	f, err := GetMemfd("test", []byte("secret"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	seals, err := unix.FcntlInt(f.Fd(), unix.F_GET_SEALS, 0)
+	require.NoError(t, err)
+	assert.Equal(t, memfdSeals, seals)
+
+	_, err = f.Write([]byte("more"))
+	assert.Error(t, err)
+
+	cmd := exec.Command("/bin/sh", "-c", "cat /proc/self/fd/3; cat /proc/self/fd/3")
+	cmd.ExtraFiles = []*os.File{f}
+	out, err := cmd.Output()
+	require.NoError(t, err)
+	assert.Equal(t, "secretsecret", string(out))
+}
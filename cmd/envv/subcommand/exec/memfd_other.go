@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package exec
+
+import (
+	"fmt"
+	"os"
+)
+
+// GetMemfd is only supported on Linux
+func GetMemfd(name string, contents []byte) (*os.File, error) {
+	return nil, fmt.Errorf("in-memory files are only supported on Linux")
+}
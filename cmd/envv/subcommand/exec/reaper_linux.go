@@ -0,0 +1,78 @@
+//go:build linux
+// +build linux
+
+package exec
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// setSubreaper sets whether the current process is a child subreaper, which
+// orphaned descendants are reparented to instead of init, so that they can
+// still be found by killDescendants
+func setSubreaper(enabled bool) {
+	var arg uintptr
+	if enabled {
+		arg = 1
+	}
+	if err := unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, arg, 0, 0, 0); err != nil {
+		log.Debugf("Failed to set child subreaper: %s", err)
+	}
+}
+
+// killDescendants kills the processes descending from the current process,
+// such as the background jobs of a shell which already exited
+func killDescendants() {
+	// Repeat until there are no descendants left, as they could be forking
+	// while being killed
+	for i := 0; i < 10; i++ {
+		children := childProcesses()
+		queue := children[os.Getpid()]
+		if len(queue) == 0 {
+			return
+		}
+		for len(queue) > 0 {
+			pid := queue[0]
+			queue = append(queue[1:], children[pid]...)
+			syscall.Kill(pid, syscall.SIGKILL)
+		}
+	}
+}
+
+// childProcesses returns the running processes by the PID of their parent
+func childProcesses() map[int][]int {
+	children := make(map[int][]int)
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return children
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		stat, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		// The fields following the command name, which is in parentheses
+		// and may contain spaces, start with the state and the parent PID
+		fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+		if len(fields) < 2 || fields[0] == "Z" {
+			continue
+		}
+		ppid, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+		children[ppid] = append(children[ppid], pid)
+	}
+	return children
+}
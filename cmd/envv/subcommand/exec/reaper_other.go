@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package exec
+
+// setSubreaper is only supported on Linux
+func setSubreaper(enabled bool) {
+}
+
+// killDescendants is only supported on Linux, elsewhere only the process
+// group of the command is killed
+func killDescendants() {
+}
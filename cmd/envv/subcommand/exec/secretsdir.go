@@ -0,0 +1,90 @@
+package exec
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/AetherVoxSanctum/envv-cli/v3"
+)
+
+// SecretFiles returns the top-level values of the branch as the contents of
+// files named after their keys, for ExecWithSecretsDir. Strings are used as
+// is, other scalars are formatted like environment variable values, and
+// branches and lists are encoded with emit.
+func SecretFiles(branch sops.TreeBranch, emit func(value interface{}) ([]byte, error)) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	for _, item := range branch {
+		if _, ok := item.Key.(sops.Comment); ok {
+			continue
+		}
+		name, ok := item.Key.(string)
+		if !ok || name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			return nil, fmt.Errorf("cannot use key %v as a file name", item.Key)
+		}
+		switch value := item.Value.(type) {
+		case sops.TreeBranch, []interface{}:
+			contents, err := emit(value)
+			if err != nil {
+				return nil, fmt.Errorf("cannot write value of %s to a file: %w", name, err)
+			}
+			files[name] = contents
+		default:
+			contents, err := envValue(value)
+			if err != nil {
+				return nil, fmt.Errorf("cannot write value of %s to a file: %w", name, err)
+			}
+			files[name] = []byte(contents)
+		}
+	}
+	return files, nil
+}
+
+// ExecWithSecretsDir runs the command with opts.SecretFiles written to a
+// temporary directory, in memory if a tmpfs is available, whose path replaces
+// "{}" in the command. The directory is removed once the command exits; to
+// make sure it is, SIGTERM and SIGHUP are forwarded to the command instead of
+// interrupting sops.
+func ExecWithSecretsDir(opts ExecOpts) error {
+	u, err := lookupUser(opts)
+	if err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp(memoryTempDir(), ".sops")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	if u != nil {
+		if err := u.chown(dir); err != nil {
+			return err
+		}
+	}
+	for name, contents := range opts.SecretFiles {
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			return fmt.Errorf("cannot use %q as a file name", name)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, contents, 0600); err != nil {
+			return err
+		}
+		if u != nil {
+			if err := u.chown(path); err != nil {
+				return err
+			}
+		}
+	}
+
+	cmd := BuildCommand(strings.Replace(opts.Command, "{}", dir, -1))
+	cmd.Env = environ(ExecOpts{Pristine: opts.Pristine, Env: opts.Env})
+	if u != nil {
+		setUser(cmd, u)
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return runForwarding(cmd, nil)
+}
@@ -0,0 +1,72 @@
+//go:build !windows
+// +build !windows
+
+package exec
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/AetherVoxSanctum/envv-cli/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretFiles(t *testing.T) {
+	branch := sops.TreeBranch{
+		sops.TreeItem{Key: sops.Comment{Value: "ignored"}},
+		sops.TreeItem{Key: "password", Value: "secret"},
+		sops.TreeItem{Key: "port", Value: 5432},
+		sops.TreeItem{Key: "db", Value: sops.TreeBranch{
+			sops.TreeItem{Key: "user", Value: "admin"},
+		}},
+		sops.TreeItem{Key: "hosts", Value: []interface{}{"a", "b"}},
+	}
+	emit := func(value interface{}) ([]byte, error) {
+		if _, ok := value.(sops.TreeBranch); ok {
+			return []byte("branch"), nil
+		}
+		return []byte("list"), nil
+	}
+
+	files, err := SecretFiles(branch, emit)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{
+		"password": []byte("secret"),
+		"port":     []byte("5432"),
+		"db":       []byte("branch"),
+		"hosts":    []byte("list"),
+	}, files)
+
+	for _, key := range []interface{}{"", "..", "a/b", 1} {
+		_, err := SecretFiles(sops.TreeBranch{sops.TreeItem{Key: key, Value: "value"}}, emit)
+		assert.ErrorContains(t, err, "as a file name")
+	}
+}
+
+func TestExecWithSecretsDir(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+
+	err := ExecWithSecretsDir(ExecOpts{
+		Command: "echo {} > " + out + "; cat {}/a {}/b >> " + out,
+		SecretFiles: map[string][]byte{
+			"a": []byte("first\n"),
+			"b": []byte("second\n"),
+		},
+	})
+	require.NoError(t, err)
+	contents, err := os.ReadFile(out)
+	require.NoError(t, err)
+	lines := strings.Split(string(contents), "\n")
+	assert.Equal(t, []string{"first", "second", ""}, lines[1:])
+	_, err = os.Stat(lines[0])
+	assert.True(t, os.IsNotExist(err), "the directory should be removed")
+
+	err = ExecWithSecretsDir(ExecOpts{
+		Command:     "true",
+		SecretFiles: map[string][]byte{"../a": []byte("value")},
+	})
+	assert.ErrorContains(t, err, "as a file name")
+}
@@ -0,0 +1,134 @@
+package exec
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// SessionEnv is set in the environment of commands run by ExecWithEnv with
+	// a TTL to the files whose secrets are in their environment, separated by
+	// os.PathListSeparator
+	SessionEnv = "ENVV_SESSION"
+	// SessionExpiresEnv is set alongside SessionEnv to the time the session
+	// expires at, in RFC 3339 format
+	SessionExpiresEnv = "ENVV_SESSION_EXPIRES"
+	// DefaultSessionTTL is the default lifetime of sessions
+	DefaultSessionTTL = time.Hour
+	// sessionKillTimeout is how long the command is given to exit after being
+	// sent SIGHUP when the session expires, before it is killed
+	sessionKillTimeout = 5 * time.Second
+)
+
+// Session is a command running with secrets in its environment until it
+// expires
+type Session struct {
+	// Files are the absolute paths of the files the secrets were decrypted
+	// from
+	Files   []string
+	Expires time.Time
+}
+
+// CurrentSession returns the session the current process runs in, if any and
+// it has not expired
+func CurrentSession() (*Session, bool) {
+	files, expires := os.Getenv(SessionEnv), os.Getenv(SessionExpiresEnv)
+	if files == "" || expires == "" {
+		return nil, false
+	}
+	t, err := time.Parse(time.RFC3339, expires)
+	if err != nil || !time.Now().Before(t) {
+		return nil, false
+	}
+	return &Session{Files: filepath.SplitList(files), Expires: t}, true
+}
+
+// Covers returns whether the secrets of all the files are in the session
+func (s *Session) Covers(files []string) bool {
+	for _, file := range files {
+		found := false
+		for _, f := range s.Files {
+			if f == file {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Session) environ() []string {
+	return []string{
+		SessionEnv + "=" + strings.Join(s.Files, string(os.PathListSeparator)),
+		SessionExpiresEnv + "=" + s.Expires.Format(time.RFC3339),
+	}
+}
+
+// runForwarding runs the command in its own process group until it exits,
+// forwarding SIGTERM and SIGHUP to the group. If the session is not nil and
+// expires first, the group is sent SIGHUP, which interactive shells exit on,
+// and killed if the command doesn't exit in time. Once it has exited, the
+// processes it left behind, such as background jobs, are killed as well, so
+// that none of them keep the secrets after the session expired.
+func runForwarding(cmd *exec.Cmd, session *Session) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+
+	foreground := setProcessGroup(cmd)
+	if session != nil {
+		setSubreaper(true)
+		defer setSubreaper(false)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	var expired <-chan time.Time
+	if session != nil {
+		timer := time.NewTimer(time.Until(session.Expires))
+		defer timer.Stop()
+		expired = timer.C
+	}
+	var kill <-chan time.Time
+	for {
+		select {
+		case sig := <-signals:
+			// Interrupts from the terminal are received by a command in its
+			// foreground directly, it decides whether to exit on them
+			if sig == os.Interrupt && foreground {
+				continue
+			}
+			if err := signalGroup(cmd.Process, sig); err != nil {
+				log.Warnf("Failed to forward %s to the command: %s", sig, err)
+			}
+		case <-expired:
+			log.Warn("The session expired, stopping the command")
+			if err := signalGroup(cmd.Process, syscall.SIGHUP); err != nil {
+				signalGroup(cmd.Process, os.Kill)
+			}
+			kill = time.After(sessionKillTimeout)
+		case <-kill:
+			log.Warnf("Command did not exit within %s, killing it", sessionKillTimeout)
+			signalGroup(cmd.Process, os.Kill)
+		case err := <-done:
+			if kill != nil {
+				signalGroup(cmd.Process, os.Kill)
+				killDescendants()
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,81 @@
+//go:build !windows
+// +build !windows
+
+package exec
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCurrentSession(t *testing.T) {
+	t.Setenv(SessionEnv, "")
+	_, ok := CurrentSession()
+	assert.False(t, ok)
+
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	t.Setenv(SessionEnv, "/secrets/a.enc.env"+string(os.PathListSeparator)+"/secrets/b.enc.yaml")
+	t.Setenv(SessionExpiresEnv, expires.Format(time.RFC3339))
+	session, ok := CurrentSession()
+	require.True(t, ok)
+	assert.Equal(t, []string{"/secrets/a.enc.env", "/secrets/b.enc.yaml"}, session.Files)
+	assert.True(t, expires.Equal(session.Expires))
+	assert.True(t, session.Covers([]string{"/secrets/b.enc.yaml"}))
+	assert.True(t, session.Covers([]string{"/secrets/a.enc.env", "/secrets/b.enc.yaml"}))
+	assert.False(t, session.Covers([]string{"/secrets/a.enc.env", "/secrets/c.enc.env"}))
+
+	t.Setenv(SessionExpiresEnv, time.Now().Add(-time.Minute).Format(time.RFC3339))
+	_, ok = CurrentSession()
+	assert.False(t, ok, "expired sessions should be ignored")
+}
+
+func TestExecWithEnv_TTL(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	start := time.Now()
+	err := ExecWithEnv(ExecOpts{
+		Command:      `echo "$SECRET $` + SessionEnv + `" > ` + out + `; exec sleep 10`,
+		Env:          []string{"SECRET=value"},
+		TTL:          200 * time.Millisecond,
+		SessionFiles: []string{"/secrets/a.enc.env"},
+	})
+	assert.Less(t, time.Since(start), 5*time.Second)
+	var exitErr *exec.ExitError
+	require.ErrorAs(t, err, &exitErr)
+	status := exitErr.Sys().(syscall.WaitStatus)
+	assert.Equal(t, syscall.SIGHUP, status.Signal())
+
+	contents, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, "value /secrets/a.enc.env", strings.TrimSpace(string(contents)))
+
+	assert.NoError(t, ExecWithEnv(ExecOpts{Command: "true", TTL: time.Hour}))
+	assert.Error(t, ExecWithEnv(ExecOpts{Command: "true", TTL: time.Hour, Background: true}))
+}
+
+func TestExecWithEnv_TTLKillsBackgroundJobs(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	err := ExecWithEnv(ExecOpts{
+		// The job ignores SIGHUP, like nohup, and is orphaned when the shell
+		// exits
+		Command: "(trap '' HUP; sleep 10) & echo $! > " + pidFile + "; wait",
+		TTL:     200 * time.Millisecond,
+	})
+	require.Error(t, err)
+
+	contents, err := os.ReadFile(pidFile)
+	require.NoError(t, err)
+	pid := strings.TrimSpace(string(contents))
+	assert.Eventually(t, func() bool {
+		stat, err := os.ReadFile(filepath.Join("/proc", pid, "stat"))
+		// Killed processes may remain as zombies until they are reaped
+		return err != nil || strings.Contains(string(stat), ") Z ")
+	}, 5*time.Second, 10*time.Millisecond, "background job is still running")
+}
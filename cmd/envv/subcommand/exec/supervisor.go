@@ -0,0 +1,191 @@
+package exec
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const (
+	// DefaultPollInterval is how often Supervise checks the watched files for
+	// changes by default
+	DefaultPollInterval = time.Second
+	// DefaultStopTimeout is how long Supervise waits for the command to exit
+	// after sending it SIGTERM before killing it by default
+	DefaultStopTimeout = 10 * time.Second
+)
+
+// forwardedSignals are passed on by Supervise to the running command
+var forwardedSignals = []os.Signal{syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP}
+
+// WatchOpts configures how Supervise reacts to changes of the encrypted files
+type WatchOpts struct {
+	// Files are checked for changes to their contents every PollInterval
+	Files        []string
+	PollInterval time.Duration
+	// Reload decrypts the files again after they changed, returning the
+	// variables to insert into the environment of the restarted command
+	Reload func() ([]string, error)
+	// Signal, if set, is sent to the command when the files change instead of
+	// restarting it
+	Signal os.Signal
+	// StopTimeout is how long to wait for the command to exit when restarting
+	// it before killing it
+	StopTimeout time.Duration
+}
+
+// child is a running command started by Supervise
+type child struct {
+	cmd  *exec.Cmd
+	done chan error
+	// foreground is whether the command is in the foreground of the terminal,
+	// and so receives the signals sent by it directly
+	foreground bool
+}
+
+func startChild(opts ExecOpts, u *User) (*child, error) {
+	cmd := BuildCommand(opts.Command)
+	cmd.Env = environ(opts)
+	if u != nil {
+		setUser(cmd, u)
+	}
+	cmd.Stdin = os.Stdin
+	foreground := setProcessGroup(cmd)
+	flush := setOutput(cmd, opts)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	c := &child{cmd: cmd, done: make(chan error, 1), foreground: foreground}
+	go func() {
+		err := cmd.Wait()
+		flush()
+		c.done <- err
+	}()
+	return c, nil
+}
+
+// stop asks the processes of the command to exit with SIGTERM and kills them
+// if the command hasn't exited after the timeout
+func (c *child) stop(timeout time.Duration) {
+	if err := signalGroup(c.cmd.Process, syscall.SIGTERM); err != nil {
+		signalGroup(c.cmd.Process, os.Kill)
+	}
+	select {
+	case <-c.done:
+	case <-time.After(timeout):
+		log.Warnf("Command did not exit within %s, killing it", timeout)
+		signalGroup(c.cmd.Process, os.Kill)
+		<-c.done
+	}
+}
+
+// Supervise runs the command in a child process until it exits, forwarding
+// SIGTERM, SIGINT and SIGHUP to its process group. Whenever the contents of the watched files
+// change and settle, the command is sent the configured signal, or restarted with the
+// environment returned by the reload function. The returned error is the one
+// of the last run of the command, so its exit code can be propagated.
+func Supervise(opts ExecOpts, watch WatchOpts) error {
+	u, err := lookupUser(opts)
+	if err != nil {
+		return err
+	}
+	if watch.PollInterval <= 0 {
+		watch.PollInterval = DefaultPollInterval
+	}
+	if watch.StopTimeout <= 0 {
+		watch.StopTimeout = DefaultStopTimeout
+	}
+
+	sums, err := checksums(watch.Files)
+	if err != nil {
+		return err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+
+	ticker := time.NewTicker(watch.PollInterval)
+	defer ticker.Stop()
+
+	c, err := startChild(opts, u)
+	if err != nil {
+		return err
+	}
+	var pending []byte
+	for {
+		select {
+		case sig := <-signals:
+			// Interrupts from the terminal are received by a command in its
+			// foreground directly
+			if sig == os.Interrupt && c.foreground {
+				continue
+			}
+			log.Debugf("Forwarding %s to the command", sig)
+			if err := signalGroup(c.cmd.Process, sig); err != nil {
+				log.Warnf("Failed to forward %s to the command: %s", sig, err)
+			}
+		case err := <-c.done:
+			return err
+		case <-ticker.C:
+			current, err := checksums(watch.Files)
+			if err != nil {
+				// Editors may replace files instead of writing them in place,
+				// so they can briefly be missing
+				log.Debugf("Failed to read the watched files: %s", err)
+				continue
+			}
+			if bytes.Equal(current, sums) {
+				pending = nil
+				continue
+			}
+			// Only act once the files haven't changed for an interval, so
+			// they aren't decrypted while still being written
+			if !bytes.Equal(current, pending) {
+				pending = current
+				continue
+			}
+			sums, pending = current, nil
+
+			if watch.Signal != nil {
+				log.Infof("Encrypted files changed, sending %s to the command", watch.Signal)
+				if err := signalGroup(c.cmd.Process, watch.Signal); err != nil {
+					log.Warnf("Failed to send %s to the command: %s", watch.Signal, err)
+				}
+				continue
+			}
+
+			env, err := watch.Reload()
+			if err != nil {
+				log.Errorf("Failed to decrypt the changed files, keeping the command running: %s", err)
+				continue
+			}
+			log.Info("Encrypted files changed, restarting the command")
+			c.stop(watch.StopTimeout)
+			opts.Env = env
+			if c, err = startChild(opts, u); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// checksums returns the concatenated SHA-256 hashes of the contents of the
+// files
+func checksums(files []string) ([]byte, error) {
+	var sums []byte
+	for _, file := range files {
+		contents, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		sum := sha256.Sum256(contents)
+		sums = append(sums, sum[:]...)
+	}
+	return sums, nil
+}
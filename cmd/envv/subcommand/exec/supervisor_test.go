@@ -0,0 +1,174 @@
+//go:build !windows
+// +build !windows
+
+package exec
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// waitForFile waits until the file has the expected number of lines
+func waitForFile(t *testing.T, path string, lines int) {
+	require.Eventually(t, func() bool {
+		contents, err := os.ReadFile(path)
+		return err == nil && strings.Count(string(contents), "\n") >= lines
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestSupervise_restart(t *testing.T) {
+	dir := t.TempDir()
+	secrets := filepath.Join(dir, "secrets.enc.env")
+	out := filepath.Join(dir, "out")
+	require.NoError(t, os.WriteFile(secrets, []byte("v1"), 0600))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- Supervise(ExecOpts{
+			Command:  "echo $VALUE >> " + out + `; [ "$VALUE" = second ] && exit 3; exec sleep 10`,
+			Pristine: true,
+			Env:      []string{"VALUE=first"},
+		}, WatchOpts{
+			Files:        []string{secrets},
+			PollInterval: 10 * time.Millisecond,
+			Reload: func() ([]string, error) {
+				return []string{"VALUE=second"}, nil
+			},
+		})
+	}()
+
+	waitForFile(t, out, 1)
+	require.NoError(t, os.WriteFile(secrets, []byte("v2"), 0600))
+
+	select {
+	case err := <-done:
+		var exitErr *exec.ExitError
+		require.ErrorAs(t, err, &exitErr)
+		assert.Equal(t, 3, exitErr.ExitCode())
+	case <-time.After(5 * time.Second):
+		t.Fatal("command was not restarted")
+	}
+	contents, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, "first\nsecond\n", string(contents))
+}
+
+func TestSupervise_restartStopsProcessGroup(t *testing.T) {
+	dir := t.TempDir()
+	secrets := filepath.Join(dir, "secrets.enc.env")
+	out := filepath.Join(dir, "out")
+	require.NoError(t, os.WriteFile(secrets, []byte("v1"), 0600))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- Supervise(ExecOpts{
+			// The shell does not exec the server, which must be stopped too
+			Command: `[ "$VALUE" = second ] && exit 3; ` +
+				"(trap 'echo stopped >> " + out + "; exit' TERM; echo started >> " + out + "; while true; do sleep 0.01; done) & wait",
+			Pristine: true,
+			Env:      []string{"VALUE=first"},
+		}, WatchOpts{
+			Files:        []string{secrets},
+			PollInterval: 10 * time.Millisecond,
+			Reload: func() ([]string, error) {
+				return []string{"VALUE=second"}, nil
+			},
+		})
+	}()
+
+	waitForFile(t, out, 1)
+	require.NoError(t, os.WriteFile(secrets, []byte("v2"), 0600))
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("command was not restarted")
+	}
+	waitForFile(t, out, 2)
+	contents, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, "started\nstopped\n", string(contents))
+}
+
+func TestSupervise_signal(t *testing.T) {
+	dir := t.TempDir()
+	secrets := filepath.Join(dir, "secrets.enc.env")
+	out := filepath.Join(dir, "out")
+	require.NoError(t, os.WriteFile(secrets, []byte("v1"), 0600))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- Supervise(ExecOpts{
+			Command: "trap 'exit 4' USR1; echo started >> " + out + "; while true; do sleep 0.01; done",
+		}, WatchOpts{
+			Files:        []string{secrets},
+			PollInterval: 10 * time.Millisecond,
+			Signal:       syscall.SIGUSR1,
+			Reload: func() ([]string, error) {
+				t.Error("files should not be reloaded")
+				return nil, nil
+			},
+		})
+	}()
+
+	waitForFile(t, out, 1)
+	require.NoError(t, os.WriteFile(secrets, []byte("v2"), 0600))
+
+	select {
+	case err := <-done:
+		var exitErr *exec.ExitError
+		require.ErrorAs(t, err, &exitErr)
+		assert.Equal(t, 4, exitErr.ExitCode())
+	case <-time.After(5 * time.Second):
+		t.Fatal("command was not signaled")
+	}
+}
+
+func TestSupervise_forwardSignals(t *testing.T) {
+	dir := t.TempDir()
+	secrets := filepath.Join(dir, "secrets.enc.env")
+	out := filepath.Join(dir, "out")
+	require.NoError(t, os.WriteFile(secrets, []byte("v1"), 0600))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- Supervise(ExecOpts{
+			Command: "trap 'exit 5' HUP; echo started >> " + out + "; while true; do sleep 0.01; done",
+		}, WatchOpts{
+			Files: []string{secrets},
+		})
+	}()
+
+	waitForFile(t, out, 1)
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+
+	select {
+	case err := <-done:
+		var exitErr *exec.ExitError
+		require.ErrorAs(t, err, &exitErr)
+		assert.Equal(t, 5, exitErr.ExitCode())
+	case <-time.After(5 * time.Second):
+		t.Fatal("signal was not forwarded")
+	}
+}
+
+func TestParseSignal(t *testing.T) {
+	sig, err := ParseSignal("hup")
+	require.NoError(t, err)
+	assert.Equal(t, syscall.SIGHUP, sig)
+
+	sig, err = ParseSignal("SIGUSR2")
+	require.NoError(t, err)
+	assert.Equal(t, syscall.SIGUSR2, sig)
+
+	_, err = ParseSignal("NOPE")
+	assert.Error(t, err)
+}
@@ -0,0 +1,27 @@
+//go:build linux
+// +build linux
+
+package exec
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// memoryTempDir returns a directory on a tmpfs to create temporary
+// directories in, or an empty string for the default temporary directory if
+// there is none
+func memoryTempDir() string {
+	for _, dir := range []string{"/dev/shm", os.TempDir(), os.Getenv("XDG_RUNTIME_DIR")} {
+		if dir == "" {
+			continue
+		}
+		var fs unix.Statfs_t
+		if unix.Statfs(dir, &fs) == nil && int64(fs.Type) == unix.TMPFS_MAGIC && unix.Access(dir, unix.W_OK) == nil {
+			return dir
+		}
+	}
+	log.Warn("No writable tmpfs found, secrets are written to the default temporary directory")
+	return ""
+}
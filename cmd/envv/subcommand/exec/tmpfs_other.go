@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package exec
+
+// memoryTempDir returns an empty string for the default temporary directory,
+// as finding a tmpfs is only supported on Linux
+func memoryTempDir() string {
+	return ""
+}
@@ -0,0 +1,42 @@
+package exec
+
+import (
+	"os"
+	"strings"
+)
+
+// User is a user to run commands as
+type User struct {
+	Name    string
+	HomeDir string
+	Uid     uint32
+	Gid     uint32
+	Groups  []uint32
+}
+
+// lookupUser returns the user to run the command as, or nil to run it as the
+// current user
+func lookupUser(opts ExecOpts) (*User, error) {
+	if opts.User == "" {
+		return nil, nil
+	}
+	return LookupUser(opts.User)
+}
+
+// environ returns the environment with HOME, USER and LOGNAME set for the user
+func (u *User) environ(env []string) []string {
+	filtered := make([]string, 0, len(env)+3)
+	for _, variable := range env {
+		switch name, _, _ := strings.Cut(variable, "="); name {
+		case "HOME", "USER", "LOGNAME":
+			continue
+		}
+		filtered = append(filtered, variable)
+	}
+	return append(filtered, "HOME="+u.HomeDir, "USER="+u.Name, "LOGNAME="+u.Name)
+}
+
+// chown gives the user ownership of the file, so the command can read it
+func (u *User) chown(name string) error {
+	return os.Chown(name, int(u.Uid), int(u.Gid))
+}
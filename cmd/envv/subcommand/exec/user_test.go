@@ -0,0 +1,13 @@
+package exec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUser_environ(t *testing.T) {
+	u := &User{Name: "app", HomeDir: "/home/app"}
+	env := u.environ([]string{"HOME=/root", "PATH=/bin", "USER=root", "LOGNAME=root", "HOMEPAGE=x"})
+	assert.Equal(t, []string{"PATH=/bin", "HOMEPAGE=x", "HOME=/home/app", "USER=app", "LOGNAME=app"}, env)
+}
@@ -17,15 +17,60 @@ type Opts struct {
 type Status struct {
 	// Encrypted represents whether the file provided is encrypted by SOPS
 	Encrypted bool `json:"encrypted"`
+	// KeyVersions lists the version of the master key each data key was
+	// encrypted with, for master keys which are versioned
+	KeyVersions []KeyVersion `json:"key_versions,omitempty"`
+}
+
+// KeyVersion represents the version of a master key
+type KeyVersion struct {
+	// Type is the identifier of the master key type
+	Type string `json:"type"`
+	// Key is the string representation of the master key
+	Key string `json:"key"`
+	// Version is the version the data key was encrypted with
+	Version int `json:"version"`
 }
 
 // FileStatus checks encryption status of a file
 func FileStatus(opts Opts) (Status, error) {
-	encrypted, err := cfs(opts.InputStore, opts.InputPath)
+	tree, encrypted, err := loadTree(opts.InputStore, opts.InputPath)
 	if err != nil {
 		return Status{}, fmt.Errorf("cannot check file status: %w", err)
 	}
-	return Status{Encrypted: encrypted}, nil
+	if !encrypted {
+		return Status{}, nil
+	}
+	return Status{Encrypted: true, KeyVersions: keyVersions(tree)}, nil
+}
+
+// versionedKey is implemented by master keys which can report the version
+// of the master key the data key was encrypted with.
+type versionedKey interface {
+	KeyVersion() (int, error)
+}
+
+// keyVersions returns the versions of the versioned master keys of the tree.
+func keyVersions(tree *sops.Tree) []KeyVersion {
+	var versions []KeyVersion
+	for _, group := range tree.Metadata.KeyGroups {
+		for _, key := range group {
+			versioned, ok := key.(versionedKey)
+			if !ok {
+				continue
+			}
+			version, err := versioned.KeyVersion()
+			if err != nil {
+				continue
+			}
+			versions = append(versions, KeyVersion{
+				Type:    key.TypeToIdentifier(),
+				Key:     key.ToString(),
+				Version: version,
+			})
+		}
+	}
+	return versions
 }
 
 // cfs checks and reports on file encryption status.
@@ -38,23 +83,30 @@ func FileStatus(opts Opts) (Status, error) {
 // sops.MetadataNotFound, as that is used to detect a sops
 // encrypted file.
 func cfs(s sops.Store, inputpath string) (bool, error) {
+	_, encrypted, err := loadTree(s, inputpath)
+	return encrypted, err
+}
+
+// loadTree loads the input file with the provided store, and returns
+// the tree and whether it is encrypted, as reported by cfs.
+func loadTree(s sops.Store, inputpath string) (*sops.Tree, bool, error) {
 	tree, err := common.LoadEncryptedFile(s, inputpath)
 	if err != nil && err == sops.MetadataNotFound {
-		return false, nil
+		return nil, false, nil
 	}
 	if err != nil {
-		return false, fmt.Errorf("cannot load encrypted file: %w", err)
+		return nil, false, fmt.Errorf("cannot load encrypted file: %w", err)
 	}
 
 	// NOTE: even if it's a file that sops recognize as containing
 	// valid metadata, we want to ensure some metadata are present
 	// to report the file as encrypted.
 	if tree.Metadata.Version == "" {
-		return false, nil
+		return nil, false, nil
 	}
 	if tree.Metadata.MessageAuthenticationCode == "" {
-		return false, nil
+		return nil, false, nil
 	}
 
-	return true, nil
+	return tree, true, nil
 }
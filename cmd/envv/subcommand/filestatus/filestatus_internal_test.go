@@ -4,8 +4,12 @@ import (
 	"path"
 	"testing"
 
+	"github.com/AetherVoxSanctum/envv-cli/v3"
+	"github.com/AetherVoxSanctum/envv-cli/v3/age"
 	"github.com/AetherVoxSanctum/envv-cli/v3/cmd/envv/common"
 	"github.com/AetherVoxSanctum/envv-cli/v3/config"
+	"github.com/AetherVoxSanctum/envv-cli/v3/gcpkms"
+	"github.com/AetherVoxSanctum/envv-cli/v3/hcvault"
 	"github.com/stretchr/testify/require"
 )
 
@@ -50,3 +54,25 @@ func TestFileStatus(t *testing.T) {
 		})
 	}
 }
+
+func TestKeyVersions(t *testing.T) {
+	tree := &sops.Tree{
+		Metadata: sops.Metadata{
+			KeyGroups: []sops.KeyGroup{
+				{
+					&hcvault.MasterKey{VaultAddress: "https://vault.example.com", EnginePath: "transit", KeyName: "sops", EncryptedKey: "vault:v3:Zm9v"},
+					&hcvault.MasterKey{VaultAddress: "https://vault.example.com", EnginePath: "transit", KeyName: "other", EncryptedKey: "invalid"},
+					&age.MasterKey{Recipient: "age1s3cqcks5genc6ru8chl0hkkd04zmxvczsvdxq99ekffe4gmvjpzsedk23c"},
+					&gcpkms.MasterKey{ResourceID: "projects/p/locations/global/keyRings/r/cryptoKeys/k/cryptoKeyVersions/2", EncryptedKey: "gcpkms:v2:Zm9v"},
+					&gcpkms.MasterKey{ResourceID: "projects/p/locations/global/keyRings/r/cryptoKeys/k", EncryptedKey: "Zm9v"},
+					&gcpkms.MasterKey{ResourceID: "projects/p/locations/global/keyRings/r/cryptoKeys/other", Version: "7", EncryptedKey: "Zm9v"},
+				},
+			},
+		},
+	}
+	require.Equal(t, []KeyVersion{
+		{Type: hcvault.KeyTypeIdentifier, Key: "https://vault.example.com/v1/transit/keys/sops", Version: 3},
+		{Type: gcpkms.KeyTypeIdentifier, Key: "projects/p/locations/global/keyRings/r/cryptoKeys/k/cryptoKeyVersions/2", Version: 2},
+		{Type: gcpkms.KeyTypeIdentifier, Key: "projects/p/locations/global/keyRings/r/cryptoKeys/other", Version: 7},
+	}, keyVersions(tree))
+}
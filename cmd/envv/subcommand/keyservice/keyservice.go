@@ -1,15 +1,23 @@
 package keyservice
 
 import (
+	"context"
+	"crypto/rand"
+	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/AetherVoxSanctum/envv-cli/v3/age"
 	"github.com/AetherVoxSanctum/envv-cli/v3/keyservice"
 	"github.com/AetherVoxSanctum/envv-cli/v3/logging"
 
 	"github.com/sirupsen/logrus"
+	"golang.org/x/term"
 	"google.golang.org/grpc"
 )
 
@@ -24,29 +32,237 @@ type Opts struct {
 	Network string
 	Address string
 	Prompt  bool
+	// PromptCommand is a command run to approve every incoming request
+	PromptCommand string
+	// PromptHTTPAddress is an address on which an HTTP endpoint for
+	// approving incoming requests is served
+	PromptHTTPAddress string
+	// PromptHTTPToken is the bearer token required by the HTTP approval
+	// endpoint. If empty, a random one is generated and logged.
+	PromptHTTPToken string
+	// PromptTimeout is the time after which unanswered requests are denied
+	PromptTimeout time.Duration
+	// DrainTimeout is the time in-flight requests are given to complete on
+	// shutdown before they are cancelled. Zero waits indefinitely.
+	DrainTimeout time.Duration
+	// SocketMode are the permissions set on a Unix socket, if not zero
+	SocketMode os.FileMode
+	// SocketOwner is the "user[:group]" set as owner of a Unix socket, if
+	// not empty
+	SocketOwner string
+	// Upstreams are the upstream key service definitions requests are
+	// forwarded to, see keyservice.ParseUpstream. If empty, requests are
+	// fulfilled locally.
+	Upstreams []string
+	// HTTPAddress is a TCP address on which an HTTP/JSON gateway to the
+	// key service is served, if not empty
+	HTTPAddress string
+	// HTTPToken is the bearer token required by the HTTP/JSON gateway. If
+	// empty, a random one is generated and logged.
+	HTTPToken string
+	// AgeKeyFiles are age identity files used to decrypt data, instead of
+	// the ones configured through the SOPS_AGE_KEY* environment variables
+	AgeKeyFiles []string
+}
+
+// ageIdentities loads the identities from the configured age key files
+func (opts Opts) ageIdentities() (age.ParsedIdentities, error) {
+	var identities age.ParsedIdentities
+	for _, path := range opts.AgeKeyFiles {
+		if err := identities.ImportFile(path); err != nil {
+			return nil, err
+		}
+	}
+	return identities, nil
+}
+
+// server returns the keyservice.KeyServiceServer configured by the options
+func (opts Opts) server(approver keyservice.Approver) (keyservice.KeyServiceServer, error) {
+	identities, err := opts.ageIdentities()
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.Upstreams) == 0 {
+		return keyservice.Server{Approver: approver, AgeIdentities: identities}, nil
+	}
+	var upstreams []keyservice.Upstream
+	for _, definition := range opts.Upstreams {
+		upstream, err := keyservice.ParseUpstream(definition)
+		if err != nil {
+			return nil, err
+		}
+		if upstream.Name == keyservice.LocalUpstream {
+			upstream.Client = keyservice.NewCustomLocalClient(keyservice.Server{AgeIdentities: identities})
+		}
+		upstreams = append(upstreams, upstream)
+	}
+	return keyservice.ProxyServer{
+		Upstreams: upstreams,
+		Approver:  approver,
+	}, nil
+}
+
+// approver returns the keyservice.Approver configured by the options, if any
+func (opts Opts) approver() (keyservice.Approver, error) {
+	switch {
+	case opts.PromptCommand != "" && opts.PromptHTTPAddress != "":
+		return nil, fmt.Errorf("cannot use both a prompt command and a prompt HTTP address")
+	case opts.PromptCommand != "":
+		return keyservice.CommandApprover{
+			Command: opts.PromptCommand,
+			Timeout: opts.PromptTimeout,
+		}, nil
+	case opts.PromptHTTPAddress != "":
+		token := opts.PromptHTTPToken
+		if token == "" {
+			var err error
+			if token, err = generateToken("approval endpoint", "--prompt-http-token"); err != nil {
+				return nil, err
+			}
+		}
+		return keyservice.NewHTTPApprover(opts.PromptTimeout, token), nil
+	case opts.Prompt:
+		return keyservice.NewTTYApprover(os.Stdin, os.Stdout, opts.PromptTimeout), nil
+	}
+	return nil, nil
+}
+
+// generateToken generates a random bearer token for the named endpoint. The
+// token is printed once to stderr instead of being logged, so that it does not
+// end up in log files, and only if stderr is a terminal: otherwise the token
+// must be given with the flag.
+func generateToken(endpoint, flag string) (string, error) {
+	if !term.IsTerminal(int(os.Stderr.Fd())) {
+		return "", fmt.Errorf("%s is required when stderr is not a terminal", flag)
+	}
+	token := rand.Text()
+	fmt.Fprintf(os.Stderr, "Bearer token of the %s: %s\n", endpoint, token)
+	return token, nil
 }
 
 // Run runs a SOPS key service server
 func Run(opts Opts) error {
-	lis, err := net.Listen(opts.Network, opts.Address)
+	approver, err := opts.approver()
+	if err != nil {
+		return err
+	}
+	server, err := opts.server(approver)
+	if err != nil {
+		return err
+	}
+	lis, err := listen(opts)
 	if err != nil {
 		return err
 	}
 	defer lis.Close()
-	grpcServer := grpc.NewServer()
-	keyservice.RegisterKeyServiceServer(grpcServer, keyservice.Server{
-		Prompt: opts.Prompt,
-	})
-	log.Infof("Listening on %s://%s", opts.Network, opts.Address)
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(keyservice.AuditInterceptor))
+	keyservice.RegisterKeyServiceServer(grpcServer, server)
+	// Errors serving HTTP shut the key service down
+	serveErrs := make(chan error, 2)
+	var gatewayServer *http.Server
+	if opts.HTTPAddress != "" {
+		token := opts.HTTPToken
+		if token == "" {
+			if token, err = generateToken("HTTP gateway", "--http-token"); err != nil {
+				return err
+			}
+		}
+		gatewayLis, err := net.Listen("tcp", opts.HTTPAddress)
+		if err != nil {
+			return err
+		}
+		gatewayServer = &http.Server{Handler: keyservice.NewGateway(server, token)}
+		go func() {
+			log.Infof("Serving HTTP gateway on http://%s", gatewayLis.Addr())
+			if err := gatewayServer.Serve(gatewayLis); err != nil && err != http.ErrServerClosed {
+				serveErrs <- fmt.Errorf("error serving HTTP gateway: %w", err)
+			}
+		}()
+	}
+	var approvalServer *http.Server
+	if httpApprover, ok := approver.(*keyservice.HTTPApprover); ok {
+		approvalLis, err := net.Listen("tcp", opts.PromptHTTPAddress)
+		if err != nil {
+			return err
+		}
+		approvalServer = &http.Server{Handler: httpApprover}
+		go func() {
+			log.Infof("Serving approval endpoint on http://%s", approvalLis.Addr())
+			if err := approvalServer.Serve(approvalLis); err != nil && err != http.ErrServerClosed {
+				serveErrs <- fmt.Errorf("error serving approval endpoint: %w", err)
+			}
+		}()
+	}
+	log.Infof("Listening on %s://%s", lis.Addr().Network(), lis.Addr().String())
 
-	// Close socket if we get killed
+	// Drain in-flight requests and close the socket if we get killed
 	sigc := make(chan os.Signal, 1)
-	signal.Notify(sigc, os.Interrupt, os.Kill, syscall.SIGTERM)
-	go func(c chan os.Signal) {
-		sig := <-c
-		log.Infof("Caught signal %s: shutting down.", sig)
-		lis.Close()
-		os.Exit(0)
-	}(sigc)
-	return grpcServer.Serve(lis)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigc)
+	stopped := make(chan struct{})
+	var serveErr error
+	go func() {
+		defer close(stopped)
+		select {
+		case sig := <-sigc:
+			log.Infof("Caught signal %s: shutting down.", sig)
+		case serveErr = <-serveErrs:
+			log.Errorf("%s: shutting down.", serveErr)
+		}
+		var wg sync.WaitGroup
+		if gatewayServer != nil {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				shutdownHTTP(gatewayServer, opts.DrainTimeout)
+			}()
+		}
+		gracefulStop(grpcServer, opts.DrainTimeout)
+		wg.Wait()
+		if approvalServer != nil {
+			approvalServer.Close()
+		}
+	}()
+	if err := grpcServer.Serve(lis); err != nil {
+		return err
+	}
+	// Serve only returns without an error once shutdown has started
+	<-stopped
+	return serveErr
+}
+
+// gracefulStop stops the server from accepting new requests and waits for
+// in-flight requests to complete, for at most timeout if it is not zero
+func gracefulStop(s *grpc.Server, timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	if timeout <= 0 {
+		<-done
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Warnf("Requests still in flight after %s, cancelling them.", timeout)
+		s.Stop()
+		<-done
+	}
+}
+
+// shutdownHTTP stops the server from accepting new requests and waits for
+// in-flight requests to complete, for at most timeout if it is not zero
+func shutdownHTTP(s *http.Server, timeout time.Duration) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if err := s.Shutdown(ctx); err != nil {
+		log.Warnf("HTTP requests still in flight after %s, cancelling them.", timeout)
+		s.Close()
+	}
 }
@@ -0,0 +1,154 @@
+package keyservice
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// listenPidEnv and listenFdsEnv are set by systemd when passing sockets
+	// to a socket activated service, see sd_listen_fds(3)
+	listenPidEnv     = "LISTEN_PID"
+	listenFdsEnv     = "LISTEN_FDS"
+	listenFdNamesEnv = "LISTEN_FDNAMES"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd
+var listenFdsStart = 3
+
+// listen returns the listener the key service should serve on. A socket passed
+// through systemd socket activation takes precedence over the configured
+// network and address.
+func listen(opts Opts) (net.Listener, error) {
+	lis, err := systemdListener()
+	if err != nil {
+		return nil, err
+	}
+	if lis != nil {
+		log.Infof("Using socket passed by systemd, ignoring network and address options")
+		return lis, nil
+	}
+	if opts.Network != "unix" {
+		return net.Listen(opts.Network, opts.Address)
+	}
+
+	if err := removeStaleSocket(opts.Address); err != nil {
+		return nil, err
+	}
+	lis, err = listenUnix(opts.Address)
+	if err != nil {
+		return nil, err
+	}
+	if opts.SocketMode != 0 {
+		if err := os.Chmod(opts.Address, opts.SocketMode); err != nil {
+			lis.Close()
+			return nil, fmt.Errorf("could not set permissions of socket %s: %w", opts.Address, err)
+		}
+	}
+	if opts.SocketOwner != "" {
+		if err := chownSocket(opts.Address, opts.SocketOwner); err != nil {
+			lis.Close()
+			return nil, err
+		}
+	}
+	return lis, nil
+}
+
+// systemdListener returns the first socket passed through systemd socket
+// activation, or nil if the process was not socket activated
+func systemdListener() (net.Listener, error) {
+	fds, ok := os.LookupEnv(listenFdsEnv)
+	if !ok {
+		return nil, nil
+	}
+	if os.Getenv(listenPidEnv) != strconv.Itoa(os.Getpid()) {
+		// The sockets were meant for another process
+		return nil, nil
+	}
+	// Do not pass the sockets on to child processes
+	os.Unsetenv(listenPidEnv)
+	os.Unsetenv(listenFdsEnv)
+	os.Unsetenv(listenFdNamesEnv)
+
+	n, err := strconv.Atoi(fds)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value %q for %s: %w", fds, listenFdsEnv, err)
+	}
+	if n < 1 {
+		return nil, nil
+	}
+	if n > 1 {
+		log.Warnf("systemd passed %d sockets, only the first one will be used", n)
+	}
+	f := os.NewFile(uintptr(listenFdsStart), "systemd-socket")
+	defer f.Close()
+	lis, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("could not use socket passed by systemd: %w", err)
+	}
+	return lis, nil
+}
+
+// removeStaleSocket removes a Unix socket left behind by a previous key service
+// that did not shut down cleanly. It refuses to remove anything that is not a
+// socket, or a socket another process is still listening on.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("cannot listen on %s: file exists and is not a socket", path)
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("cannot listen on %s: socket is in use by another process", path)
+	}
+	log.Infof("Removing stale socket %s", path)
+	return os.Remove(path)
+}
+
+// chownSocket changes the owner of the socket at path. owner has the form
+// "user", "user:group" or ":group", where user and group are names or
+// numeric IDs.
+func chownSocket(path, owner string) error {
+	uid, gid := -1, -1
+	userName, groupName, _ := strings.Cut(owner, ":")
+	if userName != "" {
+		u, err := user.Lookup(userName)
+		if err != nil {
+			u, err = user.LookupId(userName)
+		}
+		if err != nil {
+			return fmt.Errorf("could not look up socket owner %q: %w", userName, err)
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return fmt.Errorf("could not use socket owner %q: %w", userName, err)
+		}
+	}
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			g, err = user.LookupGroupId(groupName)
+		}
+		if err != nil {
+			return fmt.Errorf("could not look up socket group %q: %w", groupName, err)
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return fmt.Errorf("could not use socket group %q: %w", groupName, err)
+		}
+	}
+	if err := os.Chown(path, uid, gid); err != nil {
+		return fmt.Errorf("could not change owner of socket %s: %w", path, err)
+	}
+	return nil
+}
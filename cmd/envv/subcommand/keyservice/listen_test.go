@@ -0,0 +1,112 @@
+//go:build !windows
+
+package keyservice
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenUnixSocket(t *testing.T) {
+	t.Run("removes stale socket", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "sops.sock")
+		stale, err := net.Listen("unix", path)
+		require.NoError(t, err)
+		// Leave the socket file behind, like a killed process would.
+		stale.(*net.UnixListener).SetUnlinkOnClose(false)
+		require.NoError(t, stale.Close())
+		require.FileExists(t, path)
+
+		lis, err := listen(Opts{Network: "unix", Address: path})
+		require.NoError(t, err)
+		defer lis.Close()
+	})
+
+	t.Run("refuses socket in use", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "sops.sock")
+		other, err := net.Listen("unix", path)
+		require.NoError(t, err)
+		defer other.Close()
+
+		_, err = listen(Opts{Network: "unix", Address: path})
+		assert.ErrorContains(t, err, "in use by another process")
+	})
+
+	t.Run("refuses to remove regular file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "sops.sock")
+		require.NoError(t, os.WriteFile(path, nil, 0600))
+
+		_, err := listen(Opts{Network: "unix", Address: path})
+		assert.ErrorContains(t, err, "not a socket")
+		assert.FileExists(t, path)
+	})
+
+	t.Run("sets socket mode", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "sops.sock")
+		lis, err := listen(Opts{Network: "unix", Address: path, SocketMode: 0600})
+		require.NoError(t, err)
+		defer lis.Close()
+
+		fi, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	})
+
+	t.Run("restricts socket mode by default", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "sops.sock")
+		lis, err := listen(Opts{Network: "unix", Address: path})
+		require.NoError(t, err)
+		defer lis.Close()
+
+		fi, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	})
+
+	t.Run("removes socket on close", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "sops.sock")
+		lis, err := listen(Opts{Network: "unix", Address: path})
+		require.NoError(t, err)
+		require.NoError(t, lis.Close())
+		assert.NoFileExists(t, path)
+	})
+}
+
+func TestListenSystemd(t *testing.T) {
+	activated, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer activated.Close()
+	f, err := activated.(*net.TCPListener).File()
+	require.NoError(t, err)
+	defer f.Close()
+
+	oldStart := listenFdsStart
+	listenFdsStart = int(f.Fd())
+	defer func() { listenFdsStart = oldStart }()
+
+	t.Run("ignores sockets for other processes", func(t *testing.T) {
+		t.Setenv(listenPidEnv, "1")
+		t.Setenv(listenFdsEnv, "1")
+		lis, err := systemdListener()
+		require.NoError(t, err)
+		assert.Nil(t, lis)
+	})
+
+	t.Run("uses passed socket", func(t *testing.T) {
+		t.Setenv(listenPidEnv, strconv.Itoa(os.Getpid()))
+		t.Setenv(listenFdsEnv, "1")
+		lis, err := listen(Opts{Network: "unix", Address: filepath.Join(t.TempDir(), "unused.sock")})
+		require.NoError(t, err)
+		defer lis.Close()
+		assert.Equal(t, activated.Addr().String(), lis.Addr().String())
+
+		_, ok := os.LookupEnv(listenFdsEnv)
+		assert.False(t, ok, "%s should be unset after use", listenFdsEnv)
+	})
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package keyservice
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenUnix listens on the Unix socket at path. The socket is created with a
+// restrictive umask, so that it is only accessible to its owner until its mode
+// and owner are set.
+func listenUnix(path string) (net.Listener, error) {
+	umask := syscall.Umask(0o177)
+	defer syscall.Umask(umask)
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,10 @@
+package keyservice
+
+import (
+	"net"
+)
+
+// listenUnix listens on the Unix socket at path.
+func listenUnix(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
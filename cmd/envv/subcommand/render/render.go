@@ -0,0 +1,102 @@
+package render
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"text/template"
+)
+
+// Funcs returns the functions available to templates, in addition to the
+// builtin ones of text/template:
+//
+//   - b64enc encodes a value in base64
+//   - quote returns a value as a double-quoted string, escaped like a Go string
+//   - required fails rendering with the given message if a value is empty
+//   - default returns the given default if a value is empty
+func Funcs() template.FuncMap {
+	return template.FuncMap{
+		"b64enc": func(v interface{}) string {
+			return base64.StdEncoding.EncodeToString([]byte(toString(v)))
+		},
+		"quote": func(v interface{}) string {
+			return strconv.Quote(toString(v))
+		},
+		"required": func(msg string, v interface{}) (interface{}, error) {
+			if empty(v) {
+				return nil, fmt.Errorf("%s", msg)
+			}
+			return v, nil
+		},
+		"default": func(def interface{}, v interface{}) interface{} {
+			if empty(v) {
+				return def
+			}
+			return v
+		},
+	}
+}
+
+// Render executes the template with the decrypted tree, as returned by
+// sops.EmitAsMap, as data. Referring to a key missing from the data is an
+// error, optional values can be looked up with index instead
+func Render(name string, text []byte, data map[string]interface{}) ([]byte, error) {
+	tmpl, err := template.New(name).Funcs(Funcs()).Option("missingkey=error").Parse(string(text))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing template: %w", err)
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return nil, fmt.Errorf("error rendering template: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// WriteFile writes the rendered template to path. The file is only readable by
+// its owner, including when it already existed with other permissions
+func WriteFile(path string, output []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(output); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func toString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// empty returns whether the value is missing or the zero value of its type,
+// including empty strings, maps and lists
+func empty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	value := reflect.ValueOf(v)
+	switch value.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.String:
+		return value.Len() == 0
+	default:
+		return value.IsZero()
+	}
+}
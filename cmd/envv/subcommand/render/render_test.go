@@ -0,0 +1,72 @@
+package render
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRender(t *testing.T) {
+	data := map[string]interface{}{
+		"db": map[string]interface{}{
+			"user":     "admin",
+			"password": `pa"ss`,
+			"port":     5432,
+		},
+		"hosts": []interface{}{"a", "b"},
+		"empty": "",
+	}
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"plain", "{{ .db.user }}:{{ .db.port }}", "admin:5432"},
+		{"b64enc", "{{ .db.user | b64enc }}", "YWRtaW4="},
+		{"quote", "password = {{ .db.password | quote }}", `password = "pa\"ss"`},
+		{"required", `{{ required "user is required" .db.user }}`, "admin"},
+		{"default missing", `{{ index .db "host" | default "localhost" }}`, "localhost"},
+		{"default empty", `{{ .empty | default "value" }}`, "value"},
+		{"default set", `{{ .db.user | default "nobody" }}`, "admin"},
+		{"range", `{{ range .hosts }}{{ . }};{{ end }}`, "a;b;"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := Render("test", []byte(tt.template), data)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, string(out))
+		})
+	}
+}
+
+func TestRender_errors(t *testing.T) {
+	data := map[string]interface{}{"empty": ""}
+
+	_, err := Render("test", []byte(`{{ required "password is required" (index . "password") }}`), data)
+	assert.ErrorContains(t, err, "password is required")
+
+	_, err = Render("test", []byte(`{{ .password }}`), data)
+	assert.ErrorContains(t, err, `map has no entry for key "password"`)
+
+	_, err = Render("test", []byte(`{{ required "empty is required" .empty }}`), data)
+	assert.ErrorContains(t, err, "empty is required")
+
+	_, err = Render("test", []byte(`{{ .unclosed`), data)
+	assert.ErrorContains(t, err, "error parsing template")
+}
+
+func TestWriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out")
+	require.NoError(t, os.WriteFile(path, []byte("previous contents"), 0644))
+
+	require.NoError(t, WriteFile(path, []byte("secret")))
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", string(b))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
@@ -0,0 +1,240 @@
+package rotationstatus
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/AetherVoxSanctum/envv-cli/v3"
+	"github.com/AetherVoxSanctum/envv-cli/v3/cmd/envv/common"
+	"github.com/AetherVoxSanctum/envv-cli/v3/config"
+	"github.com/AetherVoxSanctum/envv-cli/v3/logging"
+
+	"github.com/sirupsen/logrus"
+)
+
+var log *logrus.Logger
+
+func init() {
+	log = logging.NewLogger("ROTATION-STATUS")
+}
+
+// Opts represent the input options for RotationStatus
+type Opts struct {
+	// Paths are the files and directories to check. Directories are walked
+	// recursively, skipping hidden directories and files which are not
+	// encrypted by SOPS
+	Paths []string
+	// ConfigPath is the path of the config file the rotation TTLs are read
+	// from. If empty, the default TTLs are used
+	ConfigPath string
+	InputType  string
+	// Now is the time the data key ages are computed at. If zero, the
+	// current time is used
+	Now time.Time
+}
+
+// KeyStatus represents the rotation status of a master key of a file
+type KeyStatus struct {
+	// Type is the identifier of the master key type
+	Type string
+	// Key is the string representation of the master key
+	Key string
+	// TTL is the maximum age of the data key for this master key, zero
+	// meaning it never needs rotation
+	TTL time.Duration
+	// Due is true if the data key is older than the TTL
+	Due bool
+}
+
+// FileStatus represents the rotation status of a file
+type FileStatus struct {
+	Path string
+	// DataKeyCreatedAt is the time the data key was generated. For files
+	// encrypted before it was recorded, it is estimated from the creation
+	// dates of the master keys and the last modification of the file
+	DataKeyCreatedAt time.Time
+	// DataKeyAge is the age of the data key
+	DataKeyAge time.Duration
+	Keys       []KeyStatus
+	// Due is true if the data key is past due for any of the master keys
+	Due bool
+}
+
+// RotationStatus returns the rotation status of all SOPS files found in the
+// paths of the options
+func RotationStatus(opts Opts) ([]FileStatus, error) {
+	paths := opts.Paths
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	var statuses []FileStatus
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			status, err := Check(opts, path)
+			if err != nil {
+				return nil, fmt.Errorf("cannot check rotation status of %s: %w", path, err)
+			}
+			statuses = append(statuses, status)
+			continue
+		}
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if p != path && strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			status, err := Check(opts, p)
+			if err != nil {
+				log.WithField("file", p).Debugf("Skipping file: %s", err)
+				return nil
+			}
+			statuses = append(statuses, status)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return statuses, nil
+}
+
+// Check returns the rotation status of a single SOPS file
+func Check(opts Opts, path string) (FileStatus, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return FileStatus{}, err
+	}
+	storesConfig := config.NewStoresConfig()
+	var ttl config.RotationTTL
+	if opts.ConfigPath != "" {
+		if storesConfig, err = config.LoadStoresConfig(opts.ConfigPath); err != nil {
+			return FileStatus{}, err
+		}
+		if ttl, err = config.LoadRotationTTLForFile(opts.ConfigPath, absPath); err != nil {
+			return FileStatus{}, err
+		}
+	}
+	store := common.DefaultStoreForPathOrFormat(storesConfig, absPath, opts.InputType)
+	tree, err := common.LoadEncryptedFile(store, absPath)
+	if err != nil {
+		return FileStatus{}, err
+	}
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	return fileStatus(path, tree, ttl, now), nil
+}
+
+// fileStatus returns the rotation status of the tree.
+func fileStatus(path string, tree *sops.Tree, ttl config.RotationTTL, now time.Time) FileStatus {
+	status := FileStatus{
+		Path:             path,
+		DataKeyCreatedAt: dataKeyCreatedAt(tree.Metadata),
+	}
+	status.DataKeyAge = now.Sub(status.DataKeyCreatedAt)
+	for _, group := range tree.Metadata.KeyGroups {
+		for _, key := range group {
+			keyTTL := ttl.For(key.TypeToIdentifier())
+			due := keyTTL > 0 && status.DataKeyAge > keyTTL
+			status.Keys = append(status.Keys, KeyStatus{
+				Type: key.TypeToIdentifier(),
+				Key:  key.ToString(),
+				TTL:  keyTTL,
+				Due:  due,
+			})
+			status.Due = status.Due || due
+		}
+	}
+	return status
+}
+
+// dataKeyCreatedAt returns the time the data key was generated. If it was
+// not recorded, the earliest of the creation dates of the master keys and the
+// last modification of the file is returned, as the data key can not have been
+// generated after any of them.
+func dataKeyCreatedAt(metadata sops.Metadata) time.Time {
+	if !metadata.DataKeyCreatedAt.IsZero() {
+		return metadata.DataKeyCreatedAt
+	}
+	createdAt := metadata.LastModified
+	for _, group := range metadata.KeyGroups {
+		for _, key := range group {
+			s, ok := key.ToMap()["created_at"].(string)
+			if !ok {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, s)
+			if err == nil && t.Before(createdAt) {
+				createdAt = t
+			}
+		}
+	}
+	return createdAt
+}
+
+// Due returns the statuses of the files which need rotation
+func Due(statuses []FileStatus) []FileStatus {
+	var due []FileStatus
+	for _, status := range statuses {
+		if status.Due {
+			due = append(due, status)
+		}
+	}
+	return due
+}
+
+// PrintStatus writes a human readable report of the statuses to w. Master
+// keys which are not due are only included if verbose is set
+func PrintStatus(w io.Writer, statuses []FileStatus, verbose bool) {
+	for _, status := range statuses {
+		if !status.Due && !verbose {
+			continue
+		}
+		state := "ok"
+		if status.Due {
+			state = "rotation needed"
+		}
+		fmt.Fprintf(w, "%s: %s (data key created %s, %s ago)\n", status.Path, state,
+			status.DataKeyCreatedAt.UTC().Format(time.RFC3339), formatDuration(status.DataKeyAge))
+		for _, key := range status.Keys {
+			if !key.Due && !verbose {
+				continue
+			}
+			ttl := "never expires"
+			if key.TTL > 0 {
+				ttl = "TTL " + formatDuration(key.TTL)
+			}
+			keyState := "ok"
+			if key.Due {
+				keyState = "past due"
+			}
+			fmt.Fprintf(w, "  %s %s: %s, %s\n", key.Type, key.Key, ttl, keyState)
+		}
+	}
+}
+
+// formatDuration formats d in days, or as a time.Duration if it is shorter
+// than a day.
+func formatDuration(d time.Duration) string {
+	if d < 24*time.Hour {
+		return d.Round(time.Second).String()
+	}
+	return fmt.Sprintf("%dd", int(d/(24*time.Hour)))
+}
@@ -0,0 +1,117 @@
+package rotationstatus
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AetherVoxSanctum/envv-cli/v3"
+	"github.com/AetherVoxSanctum/envv-cli/v3/age"
+	"github.com/AetherVoxSanctum/envv-cli/v3/config"
+	"github.com/AetherVoxSanctum/envv-cli/v3/kms"
+	"github.com/AetherVoxSanctum/envv-cli/v3/stores/yaml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testRecipient = "age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p"
+	testArn       = "arn:aws:kms:us-east-1:123456789012:key/sops"
+)
+
+var now = time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func testTree(dataKeyCreatedAt time.Time) *sops.Tree {
+	return &sops.Tree{
+		Branches: sops.TreeBranches{sops.TreeBranch{
+			sops.TreeItem{Key: "secret", Value: "ENC[AES256_GCM,data:dGVzdA==,iv:dGVzdA==,tag:dGVzdA==,type:str]"},
+		}},
+		Metadata: sops.Metadata{
+			LastModified:              now.Add(-24 * time.Hour),
+			DataKeyCreatedAt:          dataKeyCreatedAt,
+			MessageAuthenticationCode: "ENC[AES256_GCM,data:dGVzdA==,iv:dGVzdA==,tag:dGVzdA==,type:str]",
+			Version:                   "3.10.0",
+			KeyGroups: []sops.KeyGroup{{
+				&age.MasterKey{Recipient: testRecipient, EncryptedKey: "age"},
+				&kms.MasterKey{Arn: testArn, EncryptedKey: "kms", CreationDate: now.Add(-40 * 24 * time.Hour)},
+			}},
+		},
+	}
+}
+
+func TestFileStatus(t *testing.T) {
+	ttl := config.RotationTTL{"kms": 30 * 24 * time.Hour, "age": 0}
+
+	status := fileStatus("secrets.yaml", testTree(now.Add(-31*24*time.Hour)), ttl, now)
+	assert.True(t, status.Due)
+	assert.Equal(t, 31*24*time.Hour, status.DataKeyAge)
+	assert.Equal(t, []KeyStatus{
+		{Type: "age", Key: testRecipient, TTL: 0, Due: false},
+		{Type: "kms", Key: testArn, TTL: 30 * 24 * time.Hour, Due: true},
+	}, status.Keys)
+
+	status = fileStatus("secrets.yaml", testTree(now.Add(-29*24*time.Hour)), ttl, now)
+	assert.False(t, status.Due)
+
+	// Without configuration, DefaultRotationTTL applies to all key types but
+	// age and PGP.
+	status = fileStatus("secrets.yaml", testTree(now.Add(-31*24*time.Hour)), nil, now)
+	assert.False(t, status.Due)
+	assert.Equal(t, time.Duration(0), status.Keys[0].TTL)
+	assert.Equal(t, config.DefaultRotationTTL, status.Keys[1].TTL)
+
+	status = fileStatus("secrets.yaml", testTree(now.Add(-181*24*time.Hour)), nil, now)
+	assert.True(t, status.Due)
+	assert.False(t, status.Keys[0].Due)
+}
+
+func TestDataKeyCreatedAt(t *testing.T) {
+	createdAt := now.Add(-time.Hour)
+	assert.Equal(t, createdAt, dataKeyCreatedAt(testTree(createdAt).Metadata))
+
+	// The earliest master key creation date is used if it was not recorded.
+	assert.Equal(t, now.Add(-40*24*time.Hour), dataKeyCreatedAt(testTree(time.Time{}).Metadata))
+
+	// Falling back to the last modification if no master key has a date.
+	metadata := testTree(time.Time{}).Metadata
+	metadata.KeyGroups[0] = metadata.KeyGroups[0][:1]
+	assert.Equal(t, now.Add(-24*time.Hour), dataKeyCreatedAt(metadata))
+}
+
+func TestRotationStatus(t *testing.T) {
+	dir := t.TempDir()
+	store := &yaml.Store{}
+	writeTree := func(name string, tree *sops.Tree) {
+		out, err := store.EmitEncryptedFile(*tree)
+		require.NoError(t, err)
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o700))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), out, 0o600))
+	}
+	writeTree("old.yaml", testTree(now.Add(-60*24*time.Hour)))
+	writeTree("sub/new.yaml", testTree(now.Add(-time.Hour)))
+	writeTree(".hidden/old.yaml", testTree(now.Add(-60*24*time.Hour)))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "plain.yaml"), []byte("foo: bar\n"), 0o600))
+
+	confPath := filepath.Join(dir, ".sops.yaml")
+	require.NoError(t, os.WriteFile(confPath, []byte("creation_rules:\n  - rotation_ttl:\n      kms: 30d\n"), 0o600))
+
+	statuses, err := RotationStatus(Opts{Paths: []string{dir}, ConfigPath: confPath, Now: now})
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	assert.Equal(t, filepath.Join(dir, "old.yaml"), statuses[0].Path)
+	assert.True(t, statuses[0].Due)
+	assert.Equal(t, filepath.Join(dir, "sub", "new.yaml"), statuses[1].Path)
+	assert.False(t, statuses[1].Due)
+
+	due := Due(statuses)
+	require.Len(t, due, 1)
+	var buf bytes.Buffer
+	PrintStatus(&buf, statuses, false)
+	assert.Equal(t, filepath.Join(dir, "old.yaml")+": rotation needed (data key created 2025-04-02T00:00:00Z, 60d ago)\n"+
+		"  kms "+testArn+": TTL 30d, past due\n", buf.String())
+
+	_, err = RotationStatus(Opts{Paths: []string{filepath.Join(dir, "plain.yaml")}, Now: now})
+	assert.Error(t, err)
+}
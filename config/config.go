@@ -18,6 +18,7 @@ import (
 	"github.com/AetherVoxSanctum/envv-cli/v3/hcvault"
 	"github.com/AetherVoxSanctum/envv-cli/v3/kms"
 	"github.com/AetherVoxSanctum/envv-cli/v3/pgp"
+	"github.com/AetherVoxSanctum/envv-cli/v3/pkcs11"
 	"github.com/AetherVoxSanctum/envv-cli/v3/publish"
 	"gopkg.in/yaml.v3"
 )
@@ -135,6 +136,7 @@ type keyGroup struct {
 	AzureKV []azureKVKey `yaml:"azure_keyvault"`
 	Vault   []string     `yaml:"hc_vault"`
 	Age     []string     `yaml:"age"`
+	PKCS11  []string     `yaml:"pkcs11"`
 	PGP     []string
 }
 
@@ -147,6 +149,12 @@ type kmsKey struct {
 	Role       string             `yaml:"role,omitempty"`
 	Context    map[string]*string `yaml:"context"`
 	AwsProfile string             `yaml:"aws_profile"`
+	// ContextTemplates makes the values of Context templates, rendered like
+	// those of the kms_encryption_context of a creation rule.
+	ContextTemplates bool `yaml:"context_templates"`
+	// ReplicaRegions are the regions a multi-Region key is replicated to,
+	// which are tried if decryption in the region of the ARN fails.
+	ReplicaRegions []string `yaml:"replica_regions"`
 }
 
 type azureKVKey struct {
@@ -169,15 +177,31 @@ type destinationRule struct {
 	OmitExtensions   bool         `yaml:"omit_extensions"`
 }
 
+// EnvMapping configures how exec-env names the environment variables of
+// nested values of the files matching a creation rule.
+type EnvMapping struct {
+	// Separator joins the keys of the path of a value
+	Separator string `yaml:"separator"`
+	// Case is the letter case of names: upper, lower or preserve
+	Case string `yaml:"case"`
+	// Lists is how lists are handled: index or join
+	Lists string `yaml:"lists"`
+	// Renames maps dotted paths of values or branches to variable names
+	Renames map[string]string `yaml:"renames"`
+}
+
 type creationRule struct {
 	PathRegex               string      `yaml:"path_regex"`
 	KMS                     interface{} `yaml:"kms"` // string or []string
 	AwsProfile              string      `yaml:"aws_profile"`
-	Age                     interface{} `yaml:"age"`                  // string or []string
-	PGP                     interface{} `yaml:"pgp"`                  // string or []string
-	GCPKMS                  interface{} `yaml:"gcp_kms"`              // string or []string
-	AzureKeyVault           interface{} `yaml:"azure_keyvault"`       // string or []string
-	VaultURI                interface{} `yaml:"hc_vault_transit_uri"` // string or []string
+	KMSReplicaRegions       []string    `yaml:"kms_replica_regions"`
+	KMSEncryptionContext    interface{} `yaml:"kms_encryption_context"` // map or string, values may be templates
+	Age                     interface{} `yaml:"age"`                    // string or []string
+	PGP                     interface{} `yaml:"pgp"`                    // string or []string
+	GCPKMS                  interface{} `yaml:"gcp_kms"`                // string or []string
+	AzureKeyVault           interface{} `yaml:"azure_keyvault"`         // string or []string
+	VaultURI                interface{} `yaml:"hc_vault_transit_uri"`   // string or []string
+	PKCS11                  interface{} `yaml:"pkcs11"`                 // string or []string
 	KeyGroups               []keyGroup  `yaml:"key_groups"`
 	ShamirThreshold         int         `yaml:"shamir_threshold"`
 	UnencryptedSuffix       string      `yaml:"unencrypted_suffix"`
@@ -187,6 +211,10 @@ type creationRule struct {
 	UnencryptedCommentRegex string      `yaml:"unencrypted_comment_regex"`
 	EncryptedCommentRegex   string      `yaml:"encrypted_comment_regex"`
 	MACOnlyEncrypted        bool        `yaml:"mac_only_encrypted"`
+	// RotationTTL maps key type identifiers or "default" to TTLs, see
+	// ParseTTL.
+	RotationTTL map[string]string `yaml:"rotation_ttl"`
+	EnvMapping  EnvMapping        `yaml:"env_mapping"`
 }
 
 // Helper methods to safely extract keys as []string
@@ -214,6 +242,10 @@ func (c *creationRule) GetVaultURIs() ([]string, error) {
 	return parseKeyField(c.VaultURI, "hc_vault_transit_uri")
 }
 
+func (c *creationRule) GetPKCS11URIs() ([]string, error) {
+	return parseKeyField(c.PKCS11, "pkcs11")
+}
+
 // Utility function to handle both string and []string
 func parseKeyField(field interface{}, fieldName string) ([]string, error) {
 	if field == nil {
@@ -281,6 +313,7 @@ type Config struct {
 	MACOnlyEncrypted        bool
 	Destination             publish.Destination
 	OmitExtensions          bool
+	RotationTTL             RotationTTL
 }
 
 func deduplicateKeygroup(group sops.KeyGroup) sops.KeyGroup {
@@ -301,10 +334,10 @@ func deduplicateKeygroup(group sops.KeyGroup) sops.KeyGroup {
 	return deduplicatedKeygroup
 }
 
-func extractMasterKeys(group keyGroup) (sops.KeyGroup, error) {
+func extractMasterKeys(group keyGroup, contextData kms.ContextTemplateData) (sops.KeyGroup, error) {
 	var keyGroup sops.KeyGroup
 	for _, k := range group.Merge {
-		subKeyGroup, err := extractMasterKeys(k)
+		subKeyGroup, err := extractMasterKeys(k, contextData)
 		if err != nil {
 			return nil, err
 		}
@@ -324,7 +357,18 @@ func extractMasterKeys(group keyGroup) (sops.KeyGroup, error) {
 		keyGroup = append(keyGroup, pgp.NewMasterKeyFromFingerprint(k))
 	}
 	for _, k := range group.KMS {
-		keyGroup = append(keyGroup, kms.NewMasterKeyWithProfile(k.Arn, k.Role, k.Context, k.AwsProfile))
+		context := k.Context
+		if k.ContextTemplates {
+			var err error
+			if context, err = kms.RenderKMSContext(k.Context, contextData); err != nil {
+				return nil, err
+			}
+		}
+		key := kms.NewMasterKeyWithProfile(k.Arn, k.Role, context, k.AwsProfile)
+		if err := setKMSReplicaRegions(key, k.ReplicaRegions); err != nil {
+			return nil, err
+		}
+		keyGroup = append(keyGroup, key)
 	}
 	for _, k := range group.GCPKMS {
 		keyGroup = append(keyGroup, gcpkms.NewMasterKeyFromResourceID(k.ResourceID))
@@ -339,6 +383,13 @@ func extractMasterKeys(group keyGroup) (sops.KeyGroup, error) {
 			return nil, err
 		}
 	}
+	for _, k := range group.PKCS11 {
+		masterKey, err := pkcs11.NewMasterKeyFromURI(k)
+		if err != nil {
+			return nil, err
+		}
+		keyGroup = append(keyGroup, masterKey)
+	}
 	return deduplicateKeygroup(keyGroup), nil
 }
 
@@ -350,11 +401,24 @@ func getKeysWithValidation(getKeysFunc func() ([]string, error), keyType string)
 	return keys, nil
 }
 
-func getKeyGroupsFromCreationRule(cRule *creationRule, kmsEncryptionContext map[string]*string) ([]sops.KeyGroup, error) {
+// setKMSReplicaRegions sets the replica regions of a key group KMS key, which
+// must be a multi-Region key if any are configured.
+func setKMSReplicaRegions(key *kms.MasterKey, regions []string) error {
+	if len(regions) == 0 {
+		return nil
+	}
+	if !kms.IsMultiRegionKey(key.Arn) {
+		return fmt.Errorf("replica_regions set for KMS key %s, which is not a multi-Region key", key.Arn)
+	}
+	key.ReplicaRegions = regions
+	return nil
+}
+
+func getKeyGroupsFromCreationRule(cRule *creationRule, kmsEncryptionContext map[string]*string, contextData kms.ContextTemplateData) ([]sops.KeyGroup, error) {
 	var groups []sops.KeyGroup
 	if len(cRule.KeyGroups) > 0 {
 		for _, group := range cRule.KeyGroups {
-			keyGroup, err := extractMasterKeys(group)
+			keyGroup, err := extractMasterKeys(group, contextData)
 			if err != nil {
 				return nil, err
 			}
@@ -388,7 +452,14 @@ func getKeyGroupsFromCreationRule(cRule *creationRule, kmsEncryptionContext map[
 		if err != nil {
 			return nil, err
 		}
+		kmsEncryptionContext, err := ruleKMSEncryptionContext(cRule, kmsEncryptionContext, contextData)
+		if err != nil {
+			return nil, err
+		}
 		for _, k := range kms.MasterKeysFromArnString(strings.Join(kmsKeys, ","), kmsEncryptionContext, cRule.AwsProfile) {
+			if kms.IsMultiRegionKey(k.Arn) {
+				k.ReplicaRegions = cRule.KMSReplicaRegions
+			}
 			keyGroup = append(keyGroup, k)
 		}
 		gcpkmsKeys, err := getKeysWithValidation(cRule.GetGCPKMSKeys, "gcpkms")
@@ -420,6 +491,17 @@ func getKeyGroupsFromCreationRule(cRule *creationRule, kmsEncryptionContext map[
 		for _, k := range vaultKeys {
 			keyGroup = append(keyGroup, k)
 		}
+		pkcs11URIs, err := getKeysWithValidation(cRule.GetPKCS11URIs, "pkcs11")
+		if err != nil {
+			return nil, err
+		}
+		pkcs11Keys, err := pkcs11.MasterKeysFromURIs(strings.Join(pkcs11URIs, ","))
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range pkcs11Keys {
+			keyGroup = append(keyGroup, k)
+		}
 		groups = append(groups, keyGroup)
 	}
 	return groups, nil
@@ -439,7 +521,55 @@ func loadConfigFile(confPath string) (*configFile, error) {
 	return conf, nil
 }
 
-func configFromRule(rule *creationRule, kmsEncryptionContext map[string]*string) (*Config, error) {
+// ruleKMSEncryptionContext returns the encryption context of the creation rule
+// rendered with contextData, overridden by the entries of kmsEncryptionContext.
+func ruleKMSEncryptionContext(cRule *creationRule, kmsEncryptionContext map[string]*string, contextData kms.ContextTemplateData) (map[string]*string, error) {
+	if cRule.KMSEncryptionContext == nil {
+		return kmsEncryptionContext, nil
+	}
+	context, err := kms.RenderKMSContext(kms.ParseKMSContext(cRule.KMSEncryptionContext), contextData)
+	if err != nil {
+		return nil, err
+	}
+	if context == nil {
+		return kmsEncryptionContext, nil
+	}
+	for k, v := range kmsEncryptionContext {
+		context[k] = v
+	}
+	return context, nil
+}
+
+// contextTemplateData returns the data encryption context templates are
+// executed with for the file at filePath, whose rule was selected by pathRegex
+// matching matchedPath.
+func contextTemplateData(confPath, filePath, pathRegex, matchedPath string) (kms.ContextTemplateData, error) {
+	path, err := filepath.Abs(filePath)
+	if err != nil {
+		return kms.ContextTemplateData{}, err
+	}
+	relPath, err := relativeFilePath(confPath, path)
+	if err != nil {
+		return kms.ContextTemplateData{}, err
+	}
+	data := kms.ContextTemplateData{Path: path, RelPath: relPath, Match: map[string]string{}}
+	if pathRegex == "" {
+		return data, nil
+	}
+	reg, err := regexp.Compile(pathRegex)
+	if err != nil {
+		return data, fmt.Errorf("can not compile regexp: %w", err)
+	}
+	matches := reg.FindStringSubmatch(matchedPath)
+	for i, name := range reg.SubexpNames() {
+		if i > 0 && name != "" && i < len(matches) && matches[i] != "" {
+			data.Match[name] = matches[i]
+		}
+	}
+	return data, nil
+}
+
+func configFromRule(rule *creationRule, kmsEncryptionContext map[string]*string, contextData kms.ContextTemplateData) (*Config, error) {
 	cryptRuleCount := 0
 	if rule.UnencryptedSuffix != "" {
 		cryptRuleCount++
@@ -464,11 +594,16 @@ func configFromRule(rule *creationRule, kmsEncryptionContext map[string]*string)
 		return nil, fmt.Errorf("error loading config: cannot use more than one of encrypted_suffix, unencrypted_suffix, encrypted_regex, unencrypted_regex, encrypted_comment_regex, or unencrypted_comment_regex for the same rule")
 	}
 
-	groups, err := getKeyGroupsFromCreationRule(rule, kmsEncryptionContext)
+	groups, err := getKeyGroupsFromCreationRule(rule, kmsEncryptionContext, contextData)
 	if err != nil {
 		return nil, err
 	}
 
+	rotationTTL, err := parseRotationTTL(rule.RotationTTL)
+	if err != nil {
+		return nil, fmt.Errorf("error loading config: %w", err)
+	}
+
 	return &Config{
 		KeyGroups:               groups,
 		ShamirThreshold:         rule.ShamirThreshold,
@@ -479,10 +614,11 @@ func configFromRule(rule *creationRule, kmsEncryptionContext map[string]*string)
 		UnencryptedCommentRegex: rule.UnencryptedCommentRegex,
 		EncryptedCommentRegex:   rule.EncryptedCommentRegex,
 		MACOnlyEncrypted:        rule.MACOnlyEncrypted,
+		RotationTTL:             rotationTTL,
 	}, nil
 }
 
-func parseDestinationRuleForFile(conf *configFile, filePath string, kmsEncryptionContext map[string]*string) (*Config, error) {
+func parseDestinationRuleForFile(conf *configFile, confPath, filePath string, kmsEncryptionContext map[string]*string) (*Config, error) {
 	var rule *creationRule
 	var dRule *destinationRule
 
@@ -521,7 +657,11 @@ func parseDestinationRuleForFile(conf *configFile, filePath string, kmsEncryptio
 		dest = publish.NewVaultDestination(dRule.VaultAddress, dRule.VaultPath, dRule.VaultKVMountName, dRule.VaultKVVersion)
 	}
 
-	config, err := configFromRule(rule, kmsEncryptionContext)
+	contextData, err := contextTemplateData(confPath, filePath, dRule.PathRegex, filePath)
+	if err != nil {
+		return nil, err
+	}
+	config, err := configFromRule(rule, kmsEncryptionContext, contextData)
 	if err != nil {
 		return nil, err
 	}
@@ -537,13 +677,38 @@ func parseCreationRuleForFile(conf *configFile, confPath, filePath string, kmsEn
 		return nil, nil
 	}
 
-	configDir, err := filepath.Abs(filepath.Dir(confPath))
+	rule, err := matchCreationRule(conf, confPath, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if rule == nil {
+		return nil, fmt.Errorf("error loading config: no matching creation rules found")
+	}
+
+	relPath, err := relativeFilePath(confPath, filePath)
+	if err != nil {
+		return nil, err
+	}
+	contextData, err := contextTemplateData(confPath, filePath, rule.PathRegex, relPath)
+	if err != nil {
+		return nil, err
+	}
+	config, err := configFromRule(rule, kmsEncryptionContext, contextData)
 	if err != nil {
 		return nil, err
 	}
 
+	return config, nil
+}
+
+// matchCreationRule returns the first creation rule of the config file
+// matching filePath, or nil if none matches.
+func matchCreationRule(conf *configFile, confPath, filePath string) (*creationRule, error) {
 	// compare file path relative to path of config file
-	filePath = strings.TrimPrefix(filePath, configDir+string(filepath.Separator))
+	filePath, err := relativeFilePath(confPath, filePath)
+	if err != nil {
+		return nil, err
+	}
 
 	var rule *creationRule
 
@@ -562,21 +727,22 @@ func parseCreationRuleForFile(conf *configFile, confPath, filePath string, kmsEn
 		}
 	}
 
-	if rule == nil {
-		return nil, fmt.Errorf("error loading config: no matching creation rules found")
-	}
+	return rule, nil
+}
 
-	config, err := configFromRule(rule, kmsEncryptionContext)
+// relativeFilePath returns filePath relative to the directory of the config
+// file at confPath, or filePath itself if it is outside of that directory.
+func relativeFilePath(confPath, filePath string) (string, error) {
+	configDir, err := filepath.Abs(filepath.Dir(confPath))
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-
-	return config, nil
+	return strings.TrimPrefix(filePath, configDir+string(filepath.Separator)), nil
 }
 
 // LoadCreationRuleForFile load the configuration for a given SOPS file from the config file at confPath. A kmsEncryptionContext
-// should be provided for configurations that do not contain key groups, as there's no way to specify context inside
-// a SOPS config file outside of key groups.
+// may be provided for configurations that do not contain key groups, it overrides the kms_encryption_context of the
+// matching creation rule.
 func LoadCreationRuleForFile(confPath string, filePath string, kmsEncryptionContext map[string]*string) (*Config, error) {
 	conf, err := loadConfigFile(confPath)
 	if err != nil {
@@ -586,6 +752,46 @@ func LoadCreationRuleForFile(confPath string, filePath string, kmsEncryptionCont
 	return parseCreationRuleForFile(conf, confPath, filePath, kmsEncryptionContext)
 }
 
+// LoadRotationTTLForFile loads the rotation TTLs of the creation rule matching
+// the given SOPS file from the config file at confPath. Unlike
+// LoadCreationRuleForFile, it does not parse the master keys of the rule.
+// It returns nil if the config file has no creation rules or none matches.
+func LoadRotationTTLForFile(confPath string, filePath string) (RotationTTL, error) {
+	conf, err := loadConfigFile(confPath)
+	if err != nil {
+		return nil, err
+	}
+	if conf.CreationRules == nil {
+		return nil, nil
+	}
+	rule, err := matchCreationRule(conf, confPath, filePath)
+	if err != nil || rule == nil {
+		return nil, err
+	}
+	rotationTTL, err := parseRotationTTL(rule.RotationTTL)
+	if err != nil {
+		return nil, fmt.Errorf("error loading config: %w", err)
+	}
+	return rotationTTL, nil
+}
+
+// LoadEnvMappingForFile loads the environment variable name mapping of the
+// creation rule matching the given SOPS file from the config file at confPath.
+// Like LoadRotationTTLForFile, it does not parse the master keys of the rule.
+// It returns an empty mapping if the config file has no creation rules or none
+// matches.
+func LoadEnvMappingForFile(confPath string, filePath string) (EnvMapping, error) {
+	conf, err := loadConfigFile(confPath)
+	if err != nil {
+		return EnvMapping{}, err
+	}
+	rule, err := matchCreationRule(conf, confPath, filePath)
+	if err != nil || rule == nil {
+		return EnvMapping{}, err
+	}
+	return rule.EnvMapping, nil
+}
+
 // LoadDestinationRuleForFile works the same as LoadCreationRuleForFile, but gets the "creation_rule" from the matching destination_rule's
 // "recreation_rule".
 func LoadDestinationRuleForFile(confPath string, filePath string, kmsEncryptionContext map[string]*string) (*Config, error) {
@@ -593,7 +799,7 @@ func LoadDestinationRuleForFile(confPath string, filePath string, kmsEncryptionC
 	if err != nil {
 		return nil, err
 	}
-	return parseDestinationRuleForFile(conf, filePath, kmsEncryptionContext)
+	return parseDestinationRuleForFile(conf, confPath, filePath, kmsEncryptionContext)
 }
 
 func LoadStoresConfig(confPath string) (*StoresConfig, error) {
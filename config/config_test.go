@@ -4,10 +4,14 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/AetherVoxSanctum/envv-cli/v3/keys"
+	"github.com/AetherVoxSanctum/envv-cli/v3/kms"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type mockFS struct {
@@ -701,7 +705,7 @@ func TestLoadConfigFileWithAmbiguousPath(t *testing.T) {
 }
 
 func TestLoadConfigFileWithDestinationRule(t *testing.T) {
-	conf, err := parseDestinationRuleForFile(parseConfigFile(sampleConfigWithDestinationRule, t), "barfoo", nil)
+	conf, err := parseDestinationRuleForFile(parseConfigFile(sampleConfigWithDestinationRule, t), "/conf/path", "barfoo", nil)
 	assert.Nil(t, err)
 	assert.Equal(t, "newpgp", conf.KeyGroups[0][0].ToString())
 	assert.NotNil(t, conf.Destination)
@@ -709,11 +713,11 @@ func TestLoadConfigFileWithDestinationRule(t *testing.T) {
 }
 
 func TestLoadConfigFileWithVaultDestinationRules(t *testing.T) {
-	conf, err := parseDestinationRuleForFile(parseConfigFile(sampleConfigWithVaultDestinationRules, t), "vault-v2/barfoo", nil)
+	conf, err := parseDestinationRuleForFile(parseConfigFile(sampleConfigWithVaultDestinationRules, t), "/conf/path", "vault-v2/barfoo", nil)
 	assert.Nil(t, err)
 	assert.NotNil(t, conf.Destination)
 	assert.Contains(t, conf.Destination.Path("barfoo"), "/v1/secret/data/foobar/barfoo")
-	conf, err = parseDestinationRuleForFile(parseConfigFile(sampleConfigWithVaultDestinationRules, t), "vault-v1/barfoo", nil)
+	conf, err = parseDestinationRuleForFile(parseConfigFile(sampleConfigWithVaultDestinationRules, t), "/conf/path", "vault-v1/barfoo", nil)
 	assert.Nil(t, err)
 	assert.NotNil(t, conf.Destination)
 	assert.Contains(t, conf.Destination.Path("barfoo"), "/v1/kv/barfoo/barfoo")
@@ -755,3 +759,223 @@ creation_rules:
 	assert.Equal(t, 1, keyTypeCounts["gcp_kms"])
 	assert.Equal(t, 1, keyTypeCounts["hc_vault"])
 }
+
+func TestCreationRulePKCS11Keys(t *testing.T) {
+	var sampleConfigWithPKCS11 = []byte(`
+creation_rules:
+  - path_regex: rule
+    pkcs11: "pkcs11:token=sops;object=aes, pkcs11:token=sops;object=rsa"
+  - path_regex: groups
+    key_groups:
+    - pkcs11:
+      - "pkcs11:token=hsm1;object=master"
+      age:
+      - "age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p"
+    - pkcs11:
+      - "pkcs11:token=hsm2;object=master"
+  - path_regex: invalid
+    pkcs11:
+      - "pkcs11:token=sops"
+`)
+	conf, err := parseCreationRuleForFile(parseConfigFile(sampleConfigWithPKCS11, t), "/conf/path", "rule", nil)
+	require.NoError(t, err)
+	require.Len(t, conf.KeyGroups, 1)
+	require.Len(t, conf.KeyGroups[0], 2)
+	assert.Equal(t, "pkcs11", conf.KeyGroups[0][0].TypeToIdentifier())
+	assert.Equal(t, "pkcs11:token=sops;object=aes", conf.KeyGroups[0][0].ToString())
+	assert.Equal(t, "pkcs11:token=sops;object=rsa", conf.KeyGroups[0][1].ToString())
+
+	conf, err = parseCreationRuleForFile(parseConfigFile(sampleConfigWithPKCS11, t), "/conf/path", "groups", nil)
+	require.NoError(t, err)
+	require.Len(t, conf.KeyGroups, 2)
+	assert.Len(t, conf.KeyGroups[0], 2)
+	assert.Equal(t, "pkcs11:token=hsm2;object=master", conf.KeyGroups[1][0].ToString())
+
+	_, err = parseCreationRuleForFile(parseConfigFile(sampleConfigWithPKCS11, t), "/conf/path", "invalid", nil)
+	assert.ErrorContains(t, err, "must identify a key")
+}
+
+func TestCreationRuleRotationTTL(t *testing.T) {
+	var sampleConfigWithRotationTTL = []byte(`
+creation_rules:
+  - path_regex: prod
+    age: "age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p"
+    rotation_ttl:
+      default: 90d
+      kms: 720h
+      age: never
+  - path_regex: invalid
+    rotation_ttl:
+      ssh: 30d
+  - path_regex: dev
+    age: "age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p"
+`)
+	conf, err := parseCreationRuleForFile(parseConfigFile(sampleConfigWithRotationTTL, t), "/conf/path", "prod", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 30*24*time.Hour, conf.RotationTTL.For("kms"))
+	assert.Equal(t, time.Duration(0), conf.RotationTTL.For("age"))
+	assert.Equal(t, 90*24*time.Hour, conf.RotationTTL.For("pgp"))
+
+	conf, err = parseCreationRuleForFile(parseConfigFile(sampleConfigWithRotationTTL, t), "/conf/path", "dev", nil)
+	require.NoError(t, err)
+	assert.Nil(t, conf.RotationTTL)
+	assert.Equal(t, DefaultRotationTTL, conf.RotationTTL.For("kms"))
+	assert.Equal(t, time.Duration(0), conf.RotationTTL.For("age"))
+	assert.Equal(t, time.Duration(0), conf.RotationTTL.For("pgp"))
+
+	_, err = parseCreationRuleForFile(parseConfigFile(sampleConfigWithRotationTTL, t), "/conf/path", "invalid", nil)
+	assert.ErrorContains(t, err, `unknown key type "ssh" in rotation_ttl`)
+
+	confPath := filepath.Join(t.TempDir(), ".sops.yaml")
+	require.NoError(t, os.WriteFile(confPath, sampleConfigWithRotationTTL, 0o600))
+	ttl, err := LoadRotationTTLForFile(confPath, filepath.Join(filepath.Dir(confPath), "prod.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, 30*24*time.Hour, ttl.For("kms"))
+	ttl, err = LoadRotationTTLForFile(confPath, filepath.Join(filepath.Dir(confPath), "other.yaml"))
+	require.NoError(t, err)
+	assert.Nil(t, ttl)
+}
+
+func TestParseTTL(t *testing.T) {
+	tests := []struct {
+		in        string
+		want      time.Duration
+		expectErr bool
+	}{
+		{in: "90d", want: 90 * 24 * time.Hour},
+		{in: "36h", want: 36 * time.Hour},
+		{in: "never", want: 0},
+		{in: "0", want: 0},
+		{in: "-1d", expectErr: true},
+		{in: "-1h", expectErr: true},
+		{in: "a month", expectErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseTTL(tt.in)
+			if tt.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestKMSReplicaRegions(t *testing.T) {
+	var sampleConfigWithReplicaRegions = []byte(`
+creation_rules:
+  - path_regex: flat
+    kms: "arn:aws:kms:us-east-1:111122223333:key/mrk-1234,arn:aws:kms:us-east-1:111122223333:key/1234"
+    kms_replica_regions: [us-west-2, eu-west-1]
+  - path_regex: groups
+    key_groups:
+      - kms:
+          - arn: "arn:aws:kms:us-east-1:111122223333:key/mrk-1234"
+            replica_regions: [eu-west-1]
+  - path_regex: invalid
+    key_groups:
+      - kms:
+          - arn: "arn:aws:kms:us-east-1:111122223333:key/1234"
+            replica_regions: [eu-west-1]
+`)
+	conf, err := parseCreationRuleForFile(parseConfigFile(sampleConfigWithReplicaRegions, t), "/conf/path", "flat", nil)
+	require.NoError(t, err)
+	require.Len(t, conf.KeyGroups[0], 2)
+	assert.Equal(t, []string{"us-west-2", "eu-west-1"}, conf.KeyGroups[0][0].(*kms.MasterKey).ReplicaRegions)
+	assert.Nil(t, conf.KeyGroups[0][1].(*kms.MasterKey).ReplicaRegions)
+
+	conf, err = parseCreationRuleForFile(parseConfigFile(sampleConfigWithReplicaRegions, t), "/conf/path", "groups", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"eu-west-1"}, conf.KeyGroups[0][0].(*kms.MasterKey).ReplicaRegions)
+
+	_, err = parseCreationRuleForFile(parseConfigFile(sampleConfigWithReplicaRegions, t), "/conf/path", "invalid", nil)
+	assert.ErrorContains(t, err, "not a multi-Region key")
+}
+
+func TestKMSEncryptionContextTemplates(t *testing.T) {
+	var sampleConfigWithContextTemplates = []byte(`
+creation_rules:
+  - path_regex: ^secrets/(?P<env>[a-z]+)/
+    kms: "arn:aws:kms:us-east-1:111122223333:key/1234"
+    kms_encryption_context:
+      file: "{{.RelPath}}"
+      path: "{{.Path}}"
+      env: "{{.Match.env}}"
+  - path_regex: ^groups/(?P<team>[a-z]+)\.yaml$
+    key_groups:
+      - kms:
+          - arn: "arn:aws:kms:us-east-1:111122223333:key/1234"
+            context_templates: true
+            context:
+              team: "{{.Match.team}}"
+  - path_regex: ^literal/
+    key_groups:
+      - kms:
+          - arn: "arn:aws:kms:us-east-1:111122223333:key/1234"
+            context:
+              team: "{{.Match.team}}"
+  - path_regex: ^missing/
+    kms: "arn:aws:kms:us-east-1:111122223333:key/1234"
+    kms_encryption_context: "env:{{.Match.env}}"
+`)
+	contextOf := func(conf *Config) map[string]string {
+		out := make(map[string]string)
+		for k, v := range conf.KeyGroups[0][0].(*kms.MasterKey).EncryptionContext {
+			out[k] = *v
+		}
+		return out
+	}
+
+	conf, err := parseCreationRuleForFile(parseConfigFile(sampleConfigWithContextTemplates, t), "/conf/.sops.yaml", "/conf/secrets/prod/db.yaml", nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"file": "secrets/prod/db.yaml", "path": "/conf/secrets/prod/db.yaml", "env": "prod"}, contextOf(conf))
+
+	// An encryption context given on the command line overrides the rule.
+	override := "staging"
+	conf, err = parseCreationRuleForFile(parseConfigFile(sampleConfigWithContextTemplates, t), "/conf/.sops.yaml", "/conf/secrets/prod/db.yaml", map[string]*string{"env": &override})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"file": "secrets/prod/db.yaml", "path": "/conf/secrets/prod/db.yaml", "env": "staging"}, contextOf(conf))
+
+	conf, err = parseCreationRuleForFile(parseConfigFile(sampleConfigWithContextTemplates, t), "/conf/.sops.yaml", "/conf/groups/payments.yaml", nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"team": "payments"}, contextOf(conf))
+
+	// Key group contexts are only rendered with context_templates.
+	conf, err = parseCreationRuleForFile(parseConfigFile(sampleConfigWithContextTemplates, t), "/conf/.sops.yaml", "/conf/literal/db.yaml", nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"team": "{{.Match.team}}"}, contextOf(conf))
+
+	_, err = parseCreationRuleForFile(parseConfigFile(sampleConfigWithContextTemplates, t), "/conf/.sops.yaml", "/conf/missing/db.yaml", nil)
+	assert.ErrorContains(t, err, `could not render encryption context template for "env"`)
+}
+
+func TestLoadEnvMappingForFile(t *testing.T) {
+	var sampleConfigWithEnvMapping = []byte(`
+creation_rules:
+  - path_regex: app
+    age: "age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p"
+    env_mapping:
+      separator: __
+      case: preserve
+      lists: join
+      renames:
+        database.password: PGPASSWORD
+`)
+	confPath := filepath.Join(t.TempDir(), ".sops.yaml")
+	require.NoError(t, os.WriteFile(confPath, sampleConfigWithEnvMapping, 0o600))
+
+	mapping, err := LoadEnvMappingForFile(confPath, filepath.Join(filepath.Dir(confPath), "app.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, EnvMapping{
+		Separator: "__",
+		Case:      "preserve",
+		Lists:     "join",
+		Renames:   map[string]string{"database.password": "PGPASSWORD"},
+	}, mapping)
+
+	mapping, err = LoadEnvMappingForFile(confPath, filepath.Join(filepath.Dir(confPath), "other.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, EnvMapping{}, mapping)
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AetherVoxSanctum/envv-cli/v3/age"
+	"github.com/AetherVoxSanctum/envv-cli/v3/azkv"
+	"github.com/AetherVoxSanctum/envv-cli/v3/gcpkms"
+	"github.com/AetherVoxSanctum/envv-cli/v3/hcvault"
+	"github.com/AetherVoxSanctum/envv-cli/v3/kms"
+	"github.com/AetherVoxSanctum/envv-cli/v3/pgp"
+	"github.com/AetherVoxSanctum/envv-cli/v3/pkcs11"
+)
+
+const (
+	// DefaultRotationTTL is the maximum age of a data key for master key
+	// types without a configured TTL. Age and PGP keys have no default TTL,
+	// as they are not rotated by a key management service.
+	DefaultRotationTTL = time.Hour * 24 * 30 * 6
+	// defaultRotationTTLKey is the key of the rotation_ttl map of a creation
+	// rule which applies to all master key types without their own TTL.
+	defaultRotationTTLKey = "default"
+)
+
+// RotationTTL maps master key type identifiers to the maximum age of the data
+// key of files encrypted with a master key of that type. A TTL of zero means
+// the data key never needs rotation.
+type RotationTTL map[string]time.Duration
+
+// For returns the TTL for the given master key type identifier, falling back
+// to the configured default and DefaultRotationTTL. Without configuration,
+// age and PGP keys never need rotation.
+func (r RotationTTL) For(keyType string) time.Duration {
+	if ttl, ok := r[keyType]; ok {
+		return ttl
+	}
+	if ttl, ok := r[defaultRotationTTLKey]; ok {
+		return ttl
+	}
+	switch keyType {
+	case age.KeyTypeIdentifier, pgp.KeyTypeIdentifier:
+		return 0
+	}
+	return DefaultRotationTTL
+}
+
+// parseRotationTTL parses the rotation_ttl map of a creation rule.
+func parseRotationTTL(ttls map[string]string) (RotationTTL, error) {
+	if len(ttls) == 0 {
+		return nil, nil
+	}
+	r := make(RotationTTL, len(ttls))
+	for keyType, value := range ttls {
+		switch keyType {
+		case defaultRotationTTLKey, age.KeyTypeIdentifier, azkv.KeyTypeIdentifier, gcpkms.KeyTypeIdentifier,
+			hcvault.KeyTypeIdentifier, kms.KeyTypeIdentifier, pgp.KeyTypeIdentifier, pkcs11.KeyTypeIdentifier:
+		default:
+			return nil, fmt.Errorf("unknown key type %q in rotation_ttl", keyType)
+		}
+		ttl, err := ParseTTL(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rotation_ttl for %s: %w", keyType, err)
+		}
+		r[keyType] = ttl
+	}
+	return r, nil
+}
+
+// ParseTTL parses a duration as accepted by time.ParseDuration, a number of
+// days such as "90d", or "never", which is returned as zero.
+func ParseTTL(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "never" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid number of days %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	ttl, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if ttl < 0 {
+		return 0, fmt.Errorf("negative duration %q", s)
+	}
+	return ttl, nil
+}
@@ -0,0 +1,266 @@
+package gcpkms
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	kms "cloud.google.com/go/kms/apiv1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// asymmetricPrefix is the prefix of data keys encrypted locally with the
+	// public key of an ASYMMETRIC_DECRYPT key, followed by the version of the
+	// key and the base64 encoded ciphertext, e.g. "gcpkms:v3:<ciphertext>".
+	// Prefixing the ciphertext allows decrypting without first fetching the
+	// purpose of the key, and keeps older versions of SOPS from passing it
+	// to the symmetric Decrypt API.
+	asymmetricPrefix = "gcpkms:v"
+)
+
+var (
+	// versionRegexp matches the crypto key version of a resource ID, e.g.
+	// ".../cryptoKeys/sops/cryptoKeyVersions/3".
+	versionRegexp = regexp.MustCompile(`/cryptoKeyVersions/([^/]+)$`)
+	// asymmetricRegexp matches a data key encrypted with an asymmetric key.
+	asymmetricRegexp = regexp.MustCompile(`^` + asymmetricPrefix + `([^:]+):(.+)$`)
+
+	// publicKeyCache caches the public keys of crypto key versions for the
+	// lifetime of the process. Versions are immutable, so entries never
+	// expire.
+	publicKeyCache   = map[string]*cachedPublicKey{}
+	publicKeyCacheMu sync.Mutex
+)
+
+// cachedPublicKey is the public key of an ASYMMETRIC_DECRYPT crypto key
+// version, as cached in memory and on disk.
+type cachedPublicKey struct {
+	Algorithm string `json:"algorithm"`
+	Pem       string `json:"pem"`
+}
+
+// cryptoKeyName returns the resource ID of the crypto key, without the crypto
+// key version if one is pinned.
+func (key *MasterKey) cryptoKeyName() string {
+	return versionRegexp.ReplaceAllString(key.ResourceID, "")
+}
+
+// pinnedVersion returns the crypto key version of the ResourceID, or an empty
+// string if it refers to a crypto key.
+func (key *MasterKey) pinnedVersion() string {
+	return versionOf(key.ResourceID)
+}
+
+// versionOf returns the crypto key version of a resource name, or an empty
+// string if it does not refer to a crypto key version.
+func versionOf(name string) string {
+	if m := versionRegexp.FindStringSubmatch(name); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// KeyVersion returns the crypto key version the data key was encrypted with.
+// It is recorded in the Version field when encrypting, and otherwise known for
+// data keys encrypted with an asymmetric key and for keys with a ResourceID
+// pinned to a version. An error is returned for data keys of unpinned
+// symmetric keys encrypted by older versions of SOPS, as GCP KMS ciphertexts
+// do not expose the version.
+func (key *MasterKey) KeyVersion() (int, error) {
+	version := key.pinnedVersion()
+	if m := asymmetricRegexp.FindStringSubmatch(key.EncryptedKey); m != nil {
+		version = m[1]
+	}
+	if key.Version != "" {
+		version = key.Version
+	}
+	if version == "" {
+		return 0, fmt.Errorf("crypto key version of %s is unknown", key.ResourceID)
+	}
+	return strconv.Atoi(version)
+}
+
+// encryptAsymmetric encrypts the data key locally with the public key of the
+// pinned crypto key version, if it is an ASYMMETRIC_DECRYPT key. It returns
+// false if the version is of a different purpose, in which case the data key
+// should be encrypted with the symmetric Encrypt API.
+func (key *MasterKey) encryptAsymmetric(ctx context.Context, client *lazyKMSClient, dataKey []byte) (bool, error) {
+	publicKey, err := key.publicKey(ctx, client)
+	if status.Code(err) == codes.FailedPrecondition {
+		// The version is not of an asymmetric key.
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get public key of GCP KMS key: %w", err)
+	}
+	newHash, ok := oaepHash(publicKey.Algorithm)
+	if !ok {
+		return false, nil
+	}
+	block, _ := pem.Decode([]byte(publicKey.Pem))
+	if block == nil {
+		return false, fmt.Errorf("invalid public key of GCP KMS key %s", key.ResourceID)
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse public key of GCP KMS key: %w", err)
+	}
+	rsaKey, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return false, fmt.Errorf("public key of GCP KMS key %s is not an RSA key", key.ResourceID)
+	}
+	ciphertext, err := rsa.EncryptOAEP(newHash(), rand.Reader, rsaKey, dataKey, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to encrypt sops data key with GCP KMS public key: %w", err)
+	}
+	key.EncryptedKey = asymmetricPrefix + key.pinnedVersion() + ":" + base64.StdEncoding.EncodeToString(ciphertext)
+	key.Version = key.pinnedVersion()
+	return true, nil
+}
+
+// decryptAsymmetric decrypts a data key encrypted by encryptAsymmetric with
+// the AsymmetricDecrypt API.
+func (key *MasterKey) decryptAsymmetric(ctx context.Context, service *kms.KeyManagementClient, version, ciphertext string) ([]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	req := &kmspb.AsymmetricDecryptRequest{
+		Name:       key.cryptoKeyName() + "/cryptoKeyVersions/" + version,
+		Ciphertext: decoded,
+	}
+	resp, err := service.AsymmetricDecrypt(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Plaintext, nil
+}
+
+// publicKey returns the public key of the pinned crypto key version, from the
+// in-memory cache, the on-disk cache, or GCP KMS.
+func (key *MasterKey) publicKey(ctx context.Context, client *lazyKMSClient) (*cachedPublicKey, error) {
+	publicKeyCacheMu.Lock()
+	defer publicKeyCacheMu.Unlock()
+	if cached, ok := publicKeyCache[key.ResourceID]; ok {
+		return cached, nil
+	}
+	cachePath := publicKeyCachePath(key.ResourceID)
+	if cachePath != "" {
+		if b, err := os.ReadFile(cachePath); err == nil {
+			var cached cachedPublicKey
+			if err := json.Unmarshal(b, &cached); err == nil && cached.Pem != "" {
+				publicKeyCache[key.ResourceID] = &cached
+				return &cached, nil
+			}
+		}
+	}
+
+	service, err := client.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := service.GetPublicKey(ctx, &kmspb.GetPublicKeyRequest{Name: key.ResourceID})
+	if err != nil {
+		return nil, err
+	}
+	if resp.PemCrc32C != nil && int64(crc32.Checksum([]byte(resp.Pem), crc32.MakeTable(crc32.Castagnoli))) != resp.PemCrc32C.Value {
+		return nil, fmt.Errorf("public key of GCP KMS key %s failed the integrity check", key.ResourceID)
+	}
+	cached := &cachedPublicKey{Algorithm: resp.Algorithm.String(), Pem: resp.Pem}
+	publicKeyCache[key.ResourceID] = cached
+	if cachePath != "" {
+		if err := writePublicKeyCache(cachePath, cached); err != nil {
+			log.WithField("resourceID", key.ResourceID).Debugf("Failed to cache public key: %s", err)
+		}
+	}
+	return cached, nil
+}
+
+// publicKeyCachePath returns the path the public key of the crypto key version
+// is cached at, or an empty string if there is no user cache directory.
+func publicKeyCachePath(resourceID string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(resourceID))
+	return filepath.Join(dir, "sops", "gcpkms", hex.EncodeToString(sum[:])+".json")
+}
+
+func writePublicKeyCache(path string, cached *cachedPublicKey) error {
+	b, err := json.Marshal(cached)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o600)
+}
+
+// oaepHash returns the hash function of an RSA_DECRYPT_OAEP_* algorithm.
+func oaepHash(algorithm string) (func() hash.Hash, bool) {
+	if !strings.HasPrefix(algorithm, "RSA_DECRYPT_OAEP_") {
+		return nil, false
+	}
+	switch {
+	case strings.HasSuffix(algorithm, "_SHA1"):
+		return sha1.New, true
+	case strings.HasSuffix(algorithm, "_SHA256"):
+		return sha256.New, true
+	case strings.HasSuffix(algorithm, "_SHA512"):
+		return sha512.New, true
+	}
+	return nil, false
+}
+
+// lazyKMSClient creates a GCP KMS client for a MasterKey on first use, so no
+// client, and thus no credentials, are needed if the public key of an
+// asymmetric key is cached.
+type lazyKMSClient struct {
+	key     *MasterKey
+	service *kms.KeyManagementClient
+}
+
+// Get returns the client, creating it if needed.
+func (c *lazyKMSClient) Get(ctx context.Context) (*kms.KeyManagementClient, error) {
+	if c.service != nil {
+		return c.service, nil
+	}
+	service, err := c.key.newKMSClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create GCP KMS service: %w", err)
+	}
+	c.service = service
+	return service, nil
+}
+
+// Close closes the client, if it was created.
+func (c *lazyKMSClient) Close() {
+	if c.service == nil {
+		return
+	}
+	if err := c.service.Close(); err != nil {
+		log.Error("failed to close GCP KMS client connection")
+	}
+}
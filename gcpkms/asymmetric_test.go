@@ -0,0 +1,180 @@
+package gcpkms
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"hash/crc32"
+	"os"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+var testVersionResourceID = testResourceID + "/cryptoKeyVersions/3"
+
+// resetPublicKeyCache clears the in-memory public key cache, and points the
+// on-disk cache to a temporary directory.
+func resetPublicKeyCache(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	publicKeyCacheMu.Lock()
+	publicKeyCache = map[string]*cachedPublicKey{}
+	publicKeyCacheMu.Unlock()
+}
+
+func testPublicKey(t *testing.T, priv *rsa.PrivateKey) *kmspb.PublicKey {
+	der, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	require.NoError(t, err)
+	p := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	return &kmspb.PublicKey{
+		Pem:       p,
+		Algorithm: kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_2048_SHA256,
+		PemCrc32C: wrapperspb.Int64(int64(crc32.Checksum([]byte(p), crc32.MakeTable(crc32.Castagnoli)))),
+		Name:      testVersionResourceID,
+	}
+}
+
+func TestMasterKey_EncryptAsymmetric(t *testing.T) {
+	resetPublicKeyCache(t)
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	mockKeyManagement.err = nil
+	mockKeyManagement.reqs = nil
+	mockKeyManagement.resps = append(mockKeyManagement.resps[:0], testPublicKey(t, priv))
+
+	key := MasterKey{
+		grpcConn:       newGRPCServer("0"),
+		ResourceID:     testVersionResourceID,
+		credentialJSON: []byte("arbitrary credentials"),
+	}
+	require.NoError(t, key.Encrypt([]byte("data key")))
+	require.True(t, strings.HasPrefix(key.EncryptedKey, "gcpkms:v3:"))
+	require.Len(t, mockKeyManagement.reqs, 1)
+	assert.Equal(t, testVersionResourceID, mockKeyManagement.reqs[0].(*kmspb.GetPublicKeyRequest).Name)
+
+	ciphertext, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(key.EncryptedKey, "gcpkms:v3:"))
+	require.NoError(t, err)
+	plaintext, err := rsa.DecryptOAEP(sha256.New(), nil, priv, ciphertext, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data key"), plaintext)
+
+	assert.Equal(t, "3", key.Version)
+	version, err := key.KeyVersion()
+	require.NoError(t, err)
+	assert.Equal(t, 3, version)
+
+	// The public key is cached, no further requests are made.
+	mockKeyManagement.err = status.Error(codes.PermissionDenied, "denied")
+	key.EncryptedKey = ""
+	require.NoError(t, key.Encrypt([]byte("data key")))
+	assert.Len(t, mockKeyManagement.reqs, 1)
+
+	// Including across processes.
+	publicKeyCacheMu.Lock()
+	publicKeyCache = map[string]*cachedPublicKey{}
+	publicKeyCacheMu.Unlock()
+	key.EncryptedKey = ""
+	require.NoError(t, key.Encrypt([]byte("data key")))
+	assert.Len(t, mockKeyManagement.reqs, 1)
+	_, err = os.Stat(publicKeyCachePath(testVersionResourceID))
+	assert.NoError(t, err)
+}
+
+func TestMasterKey_EncryptAsymmetric_integrity(t *testing.T) {
+	resetPublicKeyCache(t)
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	publicKey := testPublicKey(t, priv)
+	publicKey.PemCrc32C = wrapperspb.Int64(1)
+
+	mockKeyManagement.err = nil
+	mockKeyManagement.reqs = nil
+	mockKeyManagement.resps = append(mockKeyManagement.resps[:0], publicKey)
+
+	key := MasterKey{
+		grpcConn:       newGRPCServer("0"),
+		ResourceID:     testVersionResourceID,
+		credentialJSON: []byte("arbitrary credentials"),
+	}
+	assert.ErrorContains(t, key.Encrypt([]byte("data key")), "failed the integrity check")
+}
+
+func TestMasterKey_EncryptPinnedSymmetric(t *testing.T) {
+	resetPublicKeyCache(t)
+	mockKeyManagement.err = status.Error(codes.FailedPrecondition, "not an asymmetric key")
+	mockKeyManagement.reqs = nil
+
+	key := MasterKey{
+		grpcConn:       newGRPCServer("0"),
+		ResourceID:     testVersionResourceID,
+		credentialJSON: []byte("arbitrary credentials"),
+	}
+	// The fallback to the Encrypt API fails with the same mock error, but
+	// must be attempted after GetPublicKey.
+	assert.Error(t, key.Encrypt([]byte("data key")))
+	require.Len(t, mockKeyManagement.reqs, 2)
+	assert.IsType(t, &kmspb.GetPublicKeyRequest{}, mockKeyManagement.reqs[0])
+	assert.Equal(t, testVersionResourceID, mockKeyManagement.reqs[1].(*kmspb.EncryptRequest).Name)
+}
+
+func TestMasterKey_DecryptAsymmetric(t *testing.T) {
+	mockKeyManagement.err = nil
+	mockKeyManagement.reqs = nil
+	mockKeyManagement.resps = append(mockKeyManagement.resps[:0], &kmspb.AsymmetricDecryptResponse{
+		Plaintext: []byte(decryptedData),
+	})
+	key := MasterKey{
+		grpcConn:       newGRPCServer("0"),
+		ResourceID:     testVersionResourceID,
+		EncryptedKey:   "gcpkms:v2:" + base64.StdEncoding.EncodeToString([]byte(encryptedData)),
+		credentialJSON: []byte("arbitrary credentials"),
+	}
+	data, err := key.Decrypt()
+	require.NoError(t, err)
+	assert.EqualValues(t, decryptedData, data)
+	require.Len(t, mockKeyManagement.reqs, 1)
+	req := mockKeyManagement.reqs[0].(*kmspb.AsymmetricDecryptRequest)
+	assert.Equal(t, testResourceID+"/cryptoKeyVersions/2", req.Name)
+	assert.Equal(t, []byte(encryptedData), req.Ciphertext)
+}
+
+func TestMasterKey_DecryptPinnedSymmetric(t *testing.T) {
+	mockKeyManagement.err = nil
+	mockKeyManagement.reqs = nil
+	mockKeyManagement.resps = append(mockKeyManagement.resps[:0], &kmspb.DecryptResponse{
+		Plaintext: []byte(decryptedData),
+	})
+	key := MasterKey{
+		grpcConn:       newGRPCServer("0"),
+		ResourceID:     testVersionResourceID,
+		EncryptedKey:   base64.StdEncoding.EncodeToString([]byte(encryptedData)),
+		credentialJSON: []byte("arbitrary credentials"),
+	}
+	_, err := key.Decrypt()
+	require.NoError(t, err)
+	require.Len(t, mockKeyManagement.reqs, 1)
+	assert.Equal(t, testResourceID, mockKeyManagement.reqs[0].(*kmspb.DecryptRequest).Name)
+}
+
+func TestMasterKey_KeyVersion(t *testing.T) {
+	_, err := (&MasterKey{ResourceID: testResourceID, EncryptedKey: "Zm9v"}).KeyVersion()
+	assert.Error(t, err)
+
+	version, err := (&MasterKey{ResourceID: testVersionResourceID, EncryptedKey: "Zm9v"}).KeyVersion()
+	require.NoError(t, err)
+	assert.Equal(t, 3, version)
+
+	version, err = (&MasterKey{ResourceID: testResourceID, Version: "5", EncryptedKey: "Zm9v"}).KeyVersion()
+	require.NoError(t, err)
+	assert.Equal(t, 5, version)
+}
@@ -46,8 +46,13 @@ func init() {
 // data key.
 type MasterKey struct {
 	// ResourceID is the resource id used to refer to the gcp kms key.
-	// It can be retrieved using the `gcloud` command.
+	// It can be retrieved using the `gcloud` command. It may be pinned to a
+	// crypto key version, which is required for ASYMMETRIC_DECRYPT keys.
 	ResourceID string
+	// Version is the crypto key version the data key was encrypted with, as
+	// reported by GCP KMS. Empty for data keys encrypted by older versions
+	// of SOPS.
+	Version string
 	// EncryptedKey is the string returned after encrypting with GCP KMS.
 	EncryptedKey string
 	// CreationDate is the creation timestamp of the MasterKey. Used
@@ -137,16 +142,26 @@ func (key *MasterKey) Encrypt(dataKey []byte) error {
 // EncryptContext takes a SOPS data key, encrypts it with GCP KMS, and stores the
 // result in the EncryptedKey field.
 func (key *MasterKey) EncryptContext(ctx context.Context, dataKey []byte) error {
-	service, err := key.newKMSClient(ctx)
+	client := &lazyKMSClient{key: key}
+	defer client.Close()
+
+	if key.pinnedVersion() != "" {
+		ok, err := key.encryptAsymmetric(ctx, client, dataKey)
+		if err != nil {
+			log.WithField("resourceID", key.ResourceID).Info("Encryption failed")
+			return err
+		}
+		if ok {
+			log.WithField("resourceID", key.ResourceID).Info("Encryption succeeded")
+			return nil
+		}
+	}
+
+	service, err := client.Get(ctx)
 	if err != nil {
 		log.WithField("resourceID", key.ResourceID).Info("Encryption failed")
-		return fmt.Errorf("cannot create GCP KMS service: %w", err)
+		return err
 	}
-	defer func() {
-		if err := service.Close(); err != nil {
-			log.Error("failed to close GCP KMS client connection")
-		}
-	}()
 
 	req := &kmspb.EncryptRequest{
 		Name:      key.ResourceID,
@@ -161,6 +176,8 @@ func (key *MasterKey) EncryptContext(ctx context.Context, dataKey []byte) error
 	// The previous GCP KMS client used to work with base64 encoded
 	// strings.
 	key.EncryptedKey = base64.StdEncoding.EncodeToString(resp.Ciphertext)
+	// The response names the primary version of the key that was used.
+	key.Version = versionOf(resp.Name)
 	log.WithField("resourceID", key.ResourceID).Info("Encryption succeeded")
 	return nil
 }
@@ -170,6 +187,12 @@ func (key *MasterKey) SetEncryptedDataKey(enc []byte) {
 	key.EncryptedKey = string(enc)
 }
 
+// SetKeyVersion sets the crypto key version the data key was encrypted with,
+// as returned by a key service.
+func (key *MasterKey) SetKeyVersion(version string) {
+	key.Version = version
+}
+
 // EncryptedDataKey returns the encrypted data key this master key holds.
 func (key *MasterKey) EncryptedDataKey() []byte {
 	return []byte(key.EncryptedKey)
@@ -206,6 +229,16 @@ func (key *MasterKey) DecryptContext(ctx context.Context) ([]byte, error) {
 		}
 	}()
 
+	if m := asymmetricRegexp.FindStringSubmatch(key.EncryptedKey); m != nil {
+		plaintext, err := key.decryptAsymmetric(ctx, service, m[1], m[2])
+		if err != nil {
+			log.WithField("resourceID", key.ResourceID).Info("Decryption failed")
+			return nil, fmt.Errorf("failed to decrypt sops data key with GCP KMS key: %w", err)
+		}
+		log.WithField("resourceID", key.ResourceID).Info("Decryption succeeded")
+		return plaintext, nil
+	}
+
 	// NB: this is for compatibility with SOPS <=3.8.x. The previous GCP KMS
 	// client used to work with base64 encoded strings.
 	decodedCipher, err := base64.StdEncoding.DecodeString(string(key.EncryptedDataKey()))
@@ -215,7 +248,9 @@ func (key *MasterKey) DecryptContext(ctx context.Context) ([]byte, error) {
 	}
 
 	req := &kmspb.DecryptRequest{
-		Name:       key.ResourceID,
+		// Decryption requires the crypto key, KMS selects the version
+		// from the ciphertext.
+		Name:       key.cryptoKeyName(),
 		Ciphertext: decodedCipher,
 	}
 	resp, err := service.Decrypt(ctx, req)
@@ -242,6 +277,9 @@ func (key *MasterKey) ToString() string {
 func (key MasterKey) ToMap() map[string]interface{} {
 	out := make(map[string]interface{})
 	out["resource_id"] = key.ResourceID
+	if key.Version != "" {
+		out["version"] = key.Version
+	}
 	out["created_at"] = key.CreationDate.UTC().Format(time.RFC3339)
 	out["enc"] = key.EncryptedKey
 	return out
@@ -257,7 +295,7 @@ func (key *MasterKey) TypeToIdentifier() string {
 // It returns an error if the ResourceID is invalid, or if the setup of the
 // client fails.
 func (key *MasterKey) newKMSClient(ctx context.Context) (*kms.KeyManagementClient, error) {
-	re := regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+$`)
+	re := regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+(/cryptoKeyVersions/[^/]+)?$`)
 	matches := re.FindStringSubmatch(key.ResourceID)
 	if matches == nil {
 		return nil, fmt.Errorf("no valid resource ID found in %q", key.ResourceID)
@@ -10,6 +10,7 @@ import (
 
 	"cloud.google.com/go/kms/apiv1/kmspb"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"golang.org/x/oauth2"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
@@ -58,6 +59,7 @@ func TestMasterKey_Encrypt(t *testing.T) {
 	mockKeyManagement.err = nil
 	mockKeyManagement.reqs = nil
 	mockKeyManagement.resps = append(mockKeyManagement.resps[:0], &kmspb.EncryptResponse{
+		Name:       testResourceID + "/cryptoKeyVersions/4",
 		Ciphertext: []byte(encryptedData),
 	})
 
@@ -69,6 +71,11 @@ func TestMasterKey_Encrypt(t *testing.T) {
 	err := key.Encrypt([]byte("encrypt"))
 	assert.NoError(t, err)
 	assert.EqualValues(t, base64.StdEncoding.EncodeToString([]byte(encryptedData)), key.EncryptedDataKey())
+	assert.Equal(t, "4", key.Version)
+	assert.Equal(t, "4", key.ToMap()["version"])
+	version, err := key.KeyVersion()
+	require.NoError(t, err)
+	assert.Equal(t, 4, version)
 }
 
 func TestMasterKey_EncryptIfNeeded(t *testing.T) {
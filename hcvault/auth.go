@@ -0,0 +1,240 @@
+package hcvault
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	// SopsVaultAuthMethodEnv can be set as an environment variable to the
+	// auth method used to log in to Vault. It takes precedence over the
+	// auth_method of the MasterKey.
+	SopsVaultAuthMethodEnv = "SOPS_VAULT_AUTH_METHOD"
+	// SopsVaultAuthMountEnv can be set as an environment variable to the
+	// path the auth method is mounted on, if it differs from the name of
+	// the auth method.
+	SopsVaultAuthMountEnv = "SOPS_VAULT_AUTH_MOUNT"
+	// SopsVaultRoleEnv can be set as an environment variable to the role to
+	// log in with. It takes precedence over the role of the MasterKey. For
+	// AppRole, this is the role ID.
+	SopsVaultRoleEnv = "SOPS_VAULT_ROLE"
+	// SopsVaultSecretIDEnv can be set as an environment variable to the
+	// secret ID used to log in with AppRole.
+	SopsVaultSecretIDEnv = "SOPS_VAULT_SECRET_ID"
+	// SopsVaultSecretIDFileEnv can be set as an environment variable to the
+	// path of a file containing the secret ID used to log in with AppRole.
+	SopsVaultSecretIDFileEnv = "SOPS_VAULT_SECRET_ID_FILE"
+	// SopsVaultJWTEnv can be set as an environment variable to the JWT used
+	// to log in with the Kubernetes or JWT/OIDC auth methods.
+	SopsVaultJWTEnv = "SOPS_VAULT_JWT"
+	// SopsVaultJWTFileEnv can be set as an environment variable to the path
+	// of a file containing the JWT used to log in with the Kubernetes or
+	// JWT/OIDC auth methods.
+	SopsVaultJWTFileEnv = "SOPS_VAULT_JWT_FILE"
+
+	// AuthMethodToken uses a Vault token, which is the default.
+	AuthMethodToken = "token"
+	// AuthMethodAppRole logs in with a role ID and secret ID.
+	AuthMethodAppRole = "approle"
+	// AuthMethodKubernetes logs in with a Kubernetes service account token.
+	AuthMethodKubernetes = "kubernetes"
+	// AuthMethodJWT logs in with a JWT, e.g. issued to a CI job.
+	AuthMethodJWT = "jwt"
+	// AuthMethodOIDC logs in with a JWT to an auth method mounted at "oidc".
+	AuthMethodOIDC = "oidc"
+)
+
+var (
+	// defaultKubernetesTokenFile is the path of the service account token
+	// used with the Kubernetes auth method if SopsVaultJWTFileEnv is not set.
+	defaultKubernetesTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+	// tokenCache holds the tokens obtained by logging in, so that they can be
+	// reused and renewed across MasterKeys and operations.
+	tokenCache   = map[string]*cachedToken{}
+	tokenCacheMu sync.Mutex
+)
+
+// validAuthMethod returns whether the method is a supported auth method.
+func validAuthMethod(method string) bool {
+	switch method {
+	case "", AuthMethodToken, AuthMethodAppRole, AuthMethodKubernetes, AuthMethodJWT, AuthMethodOIDC:
+		return true
+	}
+	return false
+}
+
+// authConfig describes how to log in to Vault.
+type authConfig struct {
+	method string
+	mount  string
+	role   string
+}
+
+// authConfig returns the authConfig of the key, taking the environment into
+// account.
+func (key *MasterKey) authConfig() authConfig {
+	cfg := authConfig{
+		method: key.AuthMethod,
+		role:   key.Role,
+	}
+	if method := os.Getenv(SopsVaultAuthMethodEnv); method != "" {
+		cfg.method = method
+	}
+	if role := os.Getenv(SopsVaultRoleEnv); role != "" {
+		cfg.role = role
+	}
+	cfg.mount = cfg.method
+	if mount := os.Getenv(SopsVaultAuthMountEnv); mount != "" {
+		cfg.mount = strings.Trim(mount, "/")
+	}
+	return cfg
+}
+
+// usesToken returns whether the configuration uses a Vault token instead of
+// logging in.
+func (c authConfig) usesToken() bool {
+	return c.method == "" || c.method == AuthMethodToken
+}
+
+// loginPayload returns the path and payload of the login request.
+func (c authConfig) loginPayload() (string, map[string]interface{}, error) {
+	path := "auth/" + c.mount + "/login"
+	switch c.method {
+	case AuthMethodAppRole:
+		if c.role == "" {
+			return "", nil, fmt.Errorf("AppRole auth requires a role ID, set %s or the role of the key", SopsVaultRoleEnv)
+		}
+		secretID, err := secretFromEnv(SopsVaultSecretIDEnv, SopsVaultSecretIDFileEnv, "")
+		if err != nil {
+			return "", nil, err
+		}
+		payload := map[string]interface{}{"role_id": c.role}
+		if secretID != "" {
+			payload["secret_id"] = secretID
+		}
+		return path, payload, nil
+	case AuthMethodKubernetes, AuthMethodJWT, AuthMethodOIDC:
+		if c.role == "" {
+			return "", nil, fmt.Errorf("%s auth requires a role, set %s or the role of the key", c.method, SopsVaultRoleEnv)
+		}
+		defaultFile := ""
+		if c.method == AuthMethodKubernetes {
+			defaultFile = defaultKubernetesTokenFile
+		}
+		jwt, err := secretFromEnv(SopsVaultJWTEnv, SopsVaultJWTFileEnv, defaultFile)
+		if err != nil {
+			return "", nil, err
+		}
+		if jwt == "" {
+			return "", nil, fmt.Errorf("%s auth requires a JWT, set %s or %s", c.method, SopsVaultJWTEnv, SopsVaultJWTFileEnv)
+		}
+		return path, map[string]interface{}{"role": c.role, "jwt": jwt}, nil
+	default:
+		return "", nil, fmt.Errorf("unsupported Vault auth method %q", c.method)
+	}
+}
+
+// secretFromEnv returns the value of the valueEnv environment variable, or
+// the contents of the file at the path in the fileEnv environment variable,
+// falling back to defaultFile.
+func secretFromEnv(valueEnv, fileEnv, defaultFile string) (string, error) {
+	if value := os.Getenv(valueEnv); value != "" {
+		return value, nil
+	}
+	file := os.Getenv(fileEnv)
+	if file == "" {
+		file = defaultFile
+	}
+	if file == "" {
+		return "", nil
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// cachedToken is a token obtained by logging in.
+type cachedToken struct {
+	token     string
+	renewable bool
+	ttl       time.Duration
+	expiresAt time.Time
+}
+
+// fresh returns whether the token can be used without renewing it, which is
+// the case until two thirds of its TTL have passed.
+func (t *cachedToken) fresh() bool {
+	return t.ttl == 0 || time.Until(t.expiresAt) > t.ttl/3
+}
+
+// newCachedToken returns a cachedToken for the auth of a login or renewal
+// response.
+func newCachedToken(auth *api.SecretAuth) *cachedToken {
+	ttl := time.Duration(auth.LeaseDuration) * time.Second
+	return &cachedToken{
+		token:     auth.ClientToken,
+		renewable: auth.Renewable,
+		ttl:       ttl,
+		expiresAt: time.Now().Add(ttl),
+	}
+}
+
+// authenticate sets a token obtained by logging in with the given
+// configuration on the client. Tokens are cached, renewed once two thirds of
+// their TTL have passed, and replaced by logging in again if they can not be
+// renewed.
+func authenticate(ctx context.Context, client *api.Client, cfg authConfig) error {
+	cacheKey := strings.Join([]string{client.Address(), client.Namespace(), cfg.method, cfg.mount, cfg.role}, "|")
+
+	tokenCacheMu.Lock()
+	defer tokenCacheMu.Unlock()
+
+	if cached, ok := tokenCache[cacheKey]; ok {
+		if cached.fresh() {
+			client.SetToken(cached.token)
+			return nil
+		}
+		if cached.renewable && time.Now().Before(cached.expiresAt) {
+			client.SetToken(cached.token)
+			secret, err := client.Auth().Token().RenewSelfWithContext(ctx, int(cached.ttl.Seconds()))
+			if err == nil && secret != nil && secret.Auth != nil {
+				renewed := newCachedToken(secret.Auth)
+				renewed.token = cached.token
+				tokenCache[cacheKey] = renewed
+				log.WithField("Method", cfg.method).Debug("Renewed Vault token")
+				return nil
+			}
+			log.WithField("Method", cfg.method).WithError(err).Debug("Failed to renew Vault token, logging in again")
+		}
+		delete(tokenCache, cacheKey)
+	}
+
+	path, payload, err := cfg.loginPayload()
+	if err != nil {
+		return err
+	}
+	// Login endpoints do not require a token, and an invalid one may be
+	// rejected.
+	client.ClearToken()
+	secret, err := client.Logical().WriteWithContext(ctx, path, payload)
+	if err != nil {
+		return fmt.Errorf("failed to log in to Vault with %s auth: %w", cfg.method, err)
+	}
+	if secret == nil || secret.Auth == nil || secret.Auth.ClientToken == "" {
+		return fmt.Errorf("failed to log in to Vault with %s auth: no token in response", cfg.method)
+	}
+	token := newCachedToken(secret.Auth)
+	tokenCache[cacheKey] = token
+	client.SetToken(token.token)
+	log.WithField("Method", cfg.method).Debug("Logged in to Vault")
+	return nil
+}
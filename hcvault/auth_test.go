@@ -0,0 +1,314 @@
+package hcvault
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/go-homedir"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeVault is an httptest fake of the Vault login, token renewal and
+// Transit encrypt, decrypt and rewrap endpoints.
+type fakeVault struct {
+	*httptest.Server
+
+	mu sync.Mutex
+	// logins counts the login requests per auth mount.
+	logins map[string]int
+	// renewals counts the token renewal requests.
+	renewals int
+	// namespaces records the namespace header of Transit requests.
+	namespaces []string
+	// leaseDuration is the TTL of issued tokens in seconds.
+	leaseDuration int
+	// tokens holds the issued tokens.
+	tokens map[string]bool
+	// keyVersion is the latest version of the Transit key.
+	keyVersion int
+}
+
+func newFakeVault(t *testing.T) *fakeVault {
+	t.Helper()
+	f := &fakeVault{
+		logins:        map[string]int{},
+		leaseDuration: 3600,
+		tokens:        map[string]bool{},
+		keyVersion:    1,
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(f.Close)
+
+	// Make sure no token of the environment is used.
+	homedir.Reset()
+	t.Cleanup(func() { homedir.Reset() })
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("VAULT_TOKEN", "")
+	t.Setenv("VAULT_NAMESPACE", "")
+	for _, env := range []string{SopsVaultAuthMethodEnv, SopsVaultAuthMountEnv, SopsVaultRoleEnv, SopsVaultSecretIDEnv, SopsVaultSecretIDFileEnv, SopsVaultJWTEnv, SopsVaultJWTFileEnv} {
+		t.Setenv(env, "")
+	}
+	resetTokenCache(t)
+	return f
+}
+
+// resetTokenCache empties the token cache before and after the test.
+func resetTokenCache(t *testing.T) {
+	tokenCacheMu.Lock()
+	tokenCache = map[string]*cachedToken{}
+	tokenCacheMu.Unlock()
+	t.Cleanup(func() {
+		tokenCacheMu.Lock()
+		tokenCache = map[string]*cachedToken{}
+		tokenCacheMu.Unlock()
+	})
+}
+
+func (f *fakeVault) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var body map[string]interface{}
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	reply := func(v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(v)
+	}
+	fail := func(code int, msg string) {
+		w.WriteHeader(code)
+		reply(map[string]interface{}{"errors": []string{msg}})
+	}
+	issue := func() {
+		token := fmt.Sprintf("token-%d", len(f.tokens)+1)
+		f.tokens[token] = true
+		reply(map[string]interface{}{"auth": map[string]interface{}{
+			"client_token":   token,
+			"lease_duration": f.leaseDuration,
+			"renewable":      true,
+		}})
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	switch {
+	case path == "auth/approle/login" || path == "auth/ci/login":
+		f.logins[path]++
+		if body["role_id"] != "role-id" || body["secret_id"] != "secret-id" {
+			fail(http.StatusBadRequest, "invalid role or secret ID")
+			return
+		}
+		issue()
+	case path == "auth/kubernetes/login" || path == "auth/jwt/login" || path == "auth/oidc/login":
+		f.logins[path]++
+		if body["role"] != "sops" || body["jwt"] != "a.jwt.token" {
+			fail(http.StatusBadRequest, "invalid role or JWT")
+			return
+		}
+		issue()
+	case path == "auth/shared/login":
+		// A mount accepting the credentials of both AppRole and JWT auth.
+		f.logins[path]++
+		if !(body["role_id"] == "sops" && body["secret_id"] == "secret-id") && !(body["role"] == "sops" && body["jwt"] == "a.jwt.token") {
+			fail(http.StatusBadRequest, "invalid credentials")
+			return
+		}
+		issue()
+	case path == "auth/token/renew-self":
+		if !f.tokens[r.Header.Get("X-Vault-Token")] {
+			fail(http.StatusForbidden, "permission denied")
+			return
+		}
+		f.renewals++
+		reply(map[string]interface{}{"auth": map[string]interface{}{
+			"client_token":   r.Header.Get("X-Vault-Token"),
+			"lease_duration": f.leaseDuration,
+			"renewable":      true,
+		}})
+	case strings.HasPrefix(path, "transit/"):
+		if !f.tokens[r.Header.Get("X-Vault-Token")] {
+			fail(http.StatusForbidden, "permission denied")
+			return
+		}
+		f.namespaces = append(f.namespaces, r.Header.Get("X-Vault-Namespace"))
+		// The fake "encrypts" by prefixing the plaintext with the key version.
+		prefix := fmt.Sprintf("vault:v%d:", f.keyVersion)
+		switch {
+		case strings.HasPrefix(path, "transit/encrypt/"):
+			reply(map[string]interface{}{"data": map[string]interface{}{"ciphertext": prefix + body["plaintext"].(string)}})
+		case strings.HasPrefix(path, "transit/decrypt/"):
+			reply(map[string]interface{}{"data": map[string]interface{}{"plaintext": keyVersionRegexp.ReplaceAllString(body["ciphertext"].(string), "")}})
+		case strings.HasPrefix(path, "transit/rewrap/"):
+			reply(map[string]interface{}{"data": map[string]interface{}{"ciphertext": prefix + keyVersionRegexp.ReplaceAllString(body["ciphertext"].(string), "")}})
+		default:
+			fail(http.StatusNotFound, "unexpected path "+path)
+		}
+	default:
+		fail(http.StatusNotFound, "unexpected path "+path)
+	}
+}
+
+// key returns a MasterKey for the fake server, configured with the given
+// query parameters.
+func (f *fakeVault) key(t *testing.T, query string) *MasterKey {
+	t.Helper()
+	uri := f.URL + "/v1/transit/keys/sops"
+	if query != "" {
+		uri += "?" + query
+	}
+	key, err := NewMasterKeyFromURI(uri)
+	require.NoError(t, err)
+	NewHTTPClient(f.Client()).ApplyToMasterKey(key)
+	return key
+}
+
+func TestMasterKey_AuthMethods(t *testing.T) {
+	dataKey := []byte("data key")
+
+	t.Run("approle", func(t *testing.T) {
+		f := newFakeVault(t)
+		t.Setenv(SopsVaultSecretIDEnv, "secret-id")
+		key := f.key(t, "auth_method=approle&role=role-id&namespace=team")
+		require.NoError(t, key.Encrypt(dataKey))
+		got, err := key.Decrypt()
+		require.NoError(t, err)
+		assert.Equal(t, dataKey, got)
+
+		// The token of the first login is reused.
+		assert.Equal(t, map[string]int{"auth/approle/login": 1}, f.logins)
+		assert.Equal(t, []string{"team", "team"}, f.namespaces)
+	})
+
+	t.Run("approle with secret ID file and custom mount", func(t *testing.T) {
+		f := newFakeVault(t)
+		secretIDFile := filepath.Join(t.TempDir(), "secret-id")
+		require.NoError(t, os.WriteFile(secretIDFile, []byte("secret-id\n"), 0o600))
+		t.Setenv(SopsVaultSecretIDFileEnv, secretIDFile)
+		t.Setenv(SopsVaultAuthMountEnv, "/ci/")
+		require.NoError(t, f.key(t, "auth_method=approle&role=role-id").Encrypt(dataKey))
+		assert.Equal(t, map[string]int{"auth/ci/login": 1}, f.logins)
+	})
+
+	t.Run("kubernetes", func(t *testing.T) {
+		f := newFakeVault(t)
+		tokenFile := filepath.Join(t.TempDir(), "token")
+		require.NoError(t, os.WriteFile(tokenFile, []byte("a.jwt.token"), 0o600))
+		defaultKubernetesTokenFile = tokenFile
+		t.Cleanup(func() { defaultKubernetesTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token" })
+		require.NoError(t, f.key(t, "auth_method=kubernetes&role=sops").Encrypt(dataKey))
+		assert.Equal(t, map[string]int{"auth/kubernetes/login": 1}, f.logins)
+	})
+
+	t.Run("jwt and oidc from environment", func(t *testing.T) {
+		f := newFakeVault(t)
+		t.Setenv(SopsVaultJWTEnv, "a.jwt.token")
+		t.Setenv(SopsVaultRoleEnv, "sops")
+		t.Setenv(SopsVaultAuthMethodEnv, AuthMethodJWT)
+		require.NoError(t, f.key(t, "").Encrypt(dataKey))
+		t.Setenv(SopsVaultAuthMethodEnv, AuthMethodOIDC)
+		require.NoError(t, f.key(t, "auth_method=approle&role=ignored").Encrypt(dataKey))
+		assert.Equal(t, map[string]int{"auth/jwt/login": 1, "auth/oidc/login": 1}, f.logins)
+	})
+
+	t.Run("methods sharing a mount and role", func(t *testing.T) {
+		f := newFakeVault(t)
+		t.Setenv(SopsVaultAuthMountEnv, "shared")
+		t.Setenv(SopsVaultSecretIDEnv, "secret-id")
+		t.Setenv(SopsVaultJWTEnv, "a.jwt.token")
+		require.NoError(t, f.key(t, "auth_method=approle&role=sops").Encrypt(dataKey))
+		require.NoError(t, f.key(t, "auth_method=jwt&role=sops").Encrypt(dataKey))
+		// Tokens of different auth methods are not shared.
+		assert.Equal(t, map[string]int{"auth/shared/login": 2}, f.logins)
+	})
+
+	t.Run("provided token takes precedence", func(t *testing.T) {
+		f := newFakeVault(t)
+		f.tokens["provided"] = true
+		key := f.key(t, "auth_method=approle&role=role-id")
+		Token("provided").ApplyToMasterKey(key)
+		require.NoError(t, key.Encrypt(dataKey))
+		assert.Empty(t, f.logins)
+	})
+
+	t.Run("login failure", func(t *testing.T) {
+		f := newFakeVault(t)
+		t.Setenv(SopsVaultSecretIDEnv, "wrong")
+		err := f.key(t, "auth_method=approle&role=role-id").Encrypt(dataKey)
+		assert.ErrorContains(t, err, "failed to log in to Vault with approle auth")
+	})
+
+	t.Run("missing role", func(t *testing.T) {
+		f := newFakeVault(t)
+		err := f.key(t, "auth_method=kubernetes").Encrypt(dataKey)
+		assert.ErrorContains(t, err, "kubernetes auth requires a role")
+	})
+}
+
+func TestMasterKey_AuthRenewal(t *testing.T) {
+	f := newFakeVault(t)
+	t.Setenv(SopsVaultSecretIDEnv, "secret-id")
+	key := f.key(t, "auth_method=approle&role=role-id")
+	require.NoError(t, key.Encrypt([]byte("data key")))
+	require.Len(t, tokenCache, 1)
+
+	setExpiry := func(d time.Duration) {
+		for _, cached := range tokenCache {
+			cached.expiresAt = time.Now().Add(d)
+		}
+	}
+
+	// A token with less than a third of its TTL left is renewed.
+	setExpiry(time.Minute)
+	_, err := key.Decrypt()
+	require.NoError(t, err)
+	assert.Equal(t, 1, f.renewals)
+	assert.Equal(t, 1, f.logins["auth/approle/login"])
+	for _, cached := range tokenCache {
+		assert.True(t, cached.fresh())
+	}
+
+	// An expired token is replaced by logging in again.
+	setExpiry(-time.Minute)
+	_, err = key.Decrypt()
+	require.NoError(t, err)
+	assert.Equal(t, 1, f.renewals)
+	assert.Equal(t, 2, f.logins["auth/approle/login"])
+
+	// A token which can not be renewed is replaced as well.
+	f.tokens = map[string]bool{}
+	setExpiry(time.Minute)
+	_, err = key.Decrypt()
+	require.NoError(t, err)
+	assert.Equal(t, 1, f.renewals)
+	assert.Equal(t, 3, f.logins["auth/approle/login"])
+}
+
+func TestNewMasterKeyFromURI_Auth(t *testing.T) {
+	key, err := NewMasterKeyFromURI("https://vault.example.com:8200/v1/transit/keys/keyName?auth_method=kubernetes&role=sops&namespace=team%2Fdev")
+	require.NoError(t, err)
+	assert.Equal(t, "https://vault.example.com:8200", key.VaultAddress)
+	assert.Equal(t, "transit", key.EnginePath)
+	assert.Equal(t, "keyName", key.KeyName)
+	assert.Equal(t, AuthMethodKubernetes, key.AuthMethod)
+	assert.Equal(t, "sops", key.Role)
+	assert.Equal(t, "team/dev", key.Namespace)
+	assert.Equal(t, "https://vault.example.com:8200/v1/transit/keys/keyName?auth_method=kubernetes&namespace=team%2Fdev&role=sops", key.ToString())
+
+	m := key.ToMap()
+	assert.Equal(t, AuthMethodKubernetes, m["auth_method"])
+	assert.Equal(t, "sops", m["role"])
+	assert.Equal(t, "team/dev", m["namespace"])
+
+	_, err = NewMasterKeyFromURI("https://vault.example.com:8200/v1/transit/keys/keyName?auth_method=ldap")
+	assert.ErrorContains(t, err, `unsupported Vault auth method "ldap"`)
+
+	_, err = NewMasterKeyFromURI("https://vault.example.com:8200/v1/transit/keys/keyName?mount=approle")
+	assert.ErrorContains(t, err, `unsupported query parameter "mount"`)
+}
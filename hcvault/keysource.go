@@ -13,6 +13,7 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -40,6 +41,9 @@ var (
 	// defaultTokenFile is the name of the file in the user's home directory
 	// where a Vault token is expected to be stored.
 	defaultTokenFile = ".vault-token"
+	// keyVersionRegexp matches the key version prefix of Vault Transit
+	// ciphertexts, e.g. "vault:v2:".
+	keyVersionRegexp = regexp.MustCompile(`^vault:v(\d+):`)
 )
 
 // Token used for authenticating towards a Vault server.
@@ -66,11 +70,20 @@ type MasterKey struct {
 	// CreationDate of the MasterKey, used to determine if the EncryptedKey
 	// needs rotation.
 	CreationDate time.Time
+	// AuthMethod is the Vault auth method used to obtain a token, e.g.
+	// "approle" or "kubernetes". If empty, a token is used.
+	AuthMethod string
+	// Role is the role to log in with using the AuthMethod. For AppRole,
+	// this is the role ID.
+	Role string
+	// Namespace is the Vault Enterprise namespace of the Transit engine.
+	Namespace string
 
 	// token is the token used for authenticating against the VaultAddress
 	// server. It can be injected by a (local) keyservice.KeyServiceServer
-	// Token.ApplyToMasterKey. If empty, the default client configuration
-	// is used, before falling back to the token stored in defaultTokenFile.
+	// Token.ApplyToMasterKey. If empty, a token is obtained using the
+	// AuthMethod, or else the default client configuration is used, before
+	// falling back to the token stored in defaultTokenFile.
 	token string
 	// httpClient is used to override the default HTTP client used by the Vault client.
 	httpClient *http.Client
@@ -97,7 +110,10 @@ func NewMasterKeysFromURIs(uris string) ([]*MasterKey, error) {
 }
 
 // NewMasterKeyFromURI obtains the Vault address, Transit backend path and the
-// key name from the full URI of the key.
+// key name from the full URI of the key. The auth method, role and namespace
+// can be configured using the auth_method, role and namespace query
+// parameters, e.g.
+// https://vault.example.com:8200/v1/transit/keys/keyName?auth_method=approle&role=roleID.
 func NewMasterKeyFromURI(uri string) (*MasterKey, error) {
 	var key *MasterKey
 	if uri == "" {
@@ -111,13 +127,30 @@ func NewMasterKeyFromURI(uri string) (*MasterKey, error) {
 		return nil, fmt.Errorf("missing scheme in Vault URL (should be like this: +"+
 			"https://vault.example.com:8200/v1/transit/keys/keyName), got: %v", uri)
 	}
+	query := u.Query()
+	u.RawQuery = ""
 	enginePath, keyName, err := engineAndKeyFromPath(u.RequestURI())
 	if err != nil {
 		return nil, err
 	}
 	u.Path = ""
-	return NewMasterKey(u.String(), enginePath, keyName), nil
-
+	key = NewMasterKey(u.String(), enginePath, keyName)
+	for param, values := range query {
+		switch param {
+		case "auth_method":
+			key.AuthMethod = values[0]
+		case "role":
+			key.Role = values[0]
+		case "namespace":
+			key.Namespace = values[0]
+		default:
+			return nil, fmt.Errorf("unsupported query parameter %q in Vault URL %s", param, uri)
+		}
+	}
+	if !validAuthMethod(key.AuthMethod) {
+		return nil, fmt.Errorf("unsupported Vault auth method %q in Vault URL %s", key.AuthMethod, uri)
+	}
+	return key, nil
 }
 
 // NewMasterKey creates a new MasterKey from a Vault address, Transit backend
@@ -161,7 +194,7 @@ func (key *MasterKey) Encrypt(dataKey []byte) error {
 func (key *MasterKey) EncryptContext(ctx context.Context, dataKey []byte) error {
 	fullPath := key.encryptPath()
 
-	client, err := vaultClient(key.VaultAddress, key.token, key.httpClient)
+	client, err := key.client(ctx)
 	if err != nil {
 		log.WithField("Path", fullPath).Info("Encryption failed")
 		return err
@@ -213,7 +246,7 @@ func (key *MasterKey) Decrypt() ([]byte, error) {
 func (key *MasterKey) DecryptContext(ctx context.Context) ([]byte, error) {
 	fullPath := key.decryptPath()
 
-	client, err := vaultClient(key.VaultAddress, key.token, key.httpClient)
+	client, err := key.client(ctx)
 	if err != nil {
 		log.WithField("Path", fullPath).Info("Decryption failed")
 		return nil, err
@@ -234,15 +267,75 @@ func (key *MasterKey) DecryptContext(ctx context.Context) ([]byte, error) {
 	return dataKey, nil
 }
 
+// Rewrap rewraps the EncryptedKey with the latest version of the Vault
+// Transit key, without the data key leaving Vault.
+//
+// Consider using RewrapContext instead.
+func (key *MasterKey) Rewrap() error {
+	return key.RewrapContext(context.Background())
+}
+
+// RewrapContext rewraps the EncryptedKey with the latest version of the
+// Vault Transit key, without the data key leaving Vault.
+func (key *MasterKey) RewrapContext(ctx context.Context) error {
+	fullPath := key.rewrapPath()
+
+	client, err := key.client(ctx)
+	if err != nil {
+		log.WithField("Path", fullPath).Info("Rewrapping failed")
+		return err
+	}
+
+	secret, err := client.Logical().WriteWithContext(ctx, fullPath, decryptPayload(key.EncryptedKey))
+	if err != nil {
+		log.WithField("Path", fullPath).Info("Rewrapping failed")
+		return fmt.Errorf("failed to rewrap sops data key with Vault transit backend '%s': %w", fullPath, err)
+	}
+	encryptedKey, err := encryptedKeyFromSecret(secret)
+	if err != nil {
+		log.WithField("Path", fullPath).Info("Rewrapping failed")
+		return fmt.Errorf("failed to rewrap sops data key with Vault transit backend '%s': %w", fullPath, err)
+	}
+
+	key.EncryptedKey = encryptedKey
+	log.WithField("Path", fullPath).Info("Rewrapping successful")
+	return nil
+}
+
+// KeyVersion returns the version of the Vault Transit key the EncryptedKey
+// was encrypted with.
+func (key *MasterKey) KeyVersion() (int, error) {
+	m := keyVersionRegexp.FindStringSubmatch(key.EncryptedKey)
+	if m == nil {
+		return 0, fmt.Errorf("encrypted key is not a Vault transit ciphertext")
+	}
+	return strconv.Atoi(m[1])
+}
+
 // NeedsRotation returns whether the data key needs to be rotated or not.
+// Rotating the Transit key in Vault does not require rotating the data key,
+// see RewrapContext.
 func (key *MasterKey) NeedsRotation() bool {
-	// TODO: manage rewrapping https://www.vaultproject.io/api/secret/transit/index.html#rewrap-data
 	return time.Since(key.CreationDate) > (vaultTTL)
 }
 
 // ToString converts the key to a string representation.
 func (key *MasterKey) ToString() string {
-	return fmt.Sprintf("%s/v1/%s/keys/%s", key.VaultAddress, key.EnginePath, key.KeyName)
+	s := fmt.Sprintf("%s/v1/%s/keys/%s", key.VaultAddress, key.EnginePath, key.KeyName)
+	query := url.Values{}
+	if key.AuthMethod != "" {
+		query.Set("auth_method", key.AuthMethod)
+	}
+	if key.Role != "" {
+		query.Set("role", key.Role)
+	}
+	if key.Namespace != "" {
+		query.Set("namespace", key.Namespace)
+	}
+	if len(query) > 0 {
+		s += "?" + query.Encode()
+	}
+	return s
 }
 
 // ToMap converts the MasterKey to a map for serialization purposes.
@@ -253,6 +346,15 @@ func (key MasterKey) ToMap() map[string]interface{} {
 	out["engine_path"] = key.EnginePath
 	out["enc"] = key.EncryptedKey
 	out["created_at"] = key.CreationDate.UTC().Format(time.RFC3339)
+	if key.AuthMethod != "" {
+		out["auth_method"] = key.AuthMethod
+	}
+	if key.Role != "" {
+		out["role"] = key.Role
+	}
+	if key.Namespace != "" {
+		out["namespace"] = key.Namespace
+	}
 	return out
 }
 
@@ -271,6 +373,11 @@ func (key *MasterKey) decryptPath() string {
 	return path.Join(key.EnginePath, "decrypt", key.KeyName)
 }
 
+// rewrapPath returns the path for Rewrap requests.
+func (key *MasterKey) rewrapPath() string {
+	return path.Join(key.EnginePath, "rewrap", key.KeyName)
+}
+
 // encryptPayload returns the payload for an encrypt request of the dataKey.
 func encryptPayload(dataKey []byte) map[string]interface{} {
 	encoded := base64.StdEncoding.EncodeToString(dataKey)
@@ -325,6 +432,26 @@ func dataKeyFromSecret(secret *api.Secret) ([]byte, error) {
 	return dataKey, nil
 }
 
+// client returns a Vault client for the key, configured with its namespace
+// and authenticated using its auth method unless a token was provided.
+func (key *MasterKey) client(ctx context.Context) (*api.Client, error) {
+	client, err := vaultClient(key.VaultAddress, key.token, key.httpClient)
+	if err != nil {
+		return nil, err
+	}
+	if key.Namespace != "" {
+		client.SetNamespace(key.Namespace)
+	}
+	cfg := key.authConfig()
+	if key.token != "" || cfg.usesToken() {
+		return client, nil
+	}
+	if err := authenticate(ctx, client, cfg); err != nil {
+		return nil, fmt.Errorf("cannot get Vault token: %w", err)
+	}
+	return client, nil
+}
+
 // vaultClient returns a new Vault client, configured with the given address
 // and token.
 func vaultClient(address, token string, hc *http.Client) (*api.Client, error) {
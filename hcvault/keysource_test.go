@@ -273,6 +273,43 @@ func TestMasterKey_EncryptDecrypt_RoundTrip(t *testing.T) {
 	assert.Equal(t, dataKey, decryptedData)
 }
 
+func TestMasterKey_Rewrap(t *testing.T) {
+	f := newFakeVault(t)
+	f.tokens[testVaultToken] = true
+	key := f.key(t, "")
+	Token(testVaultToken).ApplyToMasterKey(key)
+	dataKey := []byte("data key")
+	assert.NoError(t, key.Encrypt(dataKey))
+	version, err := key.KeyVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, version)
+
+	f.keyVersion = 3
+	assert.NoError(t, key.Rewrap())
+	version, err = key.KeyVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, version)
+
+	got, err := key.Decrypt()
+	assert.NoError(t, err)
+	assert.Equal(t, dataKey, got)
+
+	key.EncryptedKey = "invalid"
+	f.tokens = map[string]bool{}
+	assert.ErrorContains(t, key.Rewrap(), "failed to rewrap sops data key")
+}
+
+func TestMasterKey_KeyVersion(t *testing.T) {
+	key := &MasterKey{EncryptedKey: "vault:v12:Zm9v"}
+	version, err := key.KeyVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, 12, version)
+
+	key.EncryptedKey = "Zm9v"
+	_, err = key.KeyVersion()
+	assert.ErrorContains(t, err, "not a Vault transit ciphertext")
+}
+
 func TestMasterKey_NeedsRotation(t *testing.T) {
 	key := NewMasterKey("", "", "")
 	assert.False(t, key.NeedsRotation())
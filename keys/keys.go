@@ -7,8 +7,18 @@ type MasterKey interface {
 	EncryptedDataKey() []byte
 	SetEncryptedDataKey([]byte)
 	Decrypt() ([]byte, error)
+	// NeedsRotation returns whether the data key is older than the default
+	// TTL of the key type. The TTLs configured in creation rules are applied
+	// by config.RotationTTL instead.
 	NeedsRotation() bool
 	ToString() string
 	ToMap() map[string]interface{}
 	TypeToIdentifier() string
 }
+
+// VersionedMasterKey is implemented by master keys recording the version of the
+// key the data key was encrypted with, so it can be set when the data key is
+// encrypted by a key service.
+type VersionedMasterKey interface {
+	SetKeyVersion(version string)
+}
@@ -0,0 +1,324 @@
+package keyservice
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/shlex"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// DefaultApprovalTimeout is the time an Approver waits for a decision
+	// before denying a request, when no explicit timeout is configured.
+	DefaultApprovalTimeout = 60 * time.Second
+
+	// ApprovalRequestIDEnv is set in the environment of a CommandApprover
+	// command to the ID of the request awaiting approval.
+	ApprovalRequestIDEnv = "SOPS_KEYSERVICE_REQUEST_ID"
+	// ApprovalRequestTypeEnv is set in the environment of a CommandApprover
+	// command to the type of the request ("encrypt", "decrypt" or "rewrap").
+	ApprovalRequestTypeEnv = "SOPS_KEYSERVICE_REQUEST_TYPE"
+	// ApprovalRequestKeyEnv is set in the environment of a CommandApprover
+	// command to a human-readable description of the requested key.
+	ApprovalRequestKeyEnv = "SOPS_KEYSERVICE_REQUEST_KEY"
+)
+
+// ApprovalRequest describes a key service request that awaits approval.
+type ApprovalRequest struct {
+	// ID uniquely identifies the request for the lifetime of the server.
+	ID string `json:"id"`
+	// Type is the kind of request, one of "encrypt", "decrypt" or "rewrap".
+	Type string `json:"type"`
+	// Key is a human-readable description of the key used by the request.
+	Key string `json:"key"`
+	// Created is the time at which the request was received.
+	Created time.Time `json:"created"`
+}
+
+// newApprovalRequest creates an ApprovalRequest with a random ID for the
+// given key and request type.
+func newApprovalRequest(key *Key, requestType string) ApprovalRequest {
+	return ApprovalRequest{
+		ID:      rand.Text(),
+		Type:    requestType,
+		Key:     keyToString(key),
+		Created: time.Now(),
+	}
+}
+
+// Approver decides whether a key service request may be fulfilled.
+type Approver interface {
+	// Approve blocks until a decision has been made about the request. It
+	// returns nil if the request was approved, and an error otherwise.
+	// Implementations must deny the request when ctx is done.
+	Approve(ctx context.Context, req ApprovalRequest) error
+}
+
+// errRejected is returned when a request is explicitly rejected.
+func errRejected() error {
+	return status.Errorf(codes.PermissionDenied, "Request rejected by user")
+}
+
+// errNotApproved is returned when no decision was made in time.
+func errNotApproved(ctx context.Context) error {
+	return status.Errorf(codes.PermissionDenied, "Request not approved: %s", ctx.Err())
+}
+
+// withApprovalTimeout derives a context from ctx which is cancelled after
+// timeout, or DefaultApprovalTimeout if timeout is not positive.
+func withApprovalTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = DefaultApprovalTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// TTYApprover prompts for approval on a terminal. Prompts are serialized, so
+// concurrent requests are queued and presented one at a time.
+type TTYApprover struct {
+	// Timeout is the time a request may wait for an answer, including time
+	// spent queued behind other requests. Defaults to DefaultApprovalTimeout.
+	Timeout time.Duration
+
+	out   io.Writer
+	in    *bufio.Scanner
+	lines chan string
+	once  sync.Once
+	// queue holds a single token; a prompt may only be shown while holding it.
+	queue chan struct{}
+}
+
+// NewTTYApprover creates a new TTYApprover reading answers from in and
+// writing prompts to out.
+func NewTTYApprover(in io.Reader, out io.Writer, timeout time.Duration) *TTYApprover {
+	a := &TTYApprover{
+		Timeout: timeout,
+		out:     out,
+		in:      bufio.NewScanner(in),
+		lines:   make(chan string),
+		queue:   make(chan struct{}, 1),
+	}
+	a.queue <- struct{}{}
+	return a
+}
+
+// readLines feeds lines read from the input into the lines channel. It runs
+// for the lifetime of the approver, as reads cannot be interrupted.
+func (a *TTYApprover) readLines() {
+	for a.in.Scan() {
+		a.lines <- strings.TrimSpace(a.in.Text())
+	}
+	close(a.lines)
+}
+
+// Approve prompts the user to approve the request and waits for a "y" or "n"
+// answer.
+func (a *TTYApprover) Approve(ctx context.Context, req ApprovalRequest) error {
+	ctx, cancel := withApprovalTimeout(ctx, a.Timeout)
+	defer cancel()
+	a.once.Do(func() { go a.readLines() })
+
+	select {
+	case <-a.queue:
+		defer func() { a.queue <- struct{}{} }()
+	case <-ctx.Done():
+		return errNotApproved(ctx)
+	}
+
+	// Discard answers typed for a previous prompt that timed out.
+	select {
+	case <-a.lines:
+	default:
+	}
+
+	for {
+		fmt.Fprintf(a.out, "\nReceived %s request using %s. Respond to request? (y/n): ", req.Type, req.Key)
+		select {
+		case response, ok := <-a.lines:
+			if !ok {
+				return status.Errorf(codes.PermissionDenied, "Request not approved: no more input")
+			}
+			switch response {
+			case "y":
+				return nil
+			case "n":
+				return errRejected()
+			}
+		case <-ctx.Done():
+			fmt.Fprintf(a.out, "\nNo answer received, denying %s request.\n", req.Type)
+			return errNotApproved(ctx)
+		}
+	}
+}
+
+// CommandApprover runs a command, e.g. a desktop notification helper, for
+// every request. The request is approved if the command exits successfully.
+// Details about the request are passed through the ApprovalRequest*Env
+// environment variables.
+type CommandApprover struct {
+	// Command is the command to run. It is split into arguments using shell
+	// quoting rules, but not run through a shell.
+	Command string
+	// Timeout is the time the command may run before it is killed and the
+	// request is denied. Defaults to DefaultApprovalTimeout.
+	Timeout time.Duration
+}
+
+// Approve runs the command and approves the request if it exits with status 0.
+func (a CommandApprover) Approve(ctx context.Context, req ApprovalRequest) error {
+	args, err := shlex.Split(a.Command)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to parse approval command %q: %s", a.Command, err)
+	}
+	if len(args) == 0 {
+		return status.Errorf(codes.Internal, "approval command is empty")
+	}
+	ctx, cancel := withApprovalTimeout(ctx, a.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(),
+		ApprovalRequestIDEnv+"="+req.ID,
+		ApprovalRequestTypeEnv+"="+req.Type,
+		ApprovalRequestKeyEnv+"="+req.Key,
+	)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return errNotApproved(ctx)
+		}
+		if _, ok := err.(*exec.ExitError); ok {
+			return errRejected()
+		}
+		return status.Errorf(codes.Internal, "failed to run approval command: %s", err)
+	}
+	return nil
+}
+
+// pendingApproval is a request waiting for a decision through the
+// HTTPApprover.
+type pendingApproval struct {
+	ApprovalRequest
+	decision chan bool
+}
+
+// HTTPApprover holds requests until they are approved or denied through its
+// HTTP endpoint. It serves the following routes, which require the token given
+// to NewHTTPApprover in an "Authorization: Bearer" header:
+//
+//	GET  /requests              lists the pending requests as JSON
+//	POST /requests/{id}/approve approves the request with the given ID
+//	POST /requests/{id}/deny    denies the request with the given ID
+type HTTPApprover struct {
+	// Timeout is the time a request may stay pending before it is denied.
+	// Defaults to DefaultApprovalTimeout.
+	Timeout time.Duration
+
+	mu      sync.Mutex
+	pending map[string]*pendingApproval
+	handler http.Handler
+}
+
+// NewHTTPApprover creates a new HTTPApprover, whose endpoint only accepts
+// requests carrying the token. An empty token rejects every request.
+func NewHTTPApprover(timeout time.Duration, token string) *HTTPApprover {
+	a := &HTTPApprover{
+		Timeout: timeout,
+		pending: make(map[string]*pendingApproval),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /requests", a.handleList)
+	mux.HandleFunc("POST /requests/{id}/approve", a.handleDecision(true))
+	mux.HandleFunc("POST /requests/{id}/deny", a.handleDecision(false))
+	a.handler = requireBearerToken(token, mux)
+	return a
+}
+
+// Approve registers the request as pending and waits for a decision.
+func (a *HTTPApprover) Approve(ctx context.Context, req ApprovalRequest) error {
+	ctx, cancel := withApprovalTimeout(ctx, a.Timeout)
+	defer cancel()
+
+	p := &pendingApproval{ApprovalRequest: req, decision: make(chan bool, 1)}
+	a.mu.Lock()
+	a.pending[req.ID] = p
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		delete(a.pending, req.ID)
+		a.mu.Unlock()
+	}()
+
+	select {
+	case approved := <-p.decision:
+		if !approved {
+			return errRejected()
+		}
+		return nil
+	case <-ctx.Done():
+		return errNotApproved(ctx)
+	}
+}
+
+// Pending returns the requests currently awaiting a decision, oldest first.
+func (a *HTTPApprover) Pending() []ApprovalRequest {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	reqs := make([]ApprovalRequest, 0, len(a.pending))
+	for _, p := range a.pending {
+		reqs = append(reqs, p.ApprovalRequest)
+	}
+	sort.Slice(reqs, func(i, j int) bool {
+		return reqs[i].Created.Before(reqs[j].Created)
+	})
+	return reqs
+}
+
+// Decide records a decision for the pending request with the given ID. It
+// returns an error if no such request is pending.
+func (a *HTTPApprover) Decide(id string, approve bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	p, ok := a.pending[id]
+	if !ok {
+		return fmt.Errorf("no pending request with ID %q", id)
+	}
+	delete(a.pending, id)
+	p.decision <- approve
+	return nil
+}
+
+// ServeHTTP implements http.Handler.
+func (a *HTTPApprover) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.handler.ServeHTTP(w, r)
+}
+
+func (a *HTTPApprover) handleList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(a.Pending()); err != nil {
+		log.WithError(err).Warn("Failed to write pending requests")
+	}
+}
+
+func (a *HTTPApprover) handleDecision(approve bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := a.Decide(r.PathValue("id"), approve); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,274 @@
+package keyservice
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const mockAgeRecipient = "age1lzd99uklcjnc0e7d860axevet2cz99ce9pq6tzuzd05l5nr28ams36nvun"
+
+func mockApprovalRequest(id string) ApprovalRequest {
+	return ApprovalRequest{
+		ID:      id,
+		Type:    "decrypt",
+		Key:     "PGP key with fingerprint FBC7B9E2A4F9289AC0C1D4843D16CEE4A27381B4",
+		Created: time.Now(),
+	}
+}
+
+func assertDenied(t *testing.T, err error) {
+	t.Helper()
+	require.Error(t, err)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestTTYApprover(t *testing.T) {
+	t.Run("approve", func(t *testing.T) {
+		var out strings.Builder
+		a := NewTTYApprover(strings.NewReader("y\n"), &out, time.Second)
+		assert.NoError(t, a.Approve(context.Background(), mockApprovalRequest("1")))
+		assert.Contains(t, out.String(), "Received decrypt request using PGP key")
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		a := NewTTYApprover(strings.NewReader("n\n"), io.Discard, time.Second)
+		assertDenied(t, a.Approve(context.Background(), mockApprovalRequest("1")))
+	})
+
+	t.Run("asks again on invalid answer", func(t *testing.T) {
+		var out strings.Builder
+		a := NewTTYApprover(strings.NewReader("maybe\ny\n"), &out, time.Second)
+		assert.NoError(t, a.Approve(context.Background(), mockApprovalRequest("1")))
+		assert.Equal(t, 2, strings.Count(out.String(), "Respond to request?"))
+	})
+
+	t.Run("denies on end of input", func(t *testing.T) {
+		a := NewTTYApprover(strings.NewReader(""), io.Discard, time.Second)
+		assertDenied(t, a.Approve(context.Background(), mockApprovalRequest("1")))
+	})
+
+	t.Run("denies on timeout", func(t *testing.T) {
+		r, w := io.Pipe()
+		defer w.Close()
+		a := NewTTYApprover(r, io.Discard, 10*time.Millisecond)
+		assertDenied(t, a.Approve(context.Background(), mockApprovalRequest("1")))
+	})
+
+	t.Run("serializes prompts", func(t *testing.T) {
+		r, w := io.Pipe()
+		defer w.Close()
+		a := NewTTYApprover(r, io.Discard, time.Second)
+
+		errc := make(chan error)
+		for i := 0; i < 2; i++ {
+			go func() { errc <- a.Approve(context.Background(), mockApprovalRequest("1")) }()
+		}
+		// Each answer is consumed by exactly one prompt.
+		_, err := io.WriteString(w, "y\n")
+		require.NoError(t, err)
+		assert.NoError(t, <-errc)
+		_, err = io.WriteString(w, "n\n")
+		require.NoError(t, err)
+		assertDenied(t, <-errc)
+	})
+}
+
+func TestCommandApprover(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test relies on a POSIX shell")
+	}
+
+	t.Run("approve", func(t *testing.T) {
+		a := CommandApprover{Command: "true"}
+		assert.NoError(t, a.Approve(context.Background(), mockApprovalRequest("1")))
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		a := CommandApprover{Command: "false"}
+		assertDenied(t, a.Approve(context.Background(), mockApprovalRequest("1")))
+	})
+
+	t.Run("passes request details", func(t *testing.T) {
+		a := CommandApprover{Command: `sh -c 'test "$SOPS_KEYSERVICE_REQUEST_ID" = abc && test "$SOPS_KEYSERVICE_REQUEST_TYPE" = decrypt'`}
+		assert.NoError(t, a.Approve(context.Background(), mockApprovalRequest("abc")))
+	})
+
+	t.Run("denies on timeout", func(t *testing.T) {
+		a := CommandApprover{Command: "sleep 5", Timeout: 10 * time.Millisecond}
+		assertDenied(t, a.Approve(context.Background(), mockApprovalRequest("1")))
+	})
+
+	t.Run("invalid command", func(t *testing.T) {
+		a := CommandApprover{Command: ""}
+		err := a.Approve(context.Background(), mockApprovalRequest("1"))
+		assert.Equal(t, codes.Internal, status.Code(err))
+	})
+}
+
+func TestHTTPApprover(t *testing.T) {
+	const token = "token"
+	waitForPending := func(t *testing.T, a *HTTPApprover, n int) {
+		t.Helper()
+		require.Eventually(t, func() bool {
+			return len(a.Pending()) == n
+		}, time.Second, time.Millisecond)
+	}
+	do := func(t *testing.T, method, url, token string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, url, nil)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	t.Run("approve", func(t *testing.T) {
+		a := NewHTTPApprover(time.Second, token)
+		srv := httptest.NewServer(a)
+		defer srv.Close()
+
+		errc := make(chan error)
+		go func() { errc <- a.Approve(context.Background(), mockApprovalRequest("abc")) }()
+		waitForPending(t, a, 1)
+
+		resp := do(t, http.MethodGet, srv.URL+"/requests", token)
+		var pending []ApprovalRequest
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&pending))
+		resp.Body.Close()
+		require.Len(t, pending, 1)
+		assert.Equal(t, "abc", pending[0].ID)
+		assert.Equal(t, "decrypt", pending[0].Type)
+
+		resp = do(t, http.MethodPost, srv.URL+"/requests/abc/approve", token)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+		assert.NoError(t, <-errc)
+		assert.Empty(t, a.Pending())
+	})
+
+	t.Run("deny", func(t *testing.T) {
+		a := NewHTTPApprover(time.Second, token)
+		srv := httptest.NewServer(a)
+		defer srv.Close()
+
+		errc := make(chan error)
+		go func() { errc <- a.Approve(context.Background(), mockApprovalRequest("abc")) }()
+		waitForPending(t, a, 1)
+
+		resp := do(t, http.MethodPost, srv.URL+"/requests/abc/deny", token)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+		assertDenied(t, <-errc)
+	})
+
+	t.Run("unknown request", func(t *testing.T) {
+		srv := httptest.NewServer(NewHTTPApprover(time.Second, token))
+		defer srv.Close()
+
+		resp := do(t, http.MethodPost, srv.URL+"/requests/abc/approve", token)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("requires the token", func(t *testing.T) {
+		a := NewHTTPApprover(time.Second, token)
+		srv := httptest.NewServer(a)
+		defer srv.Close()
+
+		errc := make(chan error)
+		go func() { errc <- a.Approve(context.Background(), mockApprovalRequest("abc")) }()
+		waitForPending(t, a, 1)
+
+		for _, token := range []string{"", "wrong"} {
+			resp := do(t, http.MethodGet, srv.URL+"/requests", token)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+			resp = do(t, http.MethodPost, srv.URL+"/requests/abc/approve", token)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		}
+		// A cross-site form post cannot set the header either
+		resp, err := http.PostForm(srv.URL+"/requests/abc/approve", nil)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.Len(t, a.Pending(), 1)
+
+		resp = do(t, http.MethodPost, srv.URL+"/requests/abc/deny", token)
+		resp.Body.Close()
+		assertDenied(t, <-errc)
+	})
+
+	t.Run("empty token rejects every request", func(t *testing.T) {
+		srv := httptest.NewServer(NewHTTPApprover(time.Second, ""))
+		defer srv.Close()
+
+		resp := do(t, http.MethodGet, srv.URL+"/requests", "")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("denies on timeout", func(t *testing.T) {
+		a := NewHTTPApprover(10*time.Millisecond, token)
+		assertDenied(t, a.Approve(context.Background(), mockApprovalRequest("abc")))
+		assert.Empty(t, a.Pending())
+	})
+}
+
+type mockApprover struct {
+	err  error
+	reqs []ApprovalRequest
+}
+
+func (a *mockApprover) Approve(ctx context.Context, req ApprovalRequest) error {
+	a.reqs = append(a.reqs, req)
+	return a.err
+}
+
+func TestServerApprover(t *testing.T) {
+	key := &Key{KeyType: &Key_AgeKey{AgeKey: &AgeKey{Recipient: mockAgeRecipient}}}
+
+	t.Run("approved", func(t *testing.T) {
+		approver := &mockApprover{}
+		ks := Server{Approver: approver}
+		resp, err := ks.Encrypt(context.Background(), &EncryptRequest{Key: key, Plaintext: []byte("data key")})
+		require.NoError(t, err)
+		assert.NotEmpty(t, resp.Ciphertext)
+		require.Len(t, approver.reqs, 1)
+		assert.Equal(t, "encrypt", approver.reqs[0].Type)
+		assert.NotEmpty(t, approver.reqs[0].ID)
+	})
+
+	t.Run("denied", func(t *testing.T) {
+		approver := &mockApprover{err: errRejected()}
+		ks := Server{Approver: approver}
+		_, err := ks.Encrypt(context.Background(), &EncryptRequest{Key: key, Plaintext: []byte("data key")})
+		assertDenied(t, err)
+		_, err = ks.Decrypt(context.Background(), &DecryptRequest{Key: key, Ciphertext: []byte("ciphertext")})
+		assertDenied(t, err)
+		assert.Len(t, approver.reqs, 2)
+	})
+
+	t.Run("missing key is not submitted for approval", func(t *testing.T) {
+		approver := &mockApprover{}
+		ks := Server{Approver: approver}
+		_, err := ks.Decrypt(context.Background(), &DecryptRequest{Key: &Key{}})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+		assert.Empty(t, approver.reqs)
+	})
+}
@@ -0,0 +1,24 @@
+package keyservice
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// requireBearerToken returns a handler which passes requests on to next only
+// if they carry the token in an "Authorization: Bearer" header, and rejects
+// them otherwise. Browsers cannot set the header on cross-site form posts, so
+// this also protects the endpoints from cross-site request forgery. An empty
+// token rejects every request.
+func requireBearerToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "missing or invalid bearer token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -1,9 +1,14 @@
 package keyservice
 
 import (
+	"fmt"
+	"net"
+	"net/url"
+
 	"golang.org/x/net/context"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
 // LocalClient is a key service client that performs all operations locally
@@ -35,3 +40,41 @@ func (c LocalClient) Encrypt(ctx context.Context,
 	req *EncryptRequest, opts ...grpc.CallOption) (*EncryptResponse, error) {
 	return c.Server.Encrypt(ctx, req)
 }
+
+// Rewrap processes a rewrap request locally
+// See keyservice/server.go for more details
+func (c LocalClient) Rewrap(ctx context.Context,
+	req *RewrapRequest, opts ...grpc.CallOption) (*RewrapResponse, error) {
+	return c.Server.Rewrap(ctx, req)
+}
+
+// Dial creates a client for the key service listening at the given URI. The
+// URI has the form protocol://address, e.g. tcp://myserver.com:5000 or
+// unix:///tmp/sops.sock.
+func Dial(uri string) (KeyServiceClient, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing URI for keyservice %q: %w", uri, err)
+	}
+	addr := u.Host
+	if u.Scheme == "unix" {
+		addr = u.Path
+	}
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(
+			func(ctx context.Context, addr string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, u.Scheme, addr)
+			},
+		),
+	}
+	log.WithField(
+		"address",
+		fmt.Sprintf("%s://%s", u.Scheme, addr),
+	).Infof("Connecting to key service")
+	conn, err := grpc.NewClient(addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeyServiceClient(conn), nil
+}
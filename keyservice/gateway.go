@@ -0,0 +1,190 @@
+package keyservice
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/AetherVoxSanctum/envv-cli/v3/audit"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// GatewayEncryptPath is the path the Gateway serves encrypt requests on
+	GatewayEncryptPath = "/v1/encrypt"
+	// GatewayDecryptPath is the path the Gateway serves decrypt requests on
+	GatewayDecryptPath = "/v1/decrypt"
+
+	// maxGatewayRequestSize limits the size of request bodies accepted by the
+	// Gateway. Requests only contain a key reference and a data key.
+	maxGatewayRequestSize = 1 << 20
+)
+
+// submitAuditEvent records a request received by a key service server with
+// the configured auditors
+func submitAuditEvent(transport string, action string, key *Key, err error) {
+	event := audit.KeyServiceEvent{
+		Action:    action,
+		Key:       keyToString(key),
+		Transport: transport,
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	audit.SubmitEvent(event)
+}
+
+// AuditInterceptor is a grpc.UnaryServerInterceptor which records every
+// request received by a gRPC key service server with the configured auditors
+func AuditInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	resp, err := handler(ctx, req)
+	switch r := req.(type) {
+	case *EncryptRequest:
+		submitAuditEvent("grpc", "encrypt", r.Key, err)
+	case *DecryptRequest:
+		submitAuditEvent("grpc", "decrypt", r.Key, err)
+	case *RewrapRequest:
+		submitAuditEvent("grpc", "rewrap", r.Key, err)
+	}
+	return resp, err
+}
+
+// Gateway exposes a KeyServiceServer over HTTP with JSON encoded requests, for
+// clients which cannot speak gRPC. Requests and responses are the Protocol
+// Buffers messages of the gRPC API in their canonical JSON encoding, e.g.
+//
+//	POST /v1/decrypt
+//	{"key": {"ageKey": {"recipient": "age1..."}}, "ciphertext": "<base64>"}
+//
+// responds with
+//
+//	{"plaintext": "<base64>"}
+//
+// Errors are returned with a matching HTTP status code and a JSON body of the
+// form {"code": "PermissionDenied", "message": "..."}.
+//
+// Requests must carry the token given to NewGateway in an
+// "Authorization: Bearer" header.
+type Gateway struct {
+	Server  KeyServiceServer
+	handler http.Handler
+}
+
+// NewGateway creates a new Gateway forwarding requests to server, which only
+// accepts requests carrying the token. An empty token rejects every request.
+func NewGateway(server KeyServiceServer, token string) *Gateway {
+	g := &Gateway{Server: server}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+GatewayEncryptPath, g.handleEncrypt)
+	mux.HandleFunc("POST "+GatewayDecryptPath, g.handleDecrypt)
+	g.handler = requireBearerToken(token, mux)
+	return g
+}
+
+// ServeHTTP implements http.Handler
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.handler.ServeHTTP(w, r)
+}
+
+func (g *Gateway) handleEncrypt(w http.ResponseWriter, r *http.Request) {
+	req := &EncryptRequest{}
+	if err := readGatewayRequest(r, req); err != nil {
+		writeGatewayError(w, err)
+		return
+	}
+	resp, err := g.Server.Encrypt(r.Context(), req)
+	submitAuditEvent("http", "encrypt", req.Key, err)
+	if err != nil {
+		writeGatewayError(w, err)
+		return
+	}
+	writeGatewayResponse(w, resp)
+}
+
+func (g *Gateway) handleDecrypt(w http.ResponseWriter, r *http.Request) {
+	req := &DecryptRequest{}
+	if err := readGatewayRequest(r, req); err != nil {
+		writeGatewayError(w, err)
+		return
+	}
+	resp, err := g.Server.Decrypt(r.Context(), req)
+	submitAuditEvent("http", "decrypt", req.Key, err)
+	if err != nil {
+		writeGatewayError(w, err)
+		return
+	}
+	writeGatewayResponse(w, resp)
+}
+
+// readGatewayRequest decodes the JSON encoded request body into msg
+func readGatewayRequest(r *http.Request, msg proto.Message) error {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxGatewayRequestSize+1))
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "Failed to read request: %s", err)
+	}
+	if len(body) > maxGatewayRequestSize {
+		return status.Errorf(codes.InvalidArgument, "Request too large")
+	}
+	if err := protojson.Unmarshal(body, msg); err != nil {
+		return status.Errorf(codes.InvalidArgument, "Invalid request: %s", err)
+	}
+	return nil
+}
+
+func writeGatewayResponse(w http.ResponseWriter, msg proto.Message) {
+	body, err := protojson.Marshal(msg)
+	if err != nil {
+		writeGatewayError(w, status.Errorf(codes.Internal, "Failed to encode response: %s", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+func writeGatewayError(w http.ResponseWriter, err error) {
+	s := status.Convert(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatusFromCode(s.Code()))
+	json.NewEncoder(w).Encode(struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}{
+		Code:    s.Code().String(),
+		Message: s.Message(),
+	})
+}
+
+// httpStatusFromCode maps gRPC status codes to HTTP status codes
+func httpStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Canceled:
+		return 499 // Client Closed Request
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,147 @@
+package keyservice
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/AetherVoxSanctum/envv-cli/v3/age"
+	"github.com/AetherVoxSanctum/envv-cli/v3/audit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// mockAuditor records the key service events it receives.
+type mockAuditor struct {
+	events []audit.KeyServiceEvent
+}
+
+func (a *mockAuditor) Handle(event interface{}) {
+	if e, ok := event.(audit.KeyServiceEvent); ok {
+		a.events = append(a.events, e)
+	}
+}
+
+var testAuditor = &mockAuditor{}
+
+func init() {
+	audit.Register(testAuditor)
+}
+
+const mockGatewayToken = "token"
+
+func postJSON(t *testing.T, url string, body string) (int, map[string]string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+mockGatewayToken)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var out map[string]string
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&out))
+	return resp.StatusCode, out
+}
+
+func TestGateway(t *testing.T) {
+	const mockAgeIdentity = "AGE-SECRET-KEY-1G0Q5K9TV4REQ3ZSQRMTMG8NSWQGYT0T7TZ33RAZEE0GZYVZN0APSU24RK7"
+	var identities age.ParsedIdentities
+	require.NoError(t, identities.Import(mockAgeIdentity))
+	srv := httptest.NewServer(NewGateway(Server{AgeIdentities: identities}, mockGatewayToken))
+	defer srv.Close()
+	key := `{"ageKey": {"recipient": "` + mockAgeRecipient + `"}}`
+
+	t.Run("round trip", func(t *testing.T) {
+		testAuditor.events = nil
+		plaintext := base64.StdEncoding.EncodeToString([]byte("data key"))
+		code, out := postJSON(t, srv.URL+GatewayEncryptPath, `{"key": `+key+`, "plaintext": "`+plaintext+`"}`)
+		require.Equal(t, http.StatusOK, code, out)
+		require.NotEmpty(t, out["ciphertext"])
+
+		code, out = postJSON(t, srv.URL+GatewayDecryptPath, `{"key": `+key+`, "ciphertext": "`+out["ciphertext"]+`"}`)
+		require.Equal(t, http.StatusOK, code, out)
+		assert.Equal(t, plaintext, out["plaintext"])
+
+		require.Len(t, testAuditor.events, 2)
+		assert.Equal(t, "encrypt", testAuditor.events[0].Action)
+		assert.Equal(t, "decrypt", testAuditor.events[1].Action)
+		assert.Equal(t, "http", testAuditor.events[1].Transport)
+		assert.Equal(t, "age key with recipient "+mockAgeRecipient, testAuditor.events[1].Key)
+		assert.Empty(t, testAuditor.events[1].Error)
+	})
+
+	t.Run("proto field names", func(t *testing.T) {
+		code, out := postJSON(t, srv.URL+GatewayEncryptPath, `{"key": {"age_key": {"recipient": "`+mockAgeRecipient+`"}}, "plaintext": "ZGF0YQ=="}`)
+		require.Equal(t, http.StatusOK, code, out)
+	})
+
+	t.Run("invalid request", func(t *testing.T) {
+		code, out := postJSON(t, srv.URL+GatewayDecryptPath, `{"key": `)
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.Equal(t, codes.InvalidArgument.String(), out["code"])
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		code, out := postJSON(t, srv.URL+GatewayDecryptPath, `{}`)
+		assert.Equal(t, http.StatusNotFound, code)
+		assert.Equal(t, "Must provide a key", out["message"])
+	})
+
+	t.Run("method not allowed", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+GatewayDecryptPath, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+mockGatewayToken)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	})
+
+	t.Run("requires the token", func(t *testing.T) {
+		testAuditor.events = nil
+		for _, header := range []string{"", "Bearer wrong", "Basic " + mockGatewayToken} {
+			req, err := http.NewRequest(http.MethodPost, srv.URL+GatewayDecryptPath, strings.NewReader(`{"key": `+key+`, "ciphertext": "ZGF0YQ=="}`))
+			require.NoError(t, err)
+			if header != "" {
+				req.Header.Set("Authorization", header)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		}
+		assert.Empty(t, testAuditor.events)
+	})
+}
+
+func TestGatewayApprover(t *testing.T) {
+	srv := httptest.NewServer(NewGateway(Server{Approver: &mockApprover{err: errRejected()}}, mockGatewayToken))
+	defer srv.Close()
+
+	testAuditor.events = nil
+	code, out := postJSON(t, srv.URL+GatewayDecryptPath, `{"key": {"ageKey": {"recipient": "`+mockAgeRecipient+`"}}, "ciphertext": "ZGF0YQ=="}`)
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, codes.PermissionDenied.String(), out["code"])
+	require.Len(t, testAuditor.events, 1)
+	assert.NotEmpty(t, testAuditor.events[0].Error)
+}
+
+func TestAuditInterceptor(t *testing.T) {
+	testAuditor.events = nil
+	req := &DecryptRequest{Key: &Key{KeyType: &Key_AgeKey{AgeKey: &AgeKey{Recipient: mockAgeRecipient}}}}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &DecryptResponse{}, nil
+	}
+	_, err := AuditInterceptor(context.Background(), req, &grpc.UnaryServerInfo{FullMethod: KeyService_Decrypt_FullMethodName}, handler)
+	require.NoError(t, err)
+	require.Len(t, testAuditor.events, 1)
+	assert.Equal(t, "decrypt", testAuditor.events[0].Action)
+	assert.Equal(t, "grpc", testAuditor.events[0].Transport)
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/AetherVoxSanctum/envv-cli/v3/keys"
 	"github.com/AetherVoxSanctum/envv-cli/v3/kms"
 	"github.com/AetherVoxSanctum/envv-cli/v3/pgp"
+	"github.com/AetherVoxSanctum/envv-cli/v3/pkcs11"
 )
 
 // KeyFromMasterKey converts a SOPS internal MasterKey to an RPC Key that can be serialized with Protocol Buffers
@@ -42,6 +43,9 @@ func KeyFromMasterKey(mk keys.MasterKey) Key {
 					VaultAddress: mk.VaultAddress,
 					EnginePath:   mk.EnginePath,
 					KeyName:      mk.KeyName,
+					AuthMethod:   mk.AuthMethod,
+					Role:         mk.Role,
+					Namespace:    mk.Namespace,
 				},
 			},
 		}
@@ -53,10 +57,11 @@ func KeyFromMasterKey(mk keys.MasterKey) Key {
 		return Key{
 			KeyType: &Key_KmsKey{
 				KmsKey: &KmsKey{
-					Arn:        mk.Arn,
-					Role:       mk.Role,
-					Context:    ctx,
-					AwsProfile: mk.AwsProfile,
+					Arn:            mk.Arn,
+					Role:           mk.Role,
+					Context:        ctx,
+					AwsProfile:     mk.AwsProfile,
+					ReplicaRegions: mk.ReplicaRegions,
 				},
 			},
 		}
@@ -78,6 +83,14 @@ func KeyFromMasterKey(mk keys.MasterKey) Key {
 				},
 			},
 		}
+	case *pkcs11.MasterKey:
+		return Key{
+			KeyType: &Key_Pkcs11Key{
+				Pkcs11Key: &Pkcs11Key{
+					Uri: mk.URI,
+				},
+			},
+		}
 	default:
 		panic(fmt.Sprintf("Tried to convert unknown MasterKey type %T to keyservice.Key", mk))
 	}
@@ -21,6 +21,7 @@ const _ = grpc.SupportPackageIsVersion9
 const (
 	KeyService_Encrypt_FullMethodName = "/KeyService/Encrypt"
 	KeyService_Decrypt_FullMethodName = "/KeyService/Decrypt"
+	KeyService_Rewrap_FullMethodName  = "/KeyService/Rewrap"
 )
 
 // KeyServiceClient is the client API for KeyService service.
@@ -29,6 +30,7 @@ const (
 type KeyServiceClient interface {
 	Encrypt(ctx context.Context, in *EncryptRequest, opts ...grpc.CallOption) (*EncryptResponse, error)
 	Decrypt(ctx context.Context, in *DecryptRequest, opts ...grpc.CallOption) (*DecryptResponse, error)
+	Rewrap(ctx context.Context, in *RewrapRequest, opts ...grpc.CallOption) (*RewrapResponse, error)
 }
 
 type keyServiceClient struct {
@@ -59,12 +61,23 @@ func (c *keyServiceClient) Decrypt(ctx context.Context, in *DecryptRequest, opts
 	return out, nil
 }
 
+func (c *keyServiceClient) Rewrap(ctx context.Context, in *RewrapRequest, opts ...grpc.CallOption) (*RewrapResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RewrapResponse)
+	err := c.cc.Invoke(ctx, KeyService_Rewrap_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // KeyServiceServer is the server API for KeyService service.
 // All implementations should embed UnimplementedKeyServiceServer
 // for forward compatibility.
 type KeyServiceServer interface {
 	Encrypt(context.Context, *EncryptRequest) (*EncryptResponse, error)
 	Decrypt(context.Context, *DecryptRequest) (*DecryptResponse, error)
+	Rewrap(context.Context, *RewrapRequest) (*RewrapResponse, error)
 }
 
 // UnimplementedKeyServiceServer should be embedded to have
@@ -80,6 +93,9 @@ func (UnimplementedKeyServiceServer) Encrypt(context.Context, *EncryptRequest) (
 func (UnimplementedKeyServiceServer) Decrypt(context.Context, *DecryptRequest) (*DecryptResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Decrypt not implemented")
 }
+func (UnimplementedKeyServiceServer) Rewrap(context.Context, *RewrapRequest) (*RewrapResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Rewrap not implemented")
+}
 func (UnimplementedKeyServiceServer) testEmbeddedByValue() {}
 
 // UnsafeKeyServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -136,6 +152,24 @@ func _KeyService_Decrypt_Handler(srv interface{}, ctx context.Context, dec func(
 	return interceptor(ctx, in, info, handler)
 }
 
+func _KeyService_Rewrap_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RewrapRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyServiceServer).Rewrap(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KeyService_Rewrap_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyServiceServer).Rewrap(ctx, req.(*RewrapRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // KeyService_ServiceDesc is the grpc.ServiceDesc for KeyService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -151,6 +185,10 @@ var KeyService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Decrypt",
 			Handler:    _KeyService_Decrypt_Handler,
 		},
+		{
+			MethodName: "Rewrap",
+			Handler:    _KeyService_Rewrap_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "keyservice/keyservice.proto",
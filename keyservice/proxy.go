@@ -0,0 +1,218 @@
+package keyservice
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/AetherVoxSanctum/envv-cli/v3/age"
+	"github.com/AetherVoxSanctum/envv-cli/v3/azkv"
+	"github.com/AetherVoxSanctum/envv-cli/v3/gcpkms"
+	"github.com/AetherVoxSanctum/envv-cli/v3/hcvault"
+	"github.com/AetherVoxSanctum/envv-cli/v3/kms"
+	"github.com/AetherVoxSanctum/envv-cli/v3/pgp"
+	"github.com/AetherVoxSanctum/envv-cli/v3/pkcs11"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// LocalUpstream is the URI used to refer to the in-process key service when
+// configuring upstreams.
+const LocalUpstream = "local"
+
+// Upstream is a key service a ProxyServer forwards requests to.
+type Upstream struct {
+	// Name identifies the upstream in logs and errors, usually its URI.
+	Name string
+	// KeyTypes are the key type identifiers (e.g. "kms" or "age") of the
+	// requests forwarded to this upstream. If empty, requests for all key
+	// types are forwarded.
+	KeyTypes []string
+	// Client is used to send requests to the upstream.
+	Client KeyServiceClient
+}
+
+// ParseUpstream parses an upstream definition of the form
+// [type[,type...]=]uri, e.g. "kms,gcp_kms=tcp://bastion:5000" or "age=local".
+// The URI "local" refers to the in-process key service.
+func ParseUpstream(definition string) (Upstream, error) {
+	uri := definition
+	var keyTypes []string
+	if i := strings.Index(definition, "="); i >= 0 && !strings.Contains(definition[:i], "://") {
+		uri = definition[i+1:]
+		for _, t := range strings.Split(definition[:i], ",") {
+			t = strings.TrimSpace(t)
+			if !slices.Contains(keyTypeIdentifiers, t) {
+				return Upstream{}, fmt.Errorf("unknown key type %q in upstream %q, must be one of %s", t, definition, strings.Join(keyTypeIdentifiers, ", "))
+			}
+			keyTypes = append(keyTypes, t)
+		}
+	}
+	if uri == "" {
+		return Upstream{}, fmt.Errorf("missing URI in upstream %q", definition)
+	}
+	if uri == LocalUpstream {
+		return Upstream{Name: uri, KeyTypes: keyTypes, Client: NewLocalClient()}, nil
+	}
+	client, err := Dial(uri)
+	if err != nil {
+		return Upstream{}, err
+	}
+	return Upstream{Name: uri, KeyTypes: keyTypes, Client: client}, nil
+}
+
+// keyTypeIdentifiers are the identifiers of all key types supported by the
+// key service.
+var keyTypeIdentifiers = []string{
+	age.KeyTypeIdentifier,
+	azkv.KeyTypeIdentifier,
+	gcpkms.KeyTypeIdentifier,
+	hcvault.KeyTypeIdentifier,
+	kms.KeyTypeIdentifier,
+	pgp.KeyTypeIdentifier,
+	pkcs11.KeyTypeIdentifier,
+}
+
+// keyTypeIdentifier returns the identifier of the key's type, as returned by
+// the TypeToIdentifier method of the corresponding MasterKey.
+func keyTypeIdentifier(key *Key) string {
+	switch key.GetKeyType().(type) {
+	case *Key_PgpKey:
+		return pgp.KeyTypeIdentifier
+	case *Key_KmsKey:
+		return kms.KeyTypeIdentifier
+	case *Key_GcpKmsKey:
+		return gcpkms.KeyTypeIdentifier
+	case *Key_AzureKeyvaultKey:
+		return azkv.KeyTypeIdentifier
+	case *Key_VaultKey:
+		return hcvault.KeyTypeIdentifier
+	case *Key_AgeKey:
+		return age.KeyTypeIdentifier
+	case *Key_Pkcs11Key:
+		return pkcs11.KeyTypeIdentifier
+	default:
+		return ""
+	}
+}
+
+// handles returns whether requests for the given key type are forwarded to
+// the upstream.
+func (u Upstream) handles(keyType string) bool {
+	return len(u.KeyTypes) == 0 || slices.Contains(u.KeyTypes, keyType)
+}
+
+// ProxyServer is a key service server that forwards requests to upstream key
+// services based on the type of the requested key. If several upstreams
+// handle a key type, they are tried in order until one can be reached; other
+// errors, like a request denied by an upstream, are returned as is.
+type ProxyServer struct {
+	Upstreams []Upstream
+	// Approver, if set, is consulted before requests are forwarded.
+	Approver Approver
+}
+
+// upstreamsFor returns the upstreams handling the request's key, after
+// checking the request was approved.
+func (p ProxyServer) upstreamsFor(ctx context.Context, key *Key, requestType string) ([]Upstream, error) {
+	keyType := keyTypeIdentifier(key)
+	if keyType == "" {
+		return nil, status.Errorf(codes.NotFound, "Must provide a key")
+	}
+	var upstreams []Upstream
+	for _, u := range p.Upstreams {
+		if u.handles(keyType) {
+			upstreams = append(upstreams, u)
+		}
+	}
+	if len(upstreams) == 0 {
+		return nil, status.Errorf(codes.Unimplemented, "No upstream key service configured for %s keys", keyType)
+	}
+	if err := (Server{Approver: p.Approver}).approve(ctx, key, requestType); err != nil {
+		return nil, err
+	}
+	return upstreams, nil
+}
+
+// shouldFailover returns whether the error returned by an upstream means it
+// could not be reached, and the next upstream should be tried.
+func shouldFailover(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// upstreamError combines the errors returned by all upstreams tried for a
+// request.
+func upstreamError(requestType string, errs []string) error {
+	return status.Errorf(codes.Unavailable, "All upstream key services failed to %s: %s", requestType, strings.Join(errs, "; "))
+}
+
+// Encrypt forwards the encrypt request to the first upstream handling the
+// request's key type that can be reached.
+func (p ProxyServer) Encrypt(ctx context.Context, req *EncryptRequest) (*EncryptResponse, error) {
+	upstreams, err := p.upstreamsFor(ctx, req.Key, "encrypt")
+	if err != nil {
+		return nil, err
+	}
+	var errs []string
+	for _, u := range upstreams {
+		resp, err := u.Client.Encrypt(ctx, req)
+		if err == nil {
+			return resp, nil
+		}
+		if !shouldFailover(err) {
+			return nil, err
+		}
+		log.WithField("upstream", u.Name).Warnf("Upstream failed to encrypt: %s", err)
+		errs = append(errs, fmt.Sprintf("%s: %s", u.Name, err))
+	}
+	return nil, upstreamError("encrypt", errs)
+}
+
+// Decrypt forwards the decrypt request to the first upstream handling the
+// request's key type that can be reached.
+func (p ProxyServer) Decrypt(ctx context.Context, req *DecryptRequest) (*DecryptResponse, error) {
+	upstreams, err := p.upstreamsFor(ctx, req.Key, "decrypt")
+	if err != nil {
+		return nil, err
+	}
+	var errs []string
+	for _, u := range upstreams {
+		resp, err := u.Client.Decrypt(ctx, req)
+		if err == nil {
+			return resp, nil
+		}
+		if !shouldFailover(err) {
+			return nil, err
+		}
+		log.WithField("upstream", u.Name).Warnf("Upstream failed to decrypt: %s", err)
+		errs = append(errs, fmt.Sprintf("%s: %s", u.Name, err))
+	}
+	return nil, upstreamError("decrypt", errs)
+}
+
+// Rewrap forwards the rewrap request to the first upstream handling the
+// request's key type that can be reached.
+func (p ProxyServer) Rewrap(ctx context.Context, req *RewrapRequest) (*RewrapResponse, error) {
+	upstreams, err := p.upstreamsFor(ctx, req.Key, "rewrap")
+	if err != nil {
+		return nil, err
+	}
+	var errs []string
+	for _, u := range upstreams {
+		resp, err := u.Client.Rewrap(ctx, req)
+		if err == nil {
+			return resp, nil
+		}
+		if !shouldFailover(err) {
+			return nil, err
+		}
+		log.WithField("upstream", u.Name).Warnf("Upstream failed to rewrap: %s", err)
+		errs = append(errs, fmt.Sprintf("%s: %s", u.Name, err))
+	}
+	return nil, upstreamError("rewrap", errs)
+}
@@ -0,0 +1,179 @@
+package keyservice
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// mockClient is a KeyServiceClient that records the requests it receives.
+type mockClient struct {
+	name     string
+	err      error
+	requests int
+}
+
+func (c *mockClient) Encrypt(ctx context.Context, req *EncryptRequest, opts ...grpc.CallOption) (*EncryptResponse, error) {
+	c.requests++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &EncryptResponse{Ciphertext: []byte(c.name)}, nil
+}
+
+func (c *mockClient) Decrypt(ctx context.Context, req *DecryptRequest, opts ...grpc.CallOption) (*DecryptResponse, error) {
+	c.requests++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &DecryptResponse{Plaintext: []byte(c.name)}, nil
+}
+
+func (c *mockClient) Rewrap(ctx context.Context, req *RewrapRequest, opts ...grpc.CallOption) (*RewrapResponse, error) {
+	c.requests++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &RewrapResponse{Ciphertext: []byte(c.name)}, nil
+}
+
+func TestParseUpstream(t *testing.T) {
+	tests := []struct {
+		definition   string
+		wantName     string
+		wantKeyTypes []string
+		wantErr      string
+	}{
+		{definition: "tcp://localhost:5000", wantName: "tcp://localhost:5000"},
+		{definition: "local", wantName: "local"},
+		{definition: "age=local", wantName: "local", wantKeyTypes: []string{"age"}},
+		{definition: "kms,gcp_kms=tcp://localhost:5000", wantName: "tcp://localhost:5000", wantKeyTypes: []string{"kms", "gcp_kms"}},
+		{definition: "unix:///tmp/a=b.sock", wantName: "unix:///tmp/a=b.sock"},
+		{definition: "foo=local", wantErr: `unknown key type "foo"`},
+		{definition: "age=", wantErr: "missing URI"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.definition, func(t *testing.T) {
+			got, err := ParseUpstream(tt.definition)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantName, got.Name)
+			assert.Equal(t, tt.wantKeyTypes, got.KeyTypes)
+			assert.NotNil(t, got.Client)
+		})
+	}
+}
+
+func TestProxyServer(t *testing.T) {
+	ageKey := &Key{KeyType: &Key_AgeKey{AgeKey: &AgeKey{Recipient: mockAgeRecipient}}}
+	kmsKey := &Key{KeyType: &Key_KmsKey{KmsKey: &KmsKey{Arn: "arn:aws:kms:us-east-1:123456789012:key/abc"}}}
+
+	t.Run("routes by key type", func(t *testing.T) {
+		bastion := &mockClient{name: "bastion"}
+		local := &mockClient{name: "local"}
+		p := ProxyServer{Upstreams: []Upstream{
+			{Name: "bastion", KeyTypes: []string{"kms"}, Client: bastion},
+			{Name: "local", KeyTypes: []string{"age"}, Client: local},
+		}}
+
+		resp, err := p.Decrypt(context.Background(), &DecryptRequest{Key: kmsKey})
+		require.NoError(t, err)
+		assert.Equal(t, "bastion", string(resp.Plaintext))
+
+		encResp, err := p.Encrypt(context.Background(), &EncryptRequest{Key: ageKey})
+		require.NoError(t, err)
+		assert.Equal(t, "local", string(encResp.Ciphertext))
+		assert.Equal(t, 1, bastion.requests)
+		assert.Equal(t, 1, local.requests)
+	})
+
+	t.Run("fails over to next upstream", func(t *testing.T) {
+		primary := &mockClient{name: "primary", err: status.Error(codes.Unavailable, "connection refused")}
+		secondary := &mockClient{name: "secondary"}
+		p := ProxyServer{Upstreams: []Upstream{
+			{Name: "primary", Client: primary},
+			{Name: "secondary", Client: secondary},
+		}}
+
+		resp, err := p.Decrypt(context.Background(), &DecryptRequest{Key: kmsKey})
+		require.NoError(t, err)
+		assert.Equal(t, "secondary", string(resp.Plaintext))
+		assert.Equal(t, 1, primary.requests)
+	})
+
+	t.Run("all upstreams fail", func(t *testing.T) {
+		p := ProxyServer{Upstreams: []Upstream{
+			{Name: "primary", Client: &mockClient{err: status.Error(codes.Unavailable, "primary down")}},
+			{Name: "secondary", Client: &mockClient{err: status.Error(codes.DeadlineExceeded, "secondary down")}},
+		}}
+
+		_, err := p.Decrypt(context.Background(), &DecryptRequest{Key: kmsKey})
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+		assert.ErrorContains(t, err, "primary down")
+		assert.ErrorContains(t, err, "secondary down")
+	})
+
+	t.Run("does not fail over on other errors", func(t *testing.T) {
+		for _, upstreamErr := range []error{
+			status.Error(codes.PermissionDenied, "Request rejected by user"),
+			status.Error(codes.NotFound, "no such key"),
+			errors.New("not a status"),
+		} {
+			secondary := &mockClient{name: "secondary"}
+			p := ProxyServer{Upstreams: []Upstream{
+				{Name: "primary", Client: &mockClient{err: upstreamErr}},
+				{Name: "secondary", Client: secondary},
+			}}
+
+			_, err := p.Decrypt(context.Background(), &DecryptRequest{Key: kmsKey})
+			assert.Equal(t, upstreamErr, err)
+			_, err = p.Encrypt(context.Background(), &EncryptRequest{Key: kmsKey})
+			assert.Equal(t, upstreamErr, err)
+			assert.Zero(t, secondary.requests)
+		}
+	})
+
+	t.Run("no upstream for key type", func(t *testing.T) {
+		p := ProxyServer{Upstreams: []Upstream{
+			{Name: "bastion", KeyTypes: []string{"kms"}, Client: &mockClient{}},
+		}}
+
+		_, err := p.Encrypt(context.Background(), &EncryptRequest{Key: ageKey})
+		assert.Equal(t, codes.Unimplemented, status.Code(err))
+	})
+
+	t.Run("denied requests are not forwarded", func(t *testing.T) {
+		upstream := &mockClient{}
+		p := ProxyServer{
+			Upstreams: []Upstream{{Name: "upstream", Client: upstream}},
+			Approver:  &mockApprover{err: errRejected()},
+		}
+
+		_, err := p.Decrypt(context.Background(), &DecryptRequest{Key: kmsKey})
+		assertDenied(t, err)
+		assert.Zero(t, upstream.requests)
+	})
+
+	t.Run("forwards rewrap requests", func(t *testing.T) {
+		vaultKey := &Key{KeyType: &Key_VaultKey{VaultKey: &VaultKey{VaultAddress: "https://vault:8200", EnginePath: "transit", KeyName: "sops"}}}
+		down := &mockClient{name: "down", err: status.Error(codes.Unavailable, "connection refused")}
+		upstream := &mockClient{name: "upstream"}
+		p := ProxyServer{Upstreams: []Upstream{
+			{Name: "down", Client: down},
+			{Name: "upstream", Client: upstream},
+		}}
+
+		resp, err := p.Rewrap(context.Background(), &RewrapRequest{Key: vaultKey, Ciphertext: []byte("vault:v1:old")})
+		require.NoError(t, err)
+		assert.Equal(t, "upstream", string(resp.Ciphertext))
+	})
+}
@@ -2,22 +2,41 @@ package keyservice
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/AetherVoxSanctum/envv-cli/v3/age"
 	"github.com/AetherVoxSanctum/envv-cli/v3/azkv"
 	"github.com/AetherVoxSanctum/envv-cli/v3/gcpkms"
 	"github.com/AetherVoxSanctum/envv-cli/v3/hcvault"
 	"github.com/AetherVoxSanctum/envv-cli/v3/kms"
+	"github.com/AetherVoxSanctum/envv-cli/v3/logging"
 	"github.com/AetherVoxSanctum/envv-cli/v3/pgp"
+	"github.com/AetherVoxSanctum/envv-cli/v3/pkcs11"
+	"github.com/sirupsen/logrus"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+var log *logrus.Logger
+
+func init() {
+	log = logging.NewLogger("KEYSERVICE")
+}
+
+// ttyApprover is the Approver used when Server.Prompt is set without an
+// explicit Approver. It is shared so prompts of all servers are serialized.
+var ttyApprover = NewTTYApprover(os.Stdin, os.Stdout, 0)
+
 // Server is a key service server that uses SOPS MasterKeys to fulfill requests
 type Server struct {
-	// Prompt indicates whether the server should prompt before decrypting or encrypting data
+	// Prompt indicates whether the server should prompt on the terminal before decrypting or encrypting data
 	Prompt bool
+	// Approver, if set, is consulted before decrypting or encrypting data. It takes precedence over Prompt.
+	Approver Approver
+	// AgeIdentities, if set, are the age identities used to decrypt data, instead of the ones configured in the
+	// server's environment. For every request, only the identities matching the requested recipient are used.
+	AgeIdentities age.ParsedIdentities
 }
 
 func (ks *Server) encryptWithPgp(key *PgpKey, plaintext []byte) ([]byte, error) {
@@ -38,18 +57,18 @@ func (ks *Server) encryptWithKms(key *KmsKey, plaintext []byte) ([]byte, error)
 	return []byte(kmsKey.EncryptedKey), nil
 }
 
-func (ks *Server) encryptWithGcpKms(key *GcpKmsKey, plaintext []byte) ([]byte, error) {
+func (ks *Server) encryptWithGcpKms(key *GcpKmsKey, plaintext []byte) ([]byte, string, error) {
 	gcpKmsKey := gcpkms.MasterKey{
 		ResourceID: key.ResourceId,
 	}
 	err := gcpKmsKey.Encrypt(plaintext)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	return []byte(gcpKmsKey.EncryptedKey), nil
+	return []byte(gcpKmsKey.EncryptedKey), gcpKmsKey.Version, nil
 }
 
-func (ks *Server) encryptWithAzureKeyVault(key *AzureKeyVaultKey, plaintext []byte) ([]byte, error) {
+func (ks *Server) encryptWithAzureKeyVault(key *AzureKeyVaultKey, plaintext []byte) ([]byte, string, error) {
 	azkvKey := azkv.MasterKey{
 		VaultURL: key.VaultUrl,
 		Name:     key.Name,
@@ -57,9 +76,9 @@ func (ks *Server) encryptWithAzureKeyVault(key *AzureKeyVaultKey, plaintext []by
 	}
 	err := azkvKey.Encrypt(plaintext)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	return []byte(azkvKey.EncryptedKey), nil
+	return []byte(azkvKey.EncryptedKey), azkvKey.Version, nil
 }
 
 func (ks *Server) encryptWithVault(key *VaultKey, plaintext []byte) ([]byte, error) {
@@ -67,6 +86,9 @@ func (ks *Server) encryptWithVault(key *VaultKey, plaintext []byte) ([]byte, err
 		VaultAddress: key.VaultAddress,
 		EnginePath:   key.EnginePath,
 		KeyName:      key.KeyName,
+		AuthMethod:   key.AuthMethod,
+		Role:         key.Role,
+		Namespace:    key.Namespace,
 	}
 	err := vaultKey.Encrypt(plaintext)
 	if err != nil {
@@ -87,6 +109,16 @@ func (ks *Server) encryptWithAge(key *AgeKey, plaintext []byte) ([]byte, error)
 	return []byte(ageKey.EncryptedKey), nil
 }
 
+func (ks *Server) encryptWithPkcs11(key *Pkcs11Key, plaintext []byte) ([]byte, error) {
+	pkcs11Key := pkcs11.MasterKey{
+		URI: key.Uri,
+	}
+	if err := pkcs11Key.Encrypt(plaintext); err != nil {
+		return nil, err
+	}
+	return []byte(pkcs11Key.EncryptedKey), nil
+}
+
 func (ks *Server) decryptWithPgp(key *PgpKey, ciphertext []byte) ([]byte, error) {
 	pgpKey := pgp.NewMasterKeyFromFingerprint(key.Fingerprint)
 	pgpKey.EncryptedKey = string(ciphertext)
@@ -126,26 +158,66 @@ func (ks *Server) decryptWithVault(key *VaultKey, ciphertext []byte) ([]byte, er
 		VaultAddress: key.VaultAddress,
 		EnginePath:   key.EnginePath,
 		KeyName:      key.KeyName,
+		AuthMethod:   key.AuthMethod,
+		Role:         key.Role,
+		Namespace:    key.Namespace,
 	}
 	vaultKey.EncryptedKey = string(ciphertext)
 	plaintext, err := vaultKey.Decrypt()
 	return []byte(plaintext), err
 }
 
+func (ks *Server) rewrapWithVault(key *VaultKey, ciphertext []byte) ([]byte, error) {
+	vaultKey := hcvault.MasterKey{
+		VaultAddress: key.VaultAddress,
+		EnginePath:   key.EnginePath,
+		KeyName:      key.KeyName,
+		AuthMethod:   key.AuthMethod,
+		Role:         key.Role,
+		Namespace:    key.Namespace,
+	}
+	vaultKey.EncryptedKey = string(ciphertext)
+	err := vaultKey.Rewrap()
+	return []byte(vaultKey.EncryptedKey), err
+}
+
 func (ks *Server) decryptWithAge(key *AgeKey, ciphertext []byte) ([]byte, error) {
 	ageKey := age.MasterKey{
 		Recipient: key.Recipient,
 	}
+	// Scrypt recipients are decrypted with a passphrase, not an identity.
+	if len(ks.AgeIdentities) > 0 && !age.IsScryptRecipient(key.Recipient) {
+		identities := ks.AgeIdentities.ForRecipient(key.Recipient)
+		if len(identities) == 0 {
+			return nil, status.Errorf(codes.FailedPrecondition, "No configured age identity matches recipient %s", key.Recipient)
+		}
+		identities.ApplyToMasterKey(&ageKey)
+	}
 	ageKey.EncryptedKey = string(ciphertext)
 	plaintext, err := ageKey.Decrypt()
 	return []byte(plaintext), err
 }
 
+func (ks *Server) decryptWithPkcs11(key *Pkcs11Key, ciphertext []byte) ([]byte, error) {
+	pkcs11Key := pkcs11.MasterKey{
+		URI: key.Uri,
+	}
+	pkcs11Key.EncryptedKey = string(ciphertext)
+	plaintext, err := pkcs11Key.Decrypt()
+	return []byte(plaintext), err
+}
+
 // Encrypt takes an encrypt request and encrypts the provided plaintext with the provided key, returning the encrypted
 // result
 func (ks Server) Encrypt(ctx context.Context,
 	req *EncryptRequest) (*EncryptResponse, error) {
 	key := req.Key
+	if key.GetKeyType() == nil {
+		return nil, status.Errorf(codes.NotFound, "Must provide a key")
+	}
+	if err := ks.approve(ctx, key, "encrypt"); err != nil {
+		return nil, err
+	}
 	var response *EncryptResponse
 	switch k := key.KeyType.(type) {
 	case *Key_PgpKey:
@@ -165,20 +237,22 @@ func (ks Server) Encrypt(ctx context.Context,
 			Ciphertext: ciphertext,
 		}
 	case *Key_GcpKmsKey:
-		ciphertext, err := ks.encryptWithGcpKms(k.GcpKmsKey, req.Plaintext)
+		ciphertext, version, err := ks.encryptWithGcpKms(k.GcpKmsKey, req.Plaintext)
 		if err != nil {
 			return nil, err
 		}
 		response = &EncryptResponse{
 			Ciphertext: ciphertext,
+			KeyVersion: version,
 		}
 	case *Key_AzureKeyvaultKey:
-		ciphertext, err := ks.encryptWithAzureKeyVault(k.AzureKeyvaultKey, req.Plaintext)
+		ciphertext, version, err := ks.encryptWithAzureKeyVault(k.AzureKeyvaultKey, req.Plaintext)
 		if err != nil {
 			return nil, err
 		}
 		response = &EncryptResponse{
 			Ciphertext: ciphertext,
+			KeyVersion: version,
 		}
 	case *Key_VaultKey:
 		ciphertext, err := ks.encryptWithVault(k.VaultKey, req.Plaintext)
@@ -196,22 +270,24 @@ func (ks Server) Encrypt(ctx context.Context,
 		response = &EncryptResponse{
 			Ciphertext: ciphertext,
 		}
+	case *Key_Pkcs11Key:
+		ciphertext, err := ks.encryptWithPkcs11(k.Pkcs11Key, req.Plaintext)
+		if err != nil {
+			return nil, err
+		}
+		response = &EncryptResponse{
+			Ciphertext: ciphertext,
+		}
 	case nil:
 		return nil, status.Errorf(codes.NotFound, "Must provide a key")
 	default:
 		return nil, status.Errorf(codes.NotFound, "Unknown key type")
 	}
-	if ks.Prompt {
-		err := ks.prompt(key, "encrypt")
-		if err != nil {
-			return nil, err
-		}
-	}
 	return response, nil
 }
 
 func keyToString(key *Key) string {
-	switch k := key.KeyType.(type) {
+	switch k := key.GetKeyType().(type) {
 	case *Key_PgpKey:
 		return fmt.Sprintf("PGP key with fingerprint %s", k.PgpKey.Fingerprint)
 	case *Key_KmsKey:
@@ -222,24 +298,32 @@ func keyToString(key *Key) string {
 		return fmt.Sprintf("Azure Key Vault key with URL %s/keys/%s/%s", k.AzureKeyvaultKey.VaultUrl, k.AzureKeyvaultKey.Name, k.AzureKeyvaultKey.Version)
 	case *Key_VaultKey:
 		return fmt.Sprintf("Hashicorp Vault key with URI %s/v1/%s/keys/%s", k.VaultKey.VaultAddress, k.VaultKey.EnginePath, k.VaultKey.KeyName)
+	case *Key_AgeKey:
+		return fmt.Sprintf("age key with recipient %s", k.AgeKey.Recipient)
+	case *Key_Pkcs11Key:
+		return fmt.Sprintf("PKCS#11 key with URI %s", k.Pkcs11Key.Uri)
 	default:
 		return "Unknown key type"
 	}
 }
 
-func (ks Server) prompt(key *Key, requestType string) error {
-	keyString := keyToString(key)
-	var response string
-	for response != "y" && response != "n" {
-		fmt.Printf("\nReceived %s request using %s. Respond to request? (y/n): ", requestType, keyString)
-		_, err := fmt.Scanln(&response)
-		if err != nil {
-			return err
-		}
+// approve asks the configured Approver, if any, whether the request may be
+// fulfilled
+func (ks Server) approve(ctx context.Context, key *Key, requestType string) error {
+	approver := ks.Approver
+	if approver == nil && ks.Prompt {
+		approver = ttyApprover
+	}
+	if approver == nil {
+		return nil
 	}
-	if response == "n" {
-		return status.Errorf(codes.PermissionDenied, "Request rejected by user")
+	req := newApprovalRequest(key, requestType)
+	err := approver.Approve(ctx, req)
+	if err != nil {
+		log.WithField("id", req.ID).WithField("key", req.Key).Infof("Denied %s request: %s", requestType, err)
+		return err
 	}
+	log.WithField("id", req.ID).WithField("key", req.Key).Debugf("Approved %s request", requestType)
 	return nil
 }
 
@@ -248,6 +332,12 @@ func (ks Server) prompt(key *Key, requestType string) error {
 func (ks Server) Decrypt(ctx context.Context,
 	req *DecryptRequest) (*DecryptResponse, error) {
 	key := req.Key
+	if key.GetKeyType() == nil {
+		return nil, status.Errorf(codes.NotFound, "Must provide a key")
+	}
+	if err := ks.approve(ctx, key, "decrypt"); err != nil {
+		return nil, err
+	}
 	var response *DecryptResponse
 	switch k := key.KeyType.(type) {
 	case *Key_PgpKey:
@@ -298,17 +388,19 @@ func (ks Server) Decrypt(ctx context.Context,
 		response = &DecryptResponse{
 			Plaintext: plaintext,
 		}
+	case *Key_Pkcs11Key:
+		plaintext, err := ks.decryptWithPkcs11(k.Pkcs11Key, req.Ciphertext)
+		if err != nil {
+			return nil, err
+		}
+		response = &DecryptResponse{
+			Plaintext: plaintext,
+		}
 	case nil:
 		return nil, status.Errorf(codes.NotFound, "Must provide a key")
 	default:
 		return nil, status.Errorf(codes.NotFound, "Unknown key type")
 	}
-	if ks.Prompt {
-		err := ks.prompt(key, "decrypt")
-		if err != nil {
-			return nil, err
-		}
-	}
 	return response, nil
 }
 
@@ -323,5 +415,31 @@ func kmsKeyToMasterKey(key *KmsKey) kms.MasterKey {
 		Role:              key.Role,
 		EncryptionContext: ctx,
 		AwsProfile:        key.AwsProfile,
+		ReplicaRegions:    key.ReplicaRegions,
+	}
+}
+
+// Rewrap takes a rewrap request and re-encrypts the provided ciphertext with
+// the latest version of the provided key, without decrypting it on the
+// server. Only Vault keys support rewrapping.
+func (ks Server) Rewrap(ctx context.Context,
+	req *RewrapRequest) (*RewrapResponse, error) {
+	key := req.Key
+	if key.GetKeyType() == nil {
+		return nil, status.Errorf(codes.NotFound, "Must provide a key")
+	}
+	k, ok := key.KeyType.(*Key_VaultKey)
+	if !ok {
+		return nil, status.Errorf(codes.Unimplemented, "Rewrapping is only supported for Vault keys")
+	}
+	if err := ks.approve(ctx, key, "rewrap"); err != nil {
+		return nil, err
+	}
+	ciphertext, err := ks.rewrapWithVault(k.VaultKey, req.Ciphertext)
+	if err != nil {
+		return nil, err
 	}
+	return &RewrapResponse{
+		Ciphertext: ciphertext,
+	}, nil
 }
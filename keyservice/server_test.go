@@ -1,9 +1,14 @@
 package keyservice
 
 import (
+	"testing"
+
+	"github.com/AetherVoxSanctum/envv-cli/v3/age"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-	"testing"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestKmsKeyToMasterKey(t *testing.T) {
@@ -79,3 +84,49 @@ func TestKmsKeyToMasterKey(t *testing.T) {
 		})
 	}
 }
+
+func TestKeyToString(t *testing.T) {
+	key := &Key{KeyType: &Key_AgeKey{AgeKey: &AgeKey{Recipient: mockAgeRecipient}}}
+	assert.Equal(t, "age key with recipient "+mockAgeRecipient, keyToString(key))
+	key = &Key{KeyType: &Key_Pkcs11Key{Pkcs11Key: &Pkcs11Key{Uri: "pkcs11:token=sops;object=master"}}}
+	assert.Equal(t, "PKCS#11 key with URI pkcs11:token=sops;object=master", keyToString(key))
+	assert.Equal(t, "Unknown key type", keyToString(&Key{}))
+}
+
+func TestServerRewrap(t *testing.T) {
+	_, err := Server{}.Rewrap(context.Background(), &RewrapRequest{})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	key := &Key{KeyType: &Key_AgeKey{AgeKey: &AgeKey{Recipient: mockAgeRecipient}}}
+	_, err = Server{}.Rewrap(context.Background(), &RewrapRequest{Key: key, Ciphertext: []byte("ciphertext")})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+func TestServerAgeIdentities(t *testing.T) {
+	const (
+		// mockAgeIdentity matches mockAgeRecipient.
+		mockAgeIdentity = "AGE-SECRET-KEY-1G0Q5K9TV4REQ3ZSQRMTMG8NSWQGYT0T7TZ33RAZEE0GZYVZN0APSU24RK7"
+		// mockOtherAgeIdentity does not match mockAgeRecipient.
+		mockOtherAgeIdentity = "AGE-SECRET-KEY-1432K5YRNSC44GC4986NXMX6GVZ52WTMT9C79CLUVWYY4DKDHD5JSNDP4MC"
+	)
+	key := &Key{KeyType: &Key_AgeKey{AgeKey: &AgeKey{Recipient: mockAgeRecipient}}}
+	encResp, err := Server{}.Encrypt(context.Background(), &EncryptRequest{Key: key, Plaintext: []byte("data key")})
+	require.NoError(t, err)
+
+	t.Run("matching identity", func(t *testing.T) {
+		var identities age.ParsedIdentities
+		require.NoError(t, identities.Import(mockOtherAgeIdentity, mockAgeIdentity))
+		ks := Server{AgeIdentities: identities}
+		resp, err := ks.Decrypt(context.Background(), &DecryptRequest{Key: key, Ciphertext: encResp.Ciphertext})
+		require.NoError(t, err)
+		assert.Equal(t, "data key", string(resp.Plaintext))
+	})
+
+	t.Run("no matching identity", func(t *testing.T) {
+		var identities age.ParsedIdentities
+		require.NoError(t, identities.Import(mockOtherAgeIdentity))
+		ks := Server{AgeIdentities: identities}
+		_, err := ks.Decrypt(context.Background(), &DecryptRequest{Key: key, Ciphertext: encResp.Ciphertext})
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	})
+}
@@ -8,12 +8,14 @@ package kms // import "github.com/AetherVoxSanctum/envv-cli/v3/kms"
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"regexp"
 	"sort"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -39,6 +41,14 @@ const (
 	kmsTTL = time.Hour * 24 * 30 * 6
 	// KeyTypeIdentifier is the string used to identify an AWS KMS MasterKey.
 	KeyTypeIdentifier = "kms"
+	// SopsKMSReplicaRegionsEnv can be set to a comma separated list of AWS
+	// regions to try decrypting multi-Region keys in, in addition to the
+	// replica regions stored with the key.
+	SopsKMSReplicaRegionsEnv = "SOPS_KMS_REPLICA_REGIONS"
+	// multiRegionKeyPrefix is the prefix of the key ID of AWS KMS multi-Region
+	// keys.
+	// Ref: https://docs.aws.amazon.com/kms/latest/developerguide/multi-region-keys-overview.html
+	multiRegionKeyPrefix = "mrk-"
 )
 
 var (
@@ -69,6 +79,10 @@ type MasterKey struct {
 	// AwsProfile is the profile to use for loading configuration and credentials.
 	// Ref: https://aws.github.io/aws-sdk-go-v2/docs/configuring-sdk/#specifying-profiles
 	AwsProfile string
+	// ReplicaRegions are the AWS regions the multi-Region key is replicated
+	// to. If decryption in the region of the ARN fails, the replica keys in
+	// these regions are tried in order. It is ignored for single-Region keys.
+	ReplicaRegions []string
 
 	// credentialsProvider is used to configure the AWS client config with
 	// credentials. It can be injected by a (local) keyservice.KeyServiceServer
@@ -134,8 +148,28 @@ func MasterKeysFromArnString(arn string, context map[string]*string, awsProfile
 	return keys
 }
 
+// IsMultiRegionKey returns true if the ARN refers to an AWS KMS multi-Region
+// key, e.g. "arn:aws:kms:us-east-1:111122223333:key/mrk-1234abcd".
+func IsMultiRegionKey(arn string) bool {
+	_, keyID, ok := strings.Cut(arn, ":key/")
+	return ok && strings.HasPrefix(keyID, multiRegionKeyPrefix)
+}
+
+// ParseReplicaRegions takes a comma separated list of AWS regions, and returns
+// a slice of the non-empty regions.
+func ParseReplicaRegions(regions string) []string {
+	var out []string
+	for _, r := range strings.Split(regions, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
 // ParseKMSContext takes either a KMS context map or a comma-separated list of
-// KMS context key:value pairs, and returns a map.
+// KMS context key:value pairs, and returns a map. The values are returned as
+// is, templates in them can be executed with RenderKMSContext.
 func ParseKMSContext(in interface{}) map[string]*string {
 	const nonStringValueWarning = "Encryption context contains a non-string value, context will not be used"
 	out := make(map[string]*string)
@@ -185,6 +219,47 @@ func ParseKMSContext(in interface{}) map[string]*string {
 	return out
 }
 
+// ContextTemplateData is the data encryption context templates are executed
+// with by RenderKMSContext.
+type ContextTemplateData struct {
+	// Path is the path of the file the data key is encrypted for.
+	Path string
+	// RelPath is Path relative to the directory of the config file.
+	RelPath string
+	// Match holds the named capture groups of the path regex of the creation
+	// rule matching RelPath.
+	Match map[string]string
+}
+
+// RenderKMSContext executes the values of the encryption context as
+// text/template templates with the given data, for example
+// "{{.RelPath}}" or "{{.Match.env}}", and returns the resulting context. It
+// returns an error if a template refers to a capture group which did not
+// match, so the context never silently contains empty values.
+func RenderKMSContext(in map[string]*string, data ContextTemplateData) (map[string]*string, error) {
+	if in == nil {
+		return nil, nil
+	}
+	out := make(map[string]*string, len(in))
+	for k, v := range in {
+		if v == nil || !strings.Contains(*v, "{{") {
+			out[k] = v
+			continue
+		}
+		tmpl, err := template.New(k).Option("missingkey=error").Parse(*v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption context template for %q: %w", k, err)
+		}
+		var buf strings.Builder
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("could not render encryption context template for %q: %w", k, err)
+		}
+		value := buf.String()
+		out[k] = &value
+	}
+	return out, nil
+}
+
 // kmsContextToString converts a dictionary into a string that can be parsed
 // again with ParseKMSContext().
 func kmsContextToString(in map[string]*string) string {
@@ -319,26 +394,78 @@ func (key *MasterKey) DecryptContext(ctx context.Context) ([]byte, error) {
 		log.WithField("arn", key.Arn).Info("Decryption failed")
 		return nil, fmt.Errorf("error base64-decoding encrypted data key: %s", err)
 	}
-	cfg, err := key.createKMSConfig(ctx)
+	decrypted, err := key.decryptWithArn(ctx, key.Arn, k)
+	if err != nil && IsMultiRegionKey(key.Arn) {
+		for _, region := range key.replicaRegions() {
+			arn, replicaErr := replicaArn(key.Arn, region)
+			if replicaErr != nil {
+				return nil, replicaErr
+			}
+			log.WithField("arn", key.Arn).Infof("Decryption failed, trying replica in %s", region)
+			if decrypted, replicaErr = key.decryptWithArn(ctx, arn, k); replicaErr == nil {
+				err = nil
+				break
+			}
+			err = errors.Join(err, replicaErr)
+		}
+	}
 	if err != nil {
 		log.WithField("arn", key.Arn).Info("Decryption failed")
 		return nil, err
 	}
+	log.WithField("arn", key.Arn).Info("Decryption succeeded")
+	return decrypted, nil
+}
+
+// decryptWithArn decrypts the ciphertext with the AWS KMS key with the given
+// ARN, in the region of that ARN.
+func (key *MasterKey) decryptWithArn(ctx context.Context, arn string, ciphertext []byte) ([]byte, error) {
+	cfg, err := key.createKMSConfigForArn(ctx, arn)
+	if err != nil {
+		return nil, err
+	}
 	client := key.createClient(cfg)
 	input := &kms.DecryptInput{
-		KeyId:             &key.Arn,
-		CiphertextBlob:    k,
+		KeyId:             &arn,
+		CiphertextBlob:    ciphertext,
 		EncryptionContext: stringPointerToStringMap(key.EncryptionContext),
 	}
 	decrypted, err := client.Decrypt(ctx, input)
 	if err != nil {
-		log.WithField("arn", key.Arn).Info("Decryption failed")
-		return nil, fmt.Errorf("failed to decrypt sops data key with AWS KMS: %w", err)
+		return nil, fmt.Errorf("failed to decrypt sops data key with AWS KMS key '%s': %w", arn, err)
 	}
-	log.WithField("arn", key.Arn).Info("Decryption succeeded")
 	return decrypted.Plaintext, nil
 }
 
+// replicaRegions returns the ReplicaRegions of the key, followed by the
+// regions set in SopsKMSReplicaRegionsEnv, without duplicates or the region
+// of the ARN itself.
+func (key *MasterKey) replicaRegions() []string {
+	seen := make(map[string]bool)
+	if m := regexp.MustCompile(arnRegex).FindStringSubmatch(key.Arn); m != nil {
+		seen[m[1]] = true
+	}
+	var regions []string
+	for _, r := range append(append([]string{}, key.ReplicaRegions...), ParseReplicaRegions(os.Getenv(SopsKMSReplicaRegionsEnv))...) {
+		if !seen[r] {
+			seen[r] = true
+			regions = append(regions, r)
+		}
+	}
+	return regions
+}
+
+// replicaArn returns the ARN of the replica of the multi-Region key in the
+// given region.
+func replicaArn(arn, region string) (string, error) {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || !regexp.MustCompile(arnRegex).MatchString(arn) {
+		return "", fmt.Errorf("no valid ARN found in '%s'", arn)
+	}
+	parts[3] = region
+	return strings.Join(parts, ":"), nil
+}
+
 // NeedsRotation returns whether the data key needs to be rotated or not.
 func (key *MasterKey) NeedsRotation() bool {
 	return time.Since(key.CreationDate) > kmsTTL
@@ -376,6 +503,9 @@ func (key MasterKey) ToMap() map[string]interface{} {
 		}
 		out["context"] = outcontext
 	}
+	if len(key.ReplicaRegions) > 0 {
+		out["replica_regions"] = key.ReplicaRegions
+	}
 	return out
 }
 
@@ -387,10 +517,16 @@ func (key *MasterKey) TypeToIdentifier() string {
 // createKMSConfig returns an AWS config with the credentialsProvider of the
 // MasterKey, or the default configuration sources.
 func (key MasterKey) createKMSConfig(ctx context.Context) (*aws.Config, error) {
+	return key.createKMSConfigForArn(ctx, key.Arn)
+}
+
+// createKMSConfigForArn returns an AWS config like createKMSConfig, for the
+// region of the given ARN.
+func (key MasterKey) createKMSConfigForArn(ctx context.Context, arn string) (*aws.Config, error) {
 	re := regexp.MustCompile(arnRegex)
-	matches := re.FindStringSubmatch(key.Arn)
+	matches := re.FindStringSubmatch(arn)
 	if matches == nil {
-		return nil, fmt.Errorf("no valid ARN found in '%s'", key.Arn)
+		return nil, fmt.Errorf("no valid ARN found in '%s'", arn)
 	}
 	region := matches[1]
 
@@ -16,6 +16,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/kms"
 	"github.com/ory/dockertest/v3"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var (
@@ -227,6 +228,34 @@ func TestParseKMSContext(t *testing.T) {
 	assert.Nil(t, ParseKMSContext("key1"))
 }
 
+func TestRenderKMSContext(t *testing.T) {
+	static := "static"
+	file := "{{.RelPath}}"
+	env := "{{.Match.env}}"
+	data := ContextTemplateData{
+		Path:    "/repo/prod/secrets.yaml",
+		RelPath: "prod/secrets.yaml",
+		Match:   map[string]string{"env": "prod"},
+	}
+
+	out, err := RenderKMSContext(map[string]*string{"static": &static, "file": &file, "env": &env}, data)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"static": "static", "file": "prod/secrets.yaml", "env": "prod"}, stringPointerToStringMap(out))
+	assert.Equal(t, "{{.RelPath}}", file, "input context must not be modified")
+
+	out, err = RenderKMSContext(nil, data)
+	assert.NoError(t, err)
+	assert.Nil(t, out)
+
+	missing := "{{.Match.team}}"
+	_, err = RenderKMSContext(map[string]*string{"team": &missing}, data)
+	assert.ErrorContains(t, err, `could not render encryption context template for "team"`)
+
+	invalid := "{{.RelPath"
+	_, err = RenderKMSContext(map[string]*string{"file": &invalid}, data)
+	assert.ErrorContains(t, err, `invalid encryption context template for "file"`)
+}
+
 func TestCreds_ApplyToMasterKey(t *testing.T) {
 	creds := NewCredentialsProvider(credentials.NewStaticCredentialsProvider("", "", ""))
 	key := &MasterKey{}
@@ -0,0 +1,144 @@
+package kms
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testMRKArn = "arn:aws:kms:us-east-1:111122223333:key/mrk-1234abcd12ab34cd56ef1234567890ab"
+
+// fakeKMS is a minimal AWS KMS stand-in which decrypts ciphertexts prefixed
+// with "ciphertext:" in all regions which are not marked as unavailable.
+type fakeKMS struct {
+	unavailable map[string]bool
+	// keyIDs records the key IDs of the decrypt requests in order.
+	keyIDs []string
+}
+
+func (f *fakeKMS) RoundTrip(req *http.Request) (*http.Response, error) {
+	region := strings.TrimSuffix(strings.TrimPrefix(req.URL.Host, "kms."), ".amazonaws.com")
+	var in struct {
+		KeyId          string
+		CiphertextBlob []byte
+	}
+	if err := json.NewDecoder(req.Body).Decode(&in); err != nil {
+		return nil, err
+	}
+	f.keyIDs = append(f.keyIDs, in.KeyId)
+	if f.unavailable[region] || !strings.Contains(in.KeyId, ":"+region+":") {
+		return fakeKMSResponse(http.StatusBadRequest, map[string]string{
+			"__type":  "KMSInvalidStateException",
+			"message": "key is unavailable in " + region,
+		}), nil
+	}
+	return fakeKMSResponse(http.StatusOK, map[string]interface{}{
+		"KeyId":     in.KeyId,
+		"Plaintext": bytes.TrimPrefix(in.CiphertextBlob, []byte("ciphertext:")),
+	}), nil
+}
+
+func fakeKMSResponse(status int, body interface{}) *http.Response {
+	b, _ := json.Marshal(body)
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/x-amz-json-1.1"}},
+		Body:       io.NopCloser(bytes.NewReader(b)),
+	}
+}
+
+func newFakeKMSKey(arn string, fake *fakeKMS) *MasterKey {
+	key := &MasterKey{
+		Arn:          arn,
+		EncryptedKey: base64.StdEncoding.EncodeToString([]byte("ciphertext:data key")),
+	}
+	NewHTTPClient(&http.Client{Transport: fake}).ApplyToMasterKey(key)
+	NewCredentialsProvider(credentials.NewStaticCredentialsProvider("id", "secret", "")).ApplyToMasterKey(key)
+	return key
+}
+
+func TestIsMultiRegionKey(t *testing.T) {
+	assert.True(t, IsMultiRegionKey(testMRKArn))
+	assert.False(t, IsMultiRegionKey("arn:aws:kms:us-east-1:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab"))
+	assert.False(t, IsMultiRegionKey("arn:aws:kms:us-east-1:111122223333:alias/mrk-alias"))
+}
+
+func TestParseReplicaRegions(t *testing.T) {
+	assert.Equal(t, []string{"us-west-2", "eu-west-1"}, ParseReplicaRegions(" us-west-2,, eu-west-1 "))
+	assert.Nil(t, ParseReplicaRegions(""))
+}
+
+func Test_replicaArn(t *testing.T) {
+	arn, err := replicaArn(testMRKArn, "eu-west-1")
+	require.NoError(t, err)
+	assert.Equal(t, "arn:aws:kms:eu-west-1:111122223333:key/mrk-1234abcd12ab34cd56ef1234567890ab", arn)
+
+	_, err = replicaArn("mrk-1234", "eu-west-1")
+	assert.Error(t, err)
+}
+
+func TestMasterKey_Decrypt_MultiRegion(t *testing.T) {
+	t.Setenv(SopsKMSReplicaRegionsEnv, "")
+	// A custom CA bundle can not be applied to the HTTP client of the stand-in.
+	t.Setenv("AWS_CA_BUNDLE", "")
+
+	t.Run("home region", func(t *testing.T) {
+		fake := &fakeKMS{}
+		key := newFakeKMSKey(testMRKArn, fake)
+		key.ReplicaRegions = []string{"us-west-2"}
+		got, err := key.Decrypt()
+		require.NoError(t, err)
+		assert.Equal(t, []byte("data key"), got)
+		assert.Equal(t, []string{testMRKArn}, fake.keyIDs)
+	})
+
+	t.Run("replica regions", func(t *testing.T) {
+		fake := &fakeKMS{unavailable: map[string]bool{"us-east-1": true, "us-west-2": true}}
+		key := newFakeKMSKey(testMRKArn, fake)
+		key.ReplicaRegions = []string{"us-east-1", "us-west-2", "eu-west-1"}
+		got, err := key.Decrypt()
+		require.NoError(t, err)
+		assert.Equal(t, []byte("data key"), got)
+		assert.Equal(t, []string{
+			testMRKArn,
+			"arn:aws:kms:us-west-2:111122223333:key/mrk-1234abcd12ab34cd56ef1234567890ab",
+			"arn:aws:kms:eu-west-1:111122223333:key/mrk-1234abcd12ab34cd56ef1234567890ab",
+		}, fake.keyIDs)
+	})
+
+	t.Run("replica regions from environment", func(t *testing.T) {
+		t.Setenv(SopsKMSReplicaRegionsEnv, "eu-west-1")
+		fake := &fakeKMS{unavailable: map[string]bool{"us-east-1": true}}
+		key := newFakeKMSKey(testMRKArn, fake)
+		got, err := key.Decrypt()
+		require.NoError(t, err)
+		assert.Equal(t, []byte("data key"), got)
+		assert.Len(t, fake.keyIDs, 2)
+	})
+
+	t.Run("all regions unavailable", func(t *testing.T) {
+		fake := &fakeKMS{unavailable: map[string]bool{"us-east-1": true, "us-west-2": true}}
+		key := newFakeKMSKey(testMRKArn, fake)
+		key.ReplicaRegions = []string{"us-west-2"}
+		_, err := key.Decrypt()
+		assert.ErrorContains(t, err, "key is unavailable in us-east-1")
+		assert.ErrorContains(t, err, "key is unavailable in us-west-2")
+	})
+
+	t.Run("single-Region key", func(t *testing.T) {
+		fake := &fakeKMS{unavailable: map[string]bool{"us-east-1": true}}
+		key := newFakeKMSKey("arn:aws:kms:us-east-1:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab", fake)
+		key.ReplicaRegions = []string{"us-west-2"}
+		_, err := key.Decrypt()
+		assert.Error(t, err)
+		assert.Len(t, fake.keyIDs, 1)
+	})
+}
@@ -0,0 +1,172 @@
+/*
+Package pkcs11 contains an implementation of the github.com/AetherVoxSanctum/envv-cli/v3/keys.MasterKey
+interface that encrypts and decrypts the data key with a key stored on a
+PKCS#11 token, such as a Hardware Security Module.
+
+Keys are identified by PKCS#11 URIs (RFC 7512). AES keys wrap the data key
+with CKM_AES_KEY_WRAP (RFC 3394), RSA key pairs encrypt it with RSA-OAEP using
+SHA-256.
+*/
+package pkcs11 // import "github.com/AetherVoxSanctum/envv-cli/v3/pkcs11"
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/AetherVoxSanctum/envv-cli/v3/logging"
+)
+
+const (
+	// SopsPKCS11ModuleEnv must be set as an environment variable to the path
+	// of the PKCS#11 module.
+	SopsPKCS11ModuleEnv = "SOPS_PKCS11_MODULE"
+	// SopsPKCS11PinEnv can be set as an environment variable to the user PIN
+	// of the token.
+	SopsPKCS11PinEnv = "SOPS_PKCS11_PIN"
+	// SopsPKCS11PinFileEnv can be set as an environment variable to a file
+	// the user PIN of the token is read from, if SopsPKCS11PinEnv is not set.
+	SopsPKCS11PinFileEnv = "SOPS_PKCS11_PIN_FILE"
+	// KeyTypeIdentifier is the string used to identify a PKCS#11 MasterKey.
+	KeyTypeIdentifier = "pkcs11"
+)
+
+var (
+	// log is the global logger for any PKCS#11 MasterKey.
+	log *logrus.Logger
+	// pkcs11TTL is the duration after which a MasterKey requires rotation.
+	pkcs11TTL = time.Hour * 24 * 30 * 6
+)
+
+func init() {
+	log = logging.NewLogger("PKCS11")
+}
+
+// MasterKey is a key on a PKCS#11 token used to Encrypt and Decrypt SOPS'
+// data key.
+type MasterKey struct {
+	// URI is the PKCS#11 URI identifying the key, e.g.
+	// "pkcs11:token=sops;object=master-key".
+	URI string
+	// EncryptedKey contains the SOPS data key encrypted with the PKCS#11 key.
+	EncryptedKey string
+	// CreationDate of the MasterKey, used to determine if the EncryptedKey
+	// needs rotation.
+	CreationDate time.Time
+}
+
+// NewMasterKeyFromURI creates a new MasterKey from a PKCS#11 URI, setting the
+// creation date to the current date.
+func NewMasterKeyFromURI(uri string) (*MasterKey, error) {
+	uri = strings.TrimSpace(uri)
+	if _, err := ParseURI(uri); err != nil {
+		return nil, err
+	}
+	return &MasterKey{
+		URI:          uri,
+		CreationDate: time.Now().UTC(),
+	}, nil
+}
+
+// MasterKeysFromURIs takes a comma separated list of PKCS#11 URIs, and
+// returns a slice of new MasterKeys.
+func MasterKeysFromURIs(uris string) ([]*MasterKey, error) {
+	var keys []*MasterKey
+	if uris == "" {
+		return keys, nil
+	}
+	for _, s := range strings.Split(uris, ",") {
+		k, err := NewMasterKeyFromURI(s)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// Encrypt takes a SOPS data key, encrypts it with the PKCS#11 key, and stores
+// the result in the EncryptedKey field.
+func (key *MasterKey) Encrypt(dataKey []byte) error {
+	uri, err := ParseURI(key.URI)
+	if err != nil {
+		log.WithField("uri", key.URI).Info("Encryption failed")
+		return err
+	}
+	encryptedKey, err := encrypt(uri, dataKey)
+	if err != nil {
+		log.WithField("uri", key.URI).Info("Encryption failed")
+		return fmt.Errorf("failed to encrypt sops data key with PKCS#11 key '%s': %w", key.URI, err)
+	}
+	key.SetEncryptedDataKey([]byte(base64.StdEncoding.EncodeToString(encryptedKey)))
+	log.WithField("uri", key.URI).Info("Encryption succeeded")
+	return nil
+}
+
+// EncryptedDataKey returns the encrypted data key this master key holds.
+func (key *MasterKey) EncryptedDataKey() []byte {
+	return []byte(key.EncryptedKey)
+}
+
+// SetEncryptedDataKey sets the encrypted data key for this master key.
+func (key *MasterKey) SetEncryptedDataKey(enc []byte) {
+	key.EncryptedKey = string(enc)
+}
+
+// EncryptIfNeeded encrypts the provided SOPS data key, if it has not been
+// encrypted yet.
+func (key *MasterKey) EncryptIfNeeded(dataKey []byte) error {
+	if key.EncryptedKey == "" {
+		return key.Encrypt(dataKey)
+	}
+	return nil
+}
+
+// Decrypt decrypts the EncryptedKey field with the PKCS#11 key and returns
+// the result.
+func (key *MasterKey) Decrypt() ([]byte, error) {
+	uri, err := ParseURI(key.URI)
+	if err != nil {
+		log.WithField("uri", key.URI).Info("Decryption failed")
+		return nil, err
+	}
+	encryptedKey, err := base64.StdEncoding.DecodeString(key.EncryptedKey)
+	if err != nil {
+		log.WithField("uri", key.URI).Info("Decryption failed")
+		return nil, fmt.Errorf("failed to base64 decode PKCS#11 encrypted key: %w", err)
+	}
+	dataKey, err := decrypt(uri, encryptedKey)
+	if err != nil {
+		log.WithField("uri", key.URI).Info("Decryption failed")
+		return nil, fmt.Errorf("failed to decrypt sops data key with PKCS#11 key '%s': %w", key.URI, err)
+	}
+	log.WithField("uri", key.URI).Info("Decryption succeeded")
+	return dataKey, nil
+}
+
+// NeedsRotation returns whether the data key needs to be rotated or not.
+func (key *MasterKey) NeedsRotation() bool {
+	return time.Since(key.CreationDate) > (pkcs11TTL)
+}
+
+// ToString converts the key to a string representation.
+func (key *MasterKey) ToString() string {
+	return key.URI
+}
+
+// ToMap converts the MasterKey to a map for serialization purposes.
+func (key MasterKey) ToMap() map[string]interface{} {
+	out := make(map[string]interface{})
+	out["uri"] = key.URI
+	out["created_at"] = key.CreationDate.UTC().Format(time.RFC3339)
+	out["enc"] = key.EncryptedKey
+	return out
+}
+
+// TypeToIdentifier returns the string identifier for the MasterKey type.
+func (key *MasterKey) TypeToIdentifier() string {
+	return KeyTypeIdentifier
+}
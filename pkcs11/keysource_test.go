@@ -0,0 +1,211 @@
+package pkcs11
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	mockURI = "pkcs11:token=sops;object=master%20key;type=secret-key"
+)
+
+func TestParseURI(t *testing.T) {
+	tests := []struct {
+		name      string
+		uri       string
+		expectErr string
+		expectURI URI
+	}{
+		{
+			name: "token and object",
+			uri:  mockURI,
+			expectURI: URI{
+				Token:  "sops",
+				Object: "master key",
+				Type:   typeSecretKey,
+			},
+		},
+		{
+			name: "all attributes",
+			uri:  "pkcs11:manufacturer=SoftHSM%20project;model=SoftHSM%20v2;serial=1234;slot-id=2;id=%01%02;type=private",
+			expectURI: URI{
+				Manufacturer: "SoftHSM project",
+				Model:        "SoftHSM v2",
+				Serial:       "1234",
+				SlotID:       2,
+				HasSlotID:    true,
+				ID:           []byte{1, 2},
+				Type:         typePrivate,
+			},
+		},
+		{
+			name:      "wrong scheme",
+			uri:       "https://hsm/keys/foo",
+			expectErr: "must start with",
+		},
+		{
+			name:      "no key",
+			uri:       "pkcs11:token=sops",
+			expectErr: "must identify a key",
+		},
+		{
+			name:      "unknown attribute",
+			uri:       "pkcs11:object=foo;library-version=1",
+			expectErr: `unsupported attribute "library-version"`,
+		},
+		{
+			name:      "unknown query attribute",
+			uri:       "pkcs11:object=foo?module-name=softhsm2",
+			expectErr: `unsupported query attribute "module-name"`,
+		},
+		{
+			name:      "module-path",
+			uri:       "pkcs11:object=foo?module-path=/tmp/evil.so",
+			expectErr: "the module-path attribute is not allowed",
+		},
+		{
+			name:      "pin-source",
+			uri:       "pkcs11:object=foo?pin-source=file:/etc/shadow",
+			expectErr: "the pin-source attribute is not allowed",
+		},
+		{
+			name:      "unsupported type",
+			uri:       "pkcs11:object=foo;type=cert",
+			expectErr: "unsupported object type",
+		},
+		{
+			name:      "invalid slot-id",
+			uri:       "pkcs11:object=foo;slot-id=first",
+			expectErr: "invalid slot-id",
+		},
+		{
+			name:      "invalid escape",
+			uri:       "pkcs11:object=foo%zz",
+			expectErr: "invalid value",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uri, err := ParseURI(tt.uri)
+			if tt.expectErr != "" {
+				assert.ErrorContains(t, err, tt.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectURI, *uri)
+		})
+	}
+}
+
+func Test_pin(t *testing.T) {
+	t.Run("none", func(t *testing.T) {
+		t.Setenv(SopsPKCS11PinEnv, "")
+		t.Setenv(SopsPKCS11PinFileEnv, "")
+		got, err := pin()
+		assert.NoError(t, err)
+		assert.Empty(t, got)
+	})
+
+	t.Run("environment", func(t *testing.T) {
+		t.Setenv(SopsPKCS11PinEnv, "1234")
+		t.Setenv(SopsPKCS11PinFileEnv, "/nonexistent")
+		got, err := pin()
+		assert.NoError(t, err)
+		assert.Equal(t, "1234", got)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "pin")
+		require.NoError(t, os.WriteFile(path, []byte("5678\n"), 0o600))
+		t.Setenv(SopsPKCS11PinEnv, "")
+		t.Setenv(SopsPKCS11PinFileEnv, path)
+		got, err := pin()
+		assert.NoError(t, err)
+		assert.Equal(t, "5678", got)
+
+		t.Setenv(SopsPKCS11PinFileEnv, path+".missing")
+		_, err = pin()
+		assert.ErrorContains(t, err, "failed to read PKCS#11 PIN")
+	})
+}
+
+func Test_modulePath(t *testing.T) {
+	t.Setenv(SopsPKCS11ModuleEnv, "")
+	_, err := modulePath()
+	assert.ErrorContains(t, err, SopsPKCS11ModuleEnv)
+
+	t.Setenv(SopsPKCS11ModuleEnv, "/env/module.so")
+	path, err := modulePath()
+	assert.NoError(t, err)
+	assert.Equal(t, "/env/module.so", path)
+}
+
+func TestMasterKeysFromURIs(t *testing.T) {
+	keys, err := MasterKeysFromURIs("")
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+
+	keys, err = MasterKeysFromURIs(mockURI + ", pkcs11:object=other")
+	assert.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, mockURI, keys[0].URI)
+	assert.Equal(t, "pkcs11:object=other", keys[1].URI)
+	assert.NotZero(t, keys[0].CreationDate)
+
+	_, err = MasterKeysFromURIs(mockURI + ",pkcs11:token=sops")
+	assert.Error(t, err)
+
+	_, err = MasterKeysFromURIs("pkcs11:object=other?pin-value=1234")
+	assert.ErrorContains(t, err, "the pin-value attribute is not allowed")
+	assert.NotContains(t, err.Error(), "1234")
+}
+
+func TestMasterKey_EncryptedDataKey(t *testing.T) {
+	key := &MasterKey{EncryptedKey: "some key"}
+	assert.EqualValues(t, key.EncryptedKey, key.EncryptedDataKey())
+}
+
+func TestMasterKey_SetEncryptedDataKey(t *testing.T) {
+	encryptedKey := []byte("encrypted")
+	key := &MasterKey{}
+	key.SetEncryptedDataKey(encryptedKey)
+	assert.EqualValues(t, encryptedKey, key.EncryptedKey)
+}
+
+func TestMasterKey_EncryptIfNeeded(t *testing.T) {
+	key := &MasterKey{URI: mockURI, EncryptedKey: "encrypted"}
+	assert.NoError(t, key.EncryptIfNeeded([]byte("data")))
+	assert.Equal(t, "encrypted", key.EncryptedKey)
+}
+
+func TestMasterKey_NeedsRotation(t *testing.T) {
+	key, err := NewMasterKeyFromURI(mockURI)
+	require.NoError(t, err)
+	assert.False(t, key.NeedsRotation())
+
+	key.CreationDate = key.CreationDate.Add(-(pkcs11TTL + time.Second))
+	assert.True(t, key.NeedsRotation())
+}
+
+func TestMasterKey_ToString(t *testing.T) {
+	key := &MasterKey{URI: mockURI}
+	assert.Equal(t, mockURI, key.ToString())
+}
+
+func TestMasterKey_ToMap(t *testing.T) {
+	key := MasterKey{
+		URI:          mockURI,
+		EncryptedKey: "data",
+		CreationDate: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	assert.Equal(t, map[string]interface{}{
+		"uri":        mockURI,
+		"created_at": "2024-01-02T03:04:05Z",
+		"enc":        "data",
+	}, key.ToMap())
+}
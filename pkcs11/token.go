@@ -0,0 +1,252 @@
+//go:build cgo
+
+package pkcs11
+
+import (
+	"fmt"
+	"sync"
+
+	p11 "github.com/miekg/pkcs11"
+)
+
+var (
+	// modules holds the PKCS#11 modules loaded by the process, by path.
+	// Modules are initialized once and kept loaded, as C_Finalize would
+	// invalidate the sessions of concurrent operations.
+	modules   = map[string]*p11.Ctx{}
+	modulesMu sync.Mutex
+)
+
+// loadModule loads and initializes the PKCS#11 module at path.
+func loadModule(path string) (*p11.Ctx, error) {
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+	if ctx, ok := modules[path]; ok {
+		return ctx, nil
+	}
+	ctx := p11.New(path)
+	if ctx == nil {
+		return nil, fmt.Errorf("failed to load PKCS#11 module %q", path)
+	}
+	if err := ctx.Initialize(); err != nil && err != p11.Error(p11.CKR_CRYPTOKI_ALREADY_INITIALIZED) {
+		ctx.Destroy()
+		return nil, fmt.Errorf("failed to initialize PKCS#11 module %q: %w", path, err)
+	}
+	modules[path] = ctx
+	return ctx, nil
+}
+
+// session is an open session with the token identified by a URI.
+type session struct {
+	ctx    *p11.Ctx
+	handle p11.SessionHandle
+	uri    *URI
+}
+
+// openSession opens a session with the first token matching the URI, and
+// logs in as user if a PIN is configured.
+func openSession(uri *URI) (*session, error) {
+	path, err := modulePath()
+	if err != nil {
+		return nil, err
+	}
+	pin, err := pin()
+	if err != nil {
+		return nil, err
+	}
+	ctx, err := loadModule(path)
+	if err != nil {
+		return nil, err
+	}
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list PKCS#11 slots: %w", err)
+	}
+	for _, slot := range slots {
+		if uri.HasSlotID && slot != uri.SlotID {
+			continue
+		}
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get PKCS#11 token info of slot %d: %w", slot, err)
+		}
+		if !tokenMatches(uri, info) {
+			continue
+		}
+		handle, err := ctx.OpenSession(slot, p11.CKF_SERIAL_SESSION)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open PKCS#11 session on slot %d: %w", slot, err)
+		}
+		s := &session{ctx: ctx, handle: handle, uri: uri}
+		if pin != "" {
+			if err := ctx.Login(handle, p11.CKU_USER, pin); err != nil && err != p11.Error(p11.CKR_USER_ALREADY_LOGGED_IN) {
+				s.close()
+				return nil, fmt.Errorf("failed to log in to PKCS#11 token %q: %w", info.Label, err)
+			}
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("no PKCS#11 token matching the URI found in module %q", path)
+}
+
+// tokenMatches returns whether the token matches the token attributes of the
+// URI.
+func tokenMatches(uri *URI, info p11.TokenInfo) bool {
+	return (uri.Token == "" || uri.Token == info.Label) &&
+		(uri.Manufacturer == "" || uri.Manufacturer == info.ManufacturerID) &&
+		(uri.Model == "" || uri.Model == info.Model) &&
+		(uri.Serial == "" || uri.Serial == info.SerialNumber)
+}
+
+func (s *session) close() {
+	s.ctx.CloseSession(s.handle)
+}
+
+// findKey returns the key object of the given class identified by the URI.
+// It returns false if there is none, and an error if the URI is ambiguous.
+func (s *session) findKey(class uint) (p11.ObjectHandle, bool, error) {
+	template := []*p11.Attribute{p11.NewAttribute(p11.CKA_CLASS, class)}
+	if s.uri.Object != "" {
+		template = append(template, p11.NewAttribute(p11.CKA_LABEL, s.uri.Object))
+	}
+	if len(s.uri.ID) > 0 {
+		template = append(template, p11.NewAttribute(p11.CKA_ID, s.uri.ID))
+	}
+	if err := s.ctx.FindObjectsInit(s.handle, template); err != nil {
+		return 0, false, fmt.Errorf("failed to search PKCS#11 objects: %w", err)
+	}
+	objects, _, err := s.ctx.FindObjects(s.handle, 2)
+	if finalErr := s.ctx.FindObjectsFinal(s.handle); err == nil {
+		err = finalErr
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to search PKCS#11 objects: %w", err)
+	}
+	switch len(objects) {
+	case 0:
+		return 0, false, nil
+	case 1:
+		return objects[0], true, nil
+	default:
+		return 0, false, fmt.Errorf("the URI matches more than one PKCS#11 object")
+	}
+}
+
+// oaepMechanism is the RSA-OAEP mechanism with SHA-256 used for RSA keys.
+func oaepMechanism() []*p11.Mechanism {
+	params := p11.NewOAEPParams(p11.CKM_SHA256, p11.CKG_MGF1_SHA256, p11.CKZ_DATA_SPECIFIED, nil)
+	return []*p11.Mechanism{p11.NewMechanism(p11.CKM_RSA_PKCS_OAEP, params)}
+}
+
+// aesKeyWrapMechanism is the RFC 3394 key wrap mechanism used for AES keys.
+func aesKeyWrapMechanism() []*p11.Mechanism {
+	return []*p11.Mechanism{p11.NewMechanism(p11.CKM_AES_KEY_WRAP, nil)}
+}
+
+// dataKeyTemplate returns the template of the session object holding a data
+// key while it is wrapped or unwrapped.
+func dataKeyTemplate(value []byte) []*p11.Attribute {
+	template := []*p11.Attribute{
+		p11.NewAttribute(p11.CKA_CLASS, p11.CKO_SECRET_KEY),
+		p11.NewAttribute(p11.CKA_KEY_TYPE, p11.CKK_GENERIC_SECRET),
+		p11.NewAttribute(p11.CKA_TOKEN, false),
+		p11.NewAttribute(p11.CKA_SENSITIVE, false),
+		p11.NewAttribute(p11.CKA_EXTRACTABLE, true),
+	}
+	if value != nil {
+		template = append(template, p11.NewAttribute(p11.CKA_VALUE, value))
+	}
+	return template
+}
+
+// encrypt encrypts the data key with the AES or RSA key identified by the
+// URI.
+func encrypt(uri *URI, dataKey []byte) ([]byte, error) {
+	s, err := openSession(uri)
+	if err != nil {
+		return nil, err
+	}
+	defer s.close()
+
+	if uri.allowsSecretKey() {
+		wrappingKey, ok, err := s.findKey(p11.CKO_SECRET_KEY)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return s.wrap(wrappingKey, dataKey)
+		}
+	}
+	if uri.allowsKeyPair() {
+		publicKey, ok, err := s.findKey(p11.CKO_PUBLIC_KEY)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			if err := s.ctx.EncryptInit(s.handle, oaepMechanism(), publicKey); err != nil {
+				return nil, err
+			}
+			return s.ctx.Encrypt(s.handle, dataKey)
+		}
+	}
+	return nil, fmt.Errorf("no AES or RSA public key matching the URI found")
+}
+
+// decrypt decrypts the data key with the AES or RSA key identified by the
+// URI.
+func decrypt(uri *URI, encryptedKey []byte) ([]byte, error) {
+	s, err := openSession(uri)
+	if err != nil {
+		return nil, err
+	}
+	defer s.close()
+
+	if uri.allowsSecretKey() {
+		unwrappingKey, ok, err := s.findKey(p11.CKO_SECRET_KEY)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return s.unwrap(unwrappingKey, encryptedKey)
+		}
+	}
+	if uri.allowsKeyPair() {
+		privateKey, ok, err := s.findKey(p11.CKO_PRIVATE_KEY)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			if err := s.ctx.DecryptInit(s.handle, oaepMechanism(), privateKey); err != nil {
+				return nil, err
+			}
+			return s.ctx.Decrypt(s.handle, encryptedKey)
+		}
+	}
+	return nil, fmt.Errorf("no AES or RSA private key matching the URI found")
+}
+
+// wrap wraps the data key with the AES key, by importing it as a temporary
+// session object.
+func (s *session) wrap(wrappingKey p11.ObjectHandle, dataKey []byte) ([]byte, error) {
+	obj, err := s.ctx.CreateObject(s.handle, dataKeyTemplate(dataKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to import data key: %w", err)
+	}
+	defer s.ctx.DestroyObject(s.handle, obj)
+	return s.ctx.WrapKey(s.handle, aesKeyWrapMechanism(), wrappingKey, obj)
+}
+
+// unwrap unwraps the data key with the AES key into a temporary session
+// object, and returns its value.
+func (s *session) unwrap(unwrappingKey p11.ObjectHandle, encryptedKey []byte) ([]byte, error) {
+	obj, err := s.ctx.UnwrapKey(s.handle, aesKeyWrapMechanism(), unwrappingKey, encryptedKey, dataKeyTemplate(nil))
+	if err != nil {
+		return nil, err
+	}
+	defer s.ctx.DestroyObject(s.handle, obj)
+	attrs, err := s.ctx.GetAttributeValue(s.handle, obj, []*p11.Attribute{p11.NewAttribute(p11.CKA_VALUE, nil)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read unwrapped data key: %w", err)
+	}
+	return attrs[0].Value, nil
+}
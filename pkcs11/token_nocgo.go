@@ -0,0 +1,17 @@
+//go:build !cgo
+
+package pkcs11
+
+import "errors"
+
+// errNoCgo is returned when using a PKCS#11 key in a binary built without
+// cgo, which is required to load PKCS#11 modules.
+var errNoCgo = errors.New("PKCS#11 support requires a binary built with cgo")
+
+func encrypt(uri *URI, dataKey []byte) ([]byte, error) {
+	return nil, errNoCgo
+}
+
+func decrypt(uri *URI, encryptedKey []byte) ([]byte, error) {
+	return nil, errNoCgo
+}
@@ -0,0 +1,163 @@
+//go:build cgo
+
+package pkcs11
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	p11 "github.com/miekg/pkcs11"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	// softHSMModuleEnv can be set to the path of the SoftHSMv2 module used by
+	// the tests, if it is not installed in one of softHSMModulePaths.
+	softHSMModuleEnv = "SOFTHSM2_MODULE"
+	testTokenLabel   = "sops"
+	testPin          = "1234"
+)
+
+// softHSMModulePaths are the locations SoftHSMv2 is commonly installed to.
+var softHSMModulePaths = []string{
+	"/usr/lib/softhsm/libsofthsm2.so",
+	"/usr/lib/x86_64-linux-gnu/softhsm/libsofthsm2.so",
+	"/usr/lib64/pkcs11/libsofthsm2.so",
+	"/usr/local/lib/softhsm/libsofthsm2.so",
+	"/opt/homebrew/lib/softhsm/libsofthsm2.so",
+}
+
+// setupSoftHSM initializes a SoftHSMv2 token in a temporary directory holding
+// an AES key labeled "aes" and an RSA key pair labeled "rsa", and returns the
+// path of the module. The test is skipped if SoftHSMv2 is not installed.
+func setupSoftHSM(t *testing.T) string {
+	t.Helper()
+	module := os.Getenv(softHSMModuleEnv)
+	for _, path := range softHSMModulePaths {
+		if module != "" {
+			break
+		}
+		if _, err := os.Stat(path); err == nil {
+			module = path
+		}
+	}
+	if module == "" {
+		t.Skipf("SoftHSMv2 not found, set %s to run PKCS#11 tests", softHSMModuleEnv)
+	}
+
+	// SoftHSM reads its configuration when the module is initialized, which
+	// only happens once per process.
+	tokenDir := t.TempDir()
+	conf := filepath.Join(tokenDir, "softhsm2.conf")
+	require.NoError(t, os.WriteFile(conf, []byte("directories.tokendir = "+tokenDir+"\nobjectstore.backend = file\n"), 0o600))
+	t.Setenv("SOFTHSM2_CONF", conf)
+	ctx, err := loadModule(module)
+	require.NoError(t, err)
+
+	slots, err := ctx.GetSlotList(false)
+	require.NoError(t, err)
+	require.NotEmpty(t, slots)
+	require.NoError(t, ctx.InitToken(slots[len(slots)-1], testPin, testTokenLabel))
+
+	rw, err := ctx.OpenSession(slotOf(t, ctx, testTokenLabel), p11.CKF_SERIAL_SESSION|p11.CKF_RW_SESSION)
+	require.NoError(t, err)
+	defer ctx.CloseSession(rw)
+	require.NoError(t, ctx.Login(rw, p11.CKU_SO, testPin))
+	require.NoError(t, ctx.InitPIN(rw, testPin))
+	require.NoError(t, ctx.Logout(rw))
+	require.NoError(t, ctx.Login(rw, p11.CKU_USER, testPin))
+
+	_, err = ctx.GenerateKey(rw, []*p11.Mechanism{p11.NewMechanism(p11.CKM_AES_KEY_GEN, nil)}, []*p11.Attribute{
+		p11.NewAttribute(p11.CKA_TOKEN, true),
+		p11.NewAttribute(p11.CKA_LABEL, "aes"),
+		p11.NewAttribute(p11.CKA_VALUE_LEN, 32),
+		p11.NewAttribute(p11.CKA_WRAP, true),
+		p11.NewAttribute(p11.CKA_UNWRAP, true),
+	})
+	require.NoError(t, err)
+	_, _, err = ctx.GenerateKeyPair(rw, []*p11.Mechanism{p11.NewMechanism(p11.CKM_RSA_PKCS_KEY_PAIR_GEN, nil)}, []*p11.Attribute{
+		p11.NewAttribute(p11.CKA_TOKEN, true),
+		p11.NewAttribute(p11.CKA_LABEL, "rsa"),
+		p11.NewAttribute(p11.CKA_ENCRYPT, true),
+		p11.NewAttribute(p11.CKA_MODULUS_BITS, 2048),
+		p11.NewAttribute(p11.CKA_PUBLIC_EXPONENT, []byte{1, 0, 1}),
+	}, []*p11.Attribute{
+		p11.NewAttribute(p11.CKA_TOKEN, true),
+		p11.NewAttribute(p11.CKA_LABEL, "rsa"),
+		p11.NewAttribute(p11.CKA_DECRYPT, true),
+		p11.NewAttribute(p11.CKA_PRIVATE, true),
+	})
+	require.NoError(t, err)
+	return module
+}
+
+// slotOf returns the slot of the token with the given label.
+func slotOf(t *testing.T, ctx *p11.Ctx, label string) uint {
+	t.Helper()
+	slots, err := ctx.GetSlotList(true)
+	require.NoError(t, err)
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		require.NoError(t, err)
+		if info.Label == label {
+			return slot
+		}
+	}
+	t.Fatalf("no token labeled %q", label)
+	return 0
+}
+
+func TestSoftHSM(t *testing.T) {
+	module := setupSoftHSM(t)
+	dataKey := []byte("abcdefghijklmnopqrstuvwxyz123456")
+
+	t.Setenv(SopsPKCS11ModuleEnv, module)
+	t.Setenv(SopsPKCS11PinEnv, testPin)
+
+	for _, object := range []string{"aes", "rsa"} {
+		t.Run(object, func(t *testing.T) {
+			key, err := NewMasterKeyFromURI("pkcs11:token=" + testTokenLabel + ";object=" + object)
+			require.NoError(t, err)
+			require.NoError(t, key.Encrypt(dataKey))
+			assert.NotEmpty(t, key.EncryptedKey)
+
+			got, err := key.Decrypt()
+			require.NoError(t, err)
+			assert.Equal(t, dataKey, got)
+		})
+	}
+
+	t.Run("pin from file", func(t *testing.T) {
+		pinFile := filepath.Join(t.TempDir(), "pin")
+		require.NoError(t, os.WriteFile(pinFile, []byte(testPin+"\n"), 0o600))
+		t.Setenv(SopsPKCS11PinEnv, "")
+		t.Setenv(SopsPKCS11PinFileEnv, pinFile)
+		key, err := NewMasterKeyFromURI("pkcs11:token=" + testTokenLabel + ";object=aes")
+		require.NoError(t, err)
+		require.NoError(t, key.Encrypt(dataKey))
+		got, err := key.Decrypt()
+		require.NoError(t, err)
+		assert.Equal(t, dataKey, got)
+	})
+
+	t.Run("type mismatch", func(t *testing.T) {
+		key, err := NewMasterKeyFromURI("pkcs11:token=" + testTokenLabel + ";object=aes;type=public")
+		require.NoError(t, err)
+		assert.ErrorContains(t, key.Encrypt(dataKey), "no AES or RSA public key")
+	})
+
+	t.Run("unknown token", func(t *testing.T) {
+		key, err := NewMasterKeyFromURI("pkcs11:token=other;object=aes")
+		require.NoError(t, err)
+		assert.ErrorContains(t, key.Encrypt(dataKey), "no PKCS#11 token matching")
+	})
+
+	t.Run("wrong PIN", func(t *testing.T) {
+		t.Setenv(SopsPKCS11PinEnv, "0000")
+		key, err := NewMasterKeyFromURI("pkcs11:token=" + testTokenLabel + ";object=aes")
+		require.NoError(t, err)
+		assert.ErrorContains(t, key.Encrypt(dataKey), "failed to log in")
+	})
+}
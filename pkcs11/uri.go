@@ -0,0 +1,180 @@
+package pkcs11
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// uriScheme is the scheme of PKCS#11 URIs, as defined by RFC 7512.
+	uriScheme = "pkcs11:"
+
+	// Object types which can be used as the "type" attribute of a URI.
+	typeSecretKey = "secret-key"
+	typePublicKey = "public"
+	typePrivate   = "private"
+)
+
+// URI is a PKCS#11 URI (RFC 7512) identifying a key object on a token, e.g.
+// "pkcs11:token=sops;object=master-key".
+//
+// Only the attributes relevant to locating a key are supported. Unknown
+// attributes are rejected, so that a key is never silently matched on a
+// subset of what the user specified. URIs are stored in the metadata of
+// encrypted files and sent to key services, so they are not trusted: the
+// module-path, pin-value and pin-source query attributes, which would load a
+// shared library, disclose the PIN or read a local file, are rejected. The
+// module and PIN are configured with environment variables instead.
+type URI struct {
+	// Token is the label of the token holding the key.
+	Token string
+	// Manufacturer is the manufacturer ID of the token.
+	Manufacturer string
+	// Model is the model of the token.
+	Model string
+	// Serial is the serial number of the token.
+	Serial string
+	// SlotID is the ID of the slot holding the token, if HasSlotID is set.
+	SlotID    uint
+	HasSlotID bool
+	// Object is the label (CKA_LABEL) of the key object.
+	Object string
+	// ID is the CKA_ID of the key object.
+	ID []byte
+	// Type restricts the class of the key object: "secret-key" for AES
+	// key-wrap keys, "public" or "private" for RSA key pairs. If empty, both
+	// are looked up.
+	Type string
+}
+
+// localQueryAttributes maps the query attributes which must be configured
+// locally to the environment variable to use instead.
+var localQueryAttributes = map[string]string{
+	"module-path": SopsPKCS11ModuleEnv,
+	"pin-value":   SopsPKCS11PinEnv,
+	"pin-source":  SopsPKCS11PinFileEnv,
+}
+
+// ParseURI parses a PKCS#11 URI.
+func ParseURI(s string) (*URI, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, uriScheme) {
+		return nil, fmt.Errorf("invalid PKCS#11 URI %q: must start with %q", s, uriScheme)
+	}
+	path, query, _ := strings.Cut(strings.TrimPrefix(s, uriScheme), "?")
+
+	u := &URI{}
+	for _, attr := range splitAttributes(path, ";") {
+		name, value, err := parseAttribute(attr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PKCS#11 URI %q: %w", s, err)
+		}
+		switch name {
+		case "token":
+			u.Token = value
+		case "manufacturer":
+			u.Manufacturer = value
+		case "model":
+			u.Model = value
+		case "serial":
+			u.Serial = value
+		case "slot-id":
+			id, err := strconv.ParseUint(value, 10, 0)
+			if err != nil {
+				return nil, fmt.Errorf("invalid PKCS#11 URI %q: invalid slot-id %q", s, value)
+			}
+			u.SlotID, u.HasSlotID = uint(id), true
+		case "object":
+			u.Object = value
+		case "id":
+			u.ID = []byte(value)
+		case "type":
+			switch value {
+			case typeSecretKey, typePublicKey, typePrivate:
+				u.Type = value
+			default:
+				return nil, fmt.Errorf("invalid PKCS#11 URI %q: unsupported object type %q, must be one of %s, %s or %s", s, value, typeSecretKey, typePublicKey, typePrivate)
+			}
+		default:
+			return nil, fmt.Errorf("invalid PKCS#11 URI %q: unsupported attribute %q", s, name)
+		}
+	}
+	for _, attr := range splitAttributes(query, "&") {
+		// The query is not included in errors, as it may contain a PIN.
+		name, _, _ := strings.Cut(attr, "=")
+		if env, ok := localQueryAttributes[name]; ok {
+			return nil, fmt.Errorf("invalid PKCS#11 URI: the %s attribute is not allowed, as URIs are stored in encrypted files and sent to key services; set the %s environment variable instead", name, env)
+		}
+		return nil, fmt.Errorf("invalid PKCS#11 URI: unsupported query attribute %q", name)
+	}
+	if u.Object == "" && len(u.ID) == 0 {
+		return nil, fmt.Errorf("invalid PKCS#11 URI %q: must identify a key with an object or id attribute", s)
+	}
+	return u, nil
+}
+
+// splitAttributes splits a URI component into its attributes, ignoring empty
+// ones.
+func splitAttributes(s, sep string) []string {
+	var attrs []string
+	for _, attr := range strings.Split(s, sep) {
+		if attr != "" {
+			attrs = append(attrs, attr)
+		}
+	}
+	return attrs
+}
+
+// parseAttribute parses a "name=value" attribute, percent-decoding the value.
+func parseAttribute(attr string) (string, string, error) {
+	name, value, ok := strings.Cut(attr, "=")
+	if !ok {
+		return "", "", fmt.Errorf("attribute %q has no value", attr)
+	}
+	decoded, err := url.PathUnescape(value)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid value for attribute %q: %w", name, err)
+	}
+	return name, decoded, nil
+}
+
+// pin returns the user PIN given by the SopsPKCS11PinEnv environment
+// variable, or read from the file given by SopsPKCS11PinFileEnv. An empty PIN
+// means no login is performed.
+func pin() (string, error) {
+	if pin := os.Getenv(SopsPKCS11PinEnv); pin != "" {
+		return pin, nil
+	}
+	if path := os.Getenv(SopsPKCS11PinFileEnv); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read PKCS#11 PIN: %w", err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+	return "", nil
+}
+
+// modulePath returns the PKCS#11 module given by the SopsPKCS11ModuleEnv
+// environment variable.
+func modulePath() (string, error) {
+	if path := os.Getenv(SopsPKCS11ModuleEnv); path != "" {
+		return path, nil
+	}
+	return "", fmt.Errorf("no PKCS#11 module configured: set the %s environment variable", SopsPKCS11ModuleEnv)
+}
+
+// allowsSecretKey returns whether the URI may refer to an AES key-wrap key.
+func (u *URI) allowsSecretKey() bool {
+	return u.Type == "" || u.Type == typeSecretKey
+}
+
+// allowsKeyPair returns whether the URI may refer to an RSA key pair. Both
+// halves of the pair share the URI: the public key encrypts, the private key
+// decrypts.
+func (u *URI) allowsKeyPair() bool {
+	return u.Type != typeSecretKey
+}
@@ -660,6 +660,7 @@ func (tree *Tree) GenerateDataKeyWithKeyServices(svcs []keyservice.KeyServiceCli
 	if err != nil {
 		return nil, []error{fmt.Errorf("Could not generate random key: %s", err)}
 	}
+	tree.Metadata.DataKeyCreatedAt = time.Now().UTC()
 	return newKey, tree.Metadata.UpdateMasterKeysWithKeyServices(newKey, svcs)
 }
 
@@ -679,6 +680,9 @@ type Metadata struct {
 	// ShamirThreshold is the number of key groups required to recover the
 	// original data key
 	ShamirThreshold int
+	// DataKeyCreatedAt is the time the data key was generated, or the zero
+	// time if it is unknown
+	DataKeyCreatedAt time.Time
 	// DataKey caches the decrypted data key so it doesn't have to be decrypted with a master key every time it's needed
 	DataKey []byte
 }
@@ -802,6 +806,9 @@ func (m *Metadata) UpdateMasterKeysWithKeyServices(dataKey []byte, svcs []keyser
 					continue
 				}
 				key.SetEncryptedDataKey(rsp.Ciphertext)
+				if k, ok := key.(keys.VersionedMasterKey); ok && rsp.KeyVersion != "" {
+					k.SetKeyVersion(rsp.KeyVersion)
+				}
 				encrypted = true
 				// Only need to encrypt the key successfully with one service
 				break
@@ -990,11 +997,15 @@ func EmitAsMap(in TreeBranches) (map[string]interface{}, error) {
 			if _, ok := item.Key.(Comment); ok {
 				continue
 			}
+			key, ok := item.Key.(string)
+			if !ok {
+				return nil, fmt.Errorf("cannot use non-string keys in a map, got %T", item.Key)
+			}
 			val, err := encodeValueForMap(item.Value)
 			if err != nil {
 				return nil, err
 			}
-			data[item.Key.(string)] = val
+			data[key] = val
 		}
 	}
 
@@ -1005,6 +1016,19 @@ func encodeValueForMap(v interface{}) (interface{}, error) {
 	switch v := v.(type) {
 	case TreeBranch:
 		return EmitAsMap([]TreeBranch{v})
+	case []interface{}:
+		list := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			if _, ok := item.(Comment); ok {
+				continue
+			}
+			val, err := encodeValueForMap(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, val)
+		}
+		return list, nil
 	default:
 		return v, nil
 	}
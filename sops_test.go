@@ -2,15 +2,20 @@ package sops
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
 
 	"github.com/AetherVoxSanctum/envv-cli/v3/age"
+	"github.com/AetherVoxSanctum/envv-cli/v3/azkv"
+	"github.com/AetherVoxSanctum/envv-cli/v3/gcpkms"
 	"github.com/AetherVoxSanctum/envv-cli/v3/hcvault"
+	"github.com/AetherVoxSanctum/envv-cli/v3/keyservice"
 	"github.com/AetherVoxSanctum/envv-cli/v3/pgp"
 )
 
@@ -1509,6 +1514,12 @@ func TestEmitAsMap(t *testing.T) {
 				"baz": "foobar",
 			},
 		},
+		"list": []interface{}{
+			"item",
+			map[string]interface{}{
+				"key": "value",
+			},
+		},
 	}
 	branches := TreeBranches{
 		TreeBranch{
@@ -1540,6 +1551,19 @@ func TestEmitAsMap(t *testing.T) {
 					},
 				},
 			},
+			TreeItem{
+				Key: "list",
+				Value: []interface{}{
+					"item",
+					Comment{"comment"},
+					TreeBranch{
+						TreeItem{
+							Key:   "key",
+							Value: "value",
+						},
+					},
+				},
+			},
 		},
 	}
 
@@ -1606,3 +1630,33 @@ func TestSortKeyGroupIndices(t *testing.T) {
 		assert.Equal(t, expected, indices)
 	})
 }
+
+// versionKeyService is a key service returning a fixed ciphertext and key
+// version for every encrypt request.
+type versionKeyService struct {
+	version string
+}
+
+func (s versionKeyService) Encrypt(ctx context.Context, req *keyservice.EncryptRequest, opts ...grpc.CallOption) (*keyservice.EncryptResponse, error) {
+	return &keyservice.EncryptResponse{Ciphertext: []byte("ciphertext"), KeyVersion: s.version}, nil
+}
+
+func (s versionKeyService) Decrypt(ctx context.Context, req *keyservice.DecryptRequest, opts ...grpc.CallOption) (*keyservice.DecryptResponse, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (s versionKeyService) Rewrap(ctx context.Context, req *keyservice.RewrapRequest, opts ...grpc.CallOption) (*keyservice.RewrapResponse, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func TestUpdateMasterKeysWithKeyServicesRecordsKeyVersion(t *testing.T) {
+	gcpKmsKey := &gcpkms.MasterKey{ResourceID: "projects/p/locations/global/keyRings/r/cryptoKeys/k"}
+	azkvKey := &azkv.MasterKey{VaultURL: "https://vault.vault.azure.net", Name: "key"}
+	m := Metadata{KeyGroups: []KeyGroup{{gcpKmsKey, azkvKey}}}
+	errs := m.UpdateMasterKeysWithKeyServices([]byte("data key"), []keyservice.KeyServiceClient{versionKeyService{version: "4"}})
+	assert.Empty(t, errs)
+	assert.Equal(t, "ciphertext", gcpKmsKey.EncryptedKey)
+	assert.Equal(t, "4", gcpKmsKey.Version)
+	assert.Equal(t, "ciphertext", azkvKey.EncryptedKey)
+	assert.Equal(t, "4", azkvKey.Version)
+}
@@ -130,6 +130,22 @@ func tokenize(path string) []token {
 	return tokens
 }
 
+// SplitFlattenedKey splits a key of a map flattened by Flatten into the path
+// of the value in the original map, made of string keys and int list indices
+func SplitFlattenedKey(key string) []interface{} {
+	tokens := tokenize(key)
+	path := make([]interface{}, len(tokens))
+	for i, t := range tokens {
+		switch t := t.(type) {
+		case mapToken:
+			path[i] = t.key
+		case listToken:
+			path[i] = t.position
+		}
+	}
+	return path
+}
+
 // unflatten takes the currentNode, currentToken, nextToken and value
 // and populates currentNode such that currentToken can be considered
 // processed. It inspects nextToken to decide what type to allocate
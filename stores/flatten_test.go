@@ -140,6 +140,22 @@ func TestTokenizeNested(t *testing.T) {
 	assert.Equal(t, expected, tokenized)
 }
 
+func TestSplitFlattenedKey(t *testing.T) {
+	input := map[string]interface{}{
+		"foo": map[string]interface{}{
+			"bar": []interface{}{"a", map[string]interface{}{"baz": "b"}},
+		},
+	}
+	var paths [][]interface{}
+	for k := range Flatten(input) {
+		paths = append(paths, SplitFlattenedKey(k))
+	}
+	assert.ElementsMatch(t, [][]interface{}{
+		{"foo", "bar", 0},
+		{"foo", "bar", 1, "baz"},
+	}, paths)
+}
+
 func TestFlattenMetadata(t *testing.T) {
 	tests := []struct {
 		input Metadata
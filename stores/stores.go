@@ -21,6 +21,7 @@ import (
 	"github.com/AetherVoxSanctum/envv-cli/v3/hcvault"
 	"github.com/AetherVoxSanctum/envv-cli/v3/kms"
 	"github.com/AetherVoxSanctum/envv-cli/v3/pgp"
+	"github.com/AetherVoxSanctum/envv-cli/v3/pkcs11"
 )
 
 const (
@@ -49,7 +50,9 @@ type Metadata struct {
 	AzureKeyVaultKeys         []azkvkey   `yaml:"azure_kv,omitempty" json:"azure_kv,omitempty"`
 	VaultKeys                 []vaultkey  `yaml:"hc_vault,omitempty" json:"hc_vault,omitempty"`
 	AgeKeys                   []agekey    `yaml:"age,omitempty" json:"age,omitempty"`
+	PKCS11Keys                []pkcs11key `yaml:"pkcs11,omitempty" json:"pkcs11,omitempty"`
 	LastModified              string      `yaml:"lastmodified" json:"lastmodified"`
+	DataKeyCreatedAt          string      `yaml:"data_key_created_at,omitempty" json:"data_key_created_at,omitempty"`
 	MessageAuthenticationCode string      `yaml:"mac" json:"mac"`
 	PGPKeys                   []pgpkey    `yaml:"pgp,omitempty" json:"pgp,omitempty"`
 	UnencryptedSuffix         string      `yaml:"unencrypted_suffix,omitempty" json:"unencrypted_suffix,omitempty"`
@@ -69,6 +72,7 @@ type keygroup struct {
 	AzureKeyVaultKeys []azkvkey   `yaml:"azure_kv,omitempty" json:"azure_kv,omitempty"`
 	VaultKeys         []vaultkey  `yaml:"hc_vault" json:"hc_vault"`
 	AgeKeys           []agekey    `yaml:"age" json:"age"`
+	PKCS11Keys        []pkcs11key `yaml:"pkcs11,omitempty" json:"pkcs11,omitempty"`
 }
 
 type pgpkey struct {
@@ -84,10 +88,12 @@ type kmskey struct {
 	CreatedAt        string             `yaml:"created_at" json:"created_at"`
 	EncryptedDataKey string             `yaml:"enc" json:"enc"`
 	AwsProfile       string             `yaml:"aws_profile" json:"aws_profile"`
+	ReplicaRegions   []string           `yaml:"replica_regions,omitempty" json:"replica_regions,omitempty"`
 }
 
 type gcpkmskey struct {
 	ResourceID       string `yaml:"resource_id" json:"resource_id"`
+	Version          string `yaml:"version,omitempty" json:"version,omitempty"`
 	CreatedAt        string `yaml:"created_at" json:"created_at"`
 	EncryptedDataKey string `yaml:"enc" json:"enc"`
 }
@@ -96,6 +102,9 @@ type vaultkey struct {
 	VaultAddress     string `yaml:"vault_address" json:"vault_address"`
 	EnginePath       string `yaml:"engine_path" json:"engine_path"`
 	KeyName          string `yaml:"key_name" json:"key_name"`
+	AuthMethod       string `yaml:"auth_method,omitempty" json:"auth_method,omitempty"`
+	Role             string `yaml:"role,omitempty" json:"role,omitempty"`
+	Namespace        string `yaml:"namespace,omitempty" json:"namespace,omitempty"`
 	CreatedAt        string `yaml:"created_at" json:"created_at"`
 	EncryptedDataKey string `yaml:"enc" json:"enc"`
 }
@@ -113,10 +122,19 @@ type agekey struct {
 	EncryptedDataKey string `yaml:"enc" json:"enc"`
 }
 
+type pkcs11key struct {
+	URI              string `yaml:"uri" json:"uri"`
+	CreatedAt        string `yaml:"created_at" json:"created_at"`
+	EncryptedDataKey string `yaml:"enc" json:"enc"`
+}
+
 // MetadataFromInternal converts an internal SOPS metadata representation to a representation appropriate for storage
 func MetadataFromInternal(sopsMetadata sops.Metadata) Metadata {
 	var m Metadata
 	m.LastModified = sopsMetadata.LastModified.Format(time.RFC3339)
+	if !sopsMetadata.DataKeyCreatedAt.IsZero() {
+		m.DataKeyCreatedAt = sopsMetadata.DataKeyCreatedAt.Format(time.RFC3339)
+	}
 	m.UnencryptedSuffix = sopsMetadata.UnencryptedSuffix
 	m.EncryptedSuffix = sopsMetadata.EncryptedSuffix
 	m.UnencryptedRegex = sopsMetadata.UnencryptedRegex
@@ -135,6 +153,7 @@ func MetadataFromInternal(sopsMetadata sops.Metadata) Metadata {
 		m.VaultKeys = vaultKeysFromGroup(group)
 		m.AzureKeyVaultKeys = azkvKeysFromGroup(group)
 		m.AgeKeys = ageKeysFromGroup(group)
+		m.PKCS11Keys = pkcs11KeysFromGroup(group)
 	} else {
 		for _, group := range sopsMetadata.KeyGroups {
 			m.KeyGroups = append(m.KeyGroups, keygroup{
@@ -144,6 +163,7 @@ func MetadataFromInternal(sopsMetadata sops.Metadata) Metadata {
 				VaultKeys:         vaultKeysFromGroup(group),
 				AzureKeyVaultKeys: azkvKeysFromGroup(group),
 				AgeKeys:           ageKeysFromGroup(group),
+				PKCS11Keys:        pkcs11KeysFromGroup(group),
 			})
 		}
 	}
@@ -175,6 +195,7 @@ func kmsKeysFromGroup(group sops.KeyGroup) (keys []kmskey) {
 				Context:          key.EncryptionContext,
 				Role:             key.Role,
 				AwsProfile:       key.AwsProfile,
+				ReplicaRegions:   key.ReplicaRegions,
 			})
 		}
 	}
@@ -187,6 +208,7 @@ func gcpkmsKeysFromGroup(group sops.KeyGroup) (keys []gcpkmskey) {
 		case *gcpkms.MasterKey:
 			keys = append(keys, gcpkmskey{
 				ResourceID:       key.ResourceID,
+				Version:          key.Version,
 				CreatedAt:        key.CreationDate.Format(time.RFC3339),
 				EncryptedDataKey: key.EncryptedKey,
 			})
@@ -203,6 +225,9 @@ func vaultKeysFromGroup(group sops.KeyGroup) (keys []vaultkey) {
 				VaultAddress:     key.VaultAddress,
 				EnginePath:       key.EnginePath,
 				KeyName:          key.KeyName,
+				AuthMethod:       key.AuthMethod,
+				Role:             key.Role,
+				Namespace:        key.Namespace,
 				CreatedAt:        key.CreationDate.Format(time.RFC3339),
 				EncryptedDataKey: key.EncryptedKey,
 			})
@@ -240,12 +265,32 @@ func ageKeysFromGroup(group sops.KeyGroup) (keys []agekey) {
 	return
 }
 
+func pkcs11KeysFromGroup(group sops.KeyGroup) (keys []pkcs11key) {
+	for _, key := range group {
+		switch key := key.(type) {
+		case *pkcs11.MasterKey:
+			keys = append(keys, pkcs11key{
+				URI:              key.URI,
+				CreatedAt:        key.CreationDate.Format(time.RFC3339),
+				EncryptedDataKey: key.EncryptedKey,
+			})
+		}
+	}
+	return
+}
+
 // ToInternal converts a storage-appropriate Metadata struct to a SOPS internal representation
 func (m *Metadata) ToInternal() (sops.Metadata, error) {
 	lastModified, err := time.Parse(time.RFC3339, m.LastModified)
 	if err != nil {
 		return sops.Metadata{}, err
 	}
+	var dataKeyCreatedAt time.Time
+	if m.DataKeyCreatedAt != "" {
+		if dataKeyCreatedAt, err = time.Parse(time.RFC3339, m.DataKeyCreatedAt); err != nil {
+			return sops.Metadata{}, err
+		}
+	}
 	groups, err := m.internalKeygroups()
 	if err != nil {
 		return sops.Metadata{}, err
@@ -291,10 +336,11 @@ func (m *Metadata) ToInternal() (sops.Metadata, error) {
 		EncryptedCommentRegex:     m.EncryptedCommentRegex,
 		MACOnlyEncrypted:          m.MACOnlyEncrypted,
 		LastModified:              lastModified,
+		DataKeyCreatedAt:          dataKeyCreatedAt,
 	}, nil
 }
 
-func internalGroupFrom(kmsKeys []kmskey, pgpKeys []pgpkey, gcpKmsKeys []gcpkmskey, azkvKeys []azkvkey, vaultKeys []vaultkey, ageKeys []agekey) (sops.KeyGroup, error) {
+func internalGroupFrom(kmsKeys []kmskey, pgpKeys []pgpkey, gcpKmsKeys []gcpkmskey, azkvKeys []azkvkey, vaultKeys []vaultkey, ageKeys []agekey, pkcs11Keys []pkcs11key) (sops.KeyGroup, error) {
 	var internalGroup sops.KeyGroup
 	for _, kmsKey := range kmsKeys {
 		k, err := kmsKey.toInternal()
@@ -338,13 +384,20 @@ func internalGroupFrom(kmsKeys []kmskey, pgpKeys []pgpkey, gcpKmsKeys []gcpkmske
 		}
 		internalGroup = append(internalGroup, k)
 	}
+	for _, pkcs11Key := range pkcs11Keys {
+		k, err := pkcs11Key.toInternal()
+		if err != nil {
+			return nil, err
+		}
+		internalGroup = append(internalGroup, k)
+	}
 	return internalGroup, nil
 }
 
 func (m *Metadata) internalKeygroups() ([]sops.KeyGroup, error) {
 	var internalGroups []sops.KeyGroup
-	if len(m.PGPKeys) > 0 || len(m.KMSKeys) > 0 || len(m.GCPKMSKeys) > 0 || len(m.AzureKeyVaultKeys) > 0 || len(m.VaultKeys) > 0 || len(m.AgeKeys) > 0 {
-		internalGroup, err := internalGroupFrom(m.KMSKeys, m.PGPKeys, m.GCPKMSKeys, m.AzureKeyVaultKeys, m.VaultKeys, m.AgeKeys)
+	if len(m.PGPKeys) > 0 || len(m.KMSKeys) > 0 || len(m.GCPKMSKeys) > 0 || len(m.AzureKeyVaultKeys) > 0 || len(m.VaultKeys) > 0 || len(m.AgeKeys) > 0 || len(m.PKCS11Keys) > 0 {
+		internalGroup, err := internalGroupFrom(m.KMSKeys, m.PGPKeys, m.GCPKMSKeys, m.AzureKeyVaultKeys, m.VaultKeys, m.AgeKeys, m.PKCS11Keys)
 		if err != nil {
 			return nil, err
 		}
@@ -352,7 +405,7 @@ func (m *Metadata) internalKeygroups() ([]sops.KeyGroup, error) {
 		return internalGroups, nil
 	} else if len(m.KeyGroups) > 0 {
 		for _, group := range m.KeyGroups {
-			internalGroup, err := internalGroupFrom(group.KMSKeys, group.PGPKeys, group.GCPKMSKeys, group.AzureKeyVaultKeys, group.VaultKeys, group.AgeKeys)
+			internalGroup, err := internalGroupFrom(group.KMSKeys, group.PGPKeys, group.GCPKMSKeys, group.AzureKeyVaultKeys, group.VaultKeys, group.AgeKeys, group.PKCS11Keys)
 			if err != nil {
 				return nil, err
 			}
@@ -376,6 +429,7 @@ func (kmsKey *kmskey) toInternal() (*kms.MasterKey, error) {
 		CreationDate:      creationDate,
 		Arn:               kmsKey.Arn,
 		AwsProfile:        kmsKey.AwsProfile,
+		ReplicaRegions:    kmsKey.ReplicaRegions,
 	}, nil
 }
 
@@ -386,6 +440,7 @@ func (gcpKmsKey *gcpkmskey) toInternal() (*gcpkms.MasterKey, error) {
 	}
 	return &gcpkms.MasterKey{
 		ResourceID:   gcpKmsKey.ResourceID,
+		Version:      gcpKmsKey.Version,
 		EncryptedKey: gcpKmsKey.EncryptedDataKey,
 		CreationDate: creationDate,
 	}, nil
@@ -414,6 +469,9 @@ func (vaultKey *vaultkey) toInternal() (*hcvault.MasterKey, error) {
 		VaultAddress: vaultKey.VaultAddress,
 		EnginePath:   vaultKey.EnginePath,
 		KeyName:      vaultKey.KeyName,
+		AuthMethod:   vaultKey.AuthMethod,
+		Role:         vaultKey.Role,
+		Namespace:    vaultKey.Namespace,
 		CreationDate: creationDate,
 		EncryptedKey: vaultKey.EncryptedDataKey,
 	}, nil
@@ -533,3 +591,15 @@ func HasSopsTopLevelKey(branch sops.TreeBranch) bool {
 	}
 	return false
 }
+
+func (pkcs11Key *pkcs11key) toInternal() (*pkcs11.MasterKey, error) {
+	creationDate, err := time.Parse(time.RFC3339, pkcs11Key.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &pkcs11.MasterKey{
+		URI:          pkcs11Key.URI,
+		EncryptedKey: pkcs11Key.EncryptedDataKey,
+		CreationDate: creationDate,
+	}, nil
+}